@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alexflint/go-arg"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ownersManifestVersion is increased on incompatible manifest changes
+const ownersManifestVersion = 1
+
+// ownersManifestSuffix names the manifest written next to the output directory
+const ownersManifestSuffix = ".owners.json"
+
+// OwnersManifest records the ownership of extracted files, so it can be
+// applied later by someone allowed to chown (--defer-owners)
+type OwnersManifest struct {
+	Version int          `json:"version"`
+	Image   string       `json:"image"`
+	Entries []OwnerEntry `json:"entries"`
+}
+
+// OwnerEntry holds the attributes of one extracted file. The path is
+// relative to the output directory.
+type OwnerEntry struct {
+	Path    string     `json:"path"`
+	UID     int        `json:"uid"`
+	GID     int        `json:"gid"`
+	Mode    string     `json:"mode"`
+	ModTime *time.Time `json:"modTime,omitempty"`
+}
+
+// ApplyOwnersArgs are the arguments of the apply-owners command
+type ApplyOwnersArgs struct {
+	Manifest string `arg:"positional,required" help:"manifest written by --defer-owners, e.g. extracted.owners.json"`
+	Dir      string `arg:"--dir" help:"directory the files were extracted to [default: the manifest name without .owners.json]"`
+	NoModes  bool   `arg:"--no-modes" help:"only apply the ownership, not the modes and modification times"`
+}
+
+func (ApplyOwnersArgs) Description() string {
+	return "Applies the ownership recorded by --defer-owners to the extracted files (run it as root, e.g. with sudo)"
+}
+
+// ownersManifestPath returns the manifest name for an output directory
+func ownersManifestPath(outputDir string) string {
+	return filepath.Clean(outputDir) + ownersManifestSuffix
+}
+
+// writeOwnersManifest records the ownership of the extracted files next to
+// the output directory instead of applying it
+func writeOwnersManifest(files []FileInfo, args Args) (string, error) {
+	manifest := OwnersManifest{Version: ownersManifestVersion, Image: args.Image1, Entries: []OwnerEntry{}}
+	for _, file := range files {
+		destPath := getDestPath(file.Path, args.StripComponents)
+		if destPath == "" {
+			continue
+		}
+		uid, err := fileUID(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Could not extract UID from %q: %v\n", file.User, err)
+			continue
+		}
+		gid, err := fileGID(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Could not extract GID from %q: %v\n", file.Group, err)
+			continue
+		}
+		manifest.Entries = append(manifest.Entries, OwnerEntry{
+			Path:    strings.TrimPrefix(destPath, "/"),
+			UID:     args.owners.uid(uid),
+			GID:     args.owners.gid(gid),
+			Mode:    file.Mode,
+			ModTime: file.ModTime,
+		})
+	}
+
+	path := ownersManifestPath(args.OutputDir)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return "", fmt.Errorf("failed to write owners manifest: %v", err)
+	}
+	return path, nil
+}
+
+// runApplyOwners implements "docker-inspector apply-owners MANIFEST"
+func runApplyOwners(cmdArgs []string) {
+	var applyArgs ApplyOwnersArgs
+	parser, err := arg.NewParser(arg.Config{Program: "docker-inspector apply-owners"}, &applyArgs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	parser.MustParse(cmdArgs)
+	if applyArgs.Dir == "" {
+		if !strings.HasSuffix(applyArgs.Manifest, ownersManifestSuffix) {
+			parser.Fail("--dir is required for manifests not named DIR" + ownersManifestSuffix)
+		}
+		applyArgs.Dir = strings.TrimSuffix(applyArgs.Manifest, ownersManifestSuffix)
+	}
+
+	data, err := os.ReadFile(applyArgs.Manifest)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	var manifest OwnersManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to parse %s: %v\n", applyArgs.Manifest, err)
+		os.Exit(1)
+	}
+	if manifest.Version > ownersManifestVersion {
+		fmt.Fprintf(os.Stderr, "Error: %s has version %d, this docker-inspector supports up to %d\n",
+			applyArgs.Manifest, manifest.Version, ownersManifestVersion)
+		os.Exit(1)
+	}
+
+	// The entries are checked against the real directory, it may be a
+	// symlink itself (like /tmp on macOS)
+	root, err := filepath.EvalSymlinks(applyArgs.Dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	failed := 0
+	// Deeper paths first, so directory times are not changed by their contents
+	for i := len(manifest.Entries) - 1; i >= 0; i-- {
+		if err := applyOwnerEntry(root, manifest.Entries[i], !applyArgs.NoModes); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			failed++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Applied ownership of %d files in %s, %d failed\n",
+		len(manifest.Entries)-failed, applyArgs.Dir, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// applyOwnerEntry sets owner, mode and modification time of one file in
+// the resolved directory root. This runs as root, so neither the manifest
+// nor symlinks in the tree may lead it to files outside of root.
+func applyOwnerEntry(root string, entry OwnerEntry, modes bool) error {
+	path := filepath.Join(root, filepath.FromSlash(entry.Path))
+	// Entries must not point outside of the directory
+	if rel, err := filepath.Rel(root, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("skipping %s outside of %s", entry.Path, root)
+	}
+	// Neither through symlinked parent directories
+	if !staysInside(root, filepath.Dir(path)) {
+		return fmt.Errorf("skipping %s, its directory leads outside of %s", entry.Path, root)
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Lchown(path, entry.UID, entry.GID); err != nil {
+		return err
+	}
+	// Symlinks have no mode or time of their own to set, chmod and chtimes
+	// would change the file they point to
+	if !modes || info.Mode()&fs.ModeSymlink != 0 {
+		return nil
+	}
+	if err := os.Chmod(path, fileMode(entry.Mode)); err != nil {
+		return err
+	}
+	if entry.ModTime != nil {
+		if err := os.Chtimes(path, *entry.ModTime, *entry.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileMode converts a mode string like "urwxr-xr-x" into the permission
+// and special bits os.Chmod takes
+func fileMode(mode string) fs.FileMode {
+	bits := permBits(mode)
+	fileMode := fs.FileMode(bits & 0777)
+	if bits&04000 != 0 {
+		fileMode |= fs.ModeSetuid
+	}
+	if bits&02000 != 0 {
+		fileMode |= fs.ModeSetgid
+	}
+	if bits&01000 != 0 {
+		fileMode |= fs.ModeSticky
+	}
+	return fileMode
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// isImageArchive reports whether image names an image archive written by
+// docker save or podman save instead of an image
+func isImageArchive(image string) bool {
+	if !strings.HasSuffix(image, ".tar") {
+		return false
+	}
+	info, err := os.Stat(image)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// archiveFile is the location of a file in the archive
+type archiveFile struct {
+	offset int64
+	size   int64
+	// link is the target of a symlink, docker save links layers it has
+	// written already
+	link string
+}
+
+// imageArchive is an archive written by docker save or podman save, the
+// files in it are read in place
+type imageArchive struct {
+	path  string
+	files map[string]archiveFile
+}
+
+// openImageArchive indexes the archive and finds the image in it: the
+// manifest.json of docker save, otherwise the index.json of an OCI archive
+// with the manifest of the platform
+func openImageArchive(file, platform string) (*localImage, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	archive := &imageArchive{path: file, files: make(map[string]archiveFile)}
+	// Without compression the data of the files follows their headers, so
+	// the position after a header is where its file starts
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", file, err)
+		}
+		name := path.Clean(header.Name)
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			offset, err := f.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, err
+			}
+			archive.files[name] = archiveFile{offset: offset, size: header.Size}
+		case tar.TypeSymlink:
+			archive.files[name] = archiveFile{link: path.Join(path.Dir(name), header.Linkname)}
+		}
+	}
+	return openLocalImage(file, archive.open, "", platform)
+}
+
+// open returns a reader of a file in the archive
+func (a *imageArchive) open(name string) (io.ReadCloser, error) {
+	name = path.Clean(name)
+	entry, ok := a.files[name]
+	for hops := 0; ok && entry.link != ""; hops++ {
+		if hops == 40 {
+			return nil, fmt.Errorf("too many links for %s", name)
+		}
+		entry, ok = a.files[entry.link]
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s not found in %s", name, a.path)
+	}
+	f, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(f, entry.offset, entry.size), f}, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// dockerHubAuthKey is the key Docker Hub credentials are stored under
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+var (
+	// registryUsername and registryPassword are the --username and the
+	// password read with --password-stdin
+	registryUsername string
+	registryPassword string
+	// credentialHelper is the --credential-helper to ask for credentials
+	credentialHelper string
+	// credentialRegistry is the only registry the explicit credentials
+	// above are sent to, the --registry or the registry of the first image
+	credentialRegistry string
+)
+
+// registryAuth are the credentials sent with a pull in the X-Registry-Auth
+// header
+type registryAuth struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+	ServerAddress string `json:"serveraddress,omitempty"`
+}
+
+// header encodes the credentials for the X-Registry-Auth header
+func (a registryAuth) header() (string, error) {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(data), nil
+}
+
+// imageRegistry returns the registry host of an image reference, which is
+// docker.io if the first component does not look like a host name
+func imageRegistry(image string) string {
+	first, _, ok := strings.Cut(image, "/")
+	if !ok || (!strings.ContainsAny(first, ".:") && first != "localhost") {
+		return "docker.io"
+	}
+	return first
+}
+
+// registryCredentials finds the credentials for pulling from registry like
+// the docker CLI does: the --username, a credential helper (--credential-helper,
+// credHelpers or credsStore of the docker config) or the auths of the docker
+// config written by "docker login". It returns false if there are none. The
+// explicit credentials are only used for their registry, so comparing with
+// an image of another registry does not hand them to it.
+func registryCredentials(registry string) (registryAuth, bool, error) {
+	serverAddress := registry
+	if registry == "docker.io" {
+		serverAddress = dockerHubAuthKey
+	}
+	if authHost(registry) == authHost(credentialRegistry) {
+		if registryUsername != "" {
+			return registryAuth{Username: registryUsername, Password: registryPassword, ServerAddress: serverAddress}, true, nil
+		}
+		if credentialHelper != "" {
+			return helperCredentials(credentialHelper, serverAddress)
+		}
+	}
+
+	dir, err := dockerConfigDir()
+	if err != nil {
+		return registryAuth{}, false, nil
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return registryAuth{}, false, nil
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth          string `json:"auth"`
+			IdentityToken string `json:"identitytoken"`
+		} `json:"auths"`
+		CredsStore  string            `json:"credsStore"`
+		CredHelpers map[string]string `json:"credHelpers"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return registryAuth{}, false, fmt.Errorf("failed to parse %s: %v", filepath.Join(dir, "config.json"), err)
+	}
+	if helper := config.CredHelpers[registry]; helper != "" {
+		return helperCredentials(helper, serverAddress)
+	}
+	if config.CredsStore != "" {
+		return helperCredentials(config.CredsStore, serverAddress)
+	}
+	for key, entry := range config.Auths {
+		if authHost(key) != authHost(serverAddress) {
+			continue
+		}
+		auth := registryAuth{IdentityToken: entry.IdentityToken, ServerAddress: serverAddress}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return registryAuth{}, false, fmt.Errorf("invalid credentials for %s in the docker config: %v", key, err)
+			}
+			auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+		}
+		return auth, true, nil
+	}
+	return registryAuth{}, false, nil
+}
+
+// authHost reduces the keys of the auths in the docker config, which may be
+// URLs, to the registry host
+func authHost(key string) string {
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	host, _, _ := strings.Cut(key, "/")
+	if host == "index.docker.io" || host == "registry-1.docker.io" {
+		return "docker.io"
+	}
+	return host
+}
+
+// helperCredentials asks the docker-credential-HELPER program for the
+// credentials of the server, like the docker CLI does for credential stores
+func helperCredentials(helper, serverAddress string) (registryAuth, bool, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverAddress)
+	output, err := cmd.Output()
+	if err != nil {
+		// Helpers report missing credentials like any other failure
+		if strings.Contains(string(output), "credentials not found") {
+			return registryAuth{}, false, nil
+		}
+		message := strings.TrimSpace(string(output))
+		if message == "" {
+			message = err.Error()
+		}
+		return registryAuth{}, false, fmt.Errorf("credential helper %s: %s", helper, message)
+	}
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(output, &creds); err != nil {
+		return registryAuth{}, false, fmt.Errorf("credential helper %s: %v", helper, err)
+	}
+	auth := registryAuth{Username: creds.Username, Password: creds.Secret, ServerAddress: serverAddress}
+	// A token is returned with this special user name
+	if creds.Username == "<token>" {
+		auth = registryAuth{IdentityToken: creds.Secret, ServerAddress: serverAddress}
+	}
+	return auth, true, nil
+}
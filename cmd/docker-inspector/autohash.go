@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// hashCandidates returns the regular files of both listings which can only
+// be told apart by their content: files with the same path and size and,
+// for rename detection, removed and added files of the same size
+func hashCandidates(files1, files2 []FileInfo, renames bool) ([]string, []string) {
+	regular1 := regularFiles(files1)
+	regular2 := regularFiles(files2)
+
+	// Sizes of the files that exist in only one of the listings
+	removedSizes := make(map[int64]bool)
+	addedSizes := make(map[int64]bool)
+	for path, file := range regular1 {
+		if _, ok := regular2[path]; !ok {
+			removedSizes[file.Size] = true
+		}
+	}
+	for path, file := range regular2 {
+		if _, ok := regular1[path]; !ok {
+			addedSizes[file.Size] = true
+		}
+	}
+
+	var paths1, paths2 []string
+	for _, file := range files1 {
+		if regular1[file.Path] == nil {
+			continue
+		}
+		if other, ok := regular2[file.Path]; ok && other.Size == file.Size || !ok && renames && addedSizes[file.Size] {
+			paths1 = append(paths1, file.Path)
+		}
+	}
+	for _, file := range files2 {
+		if regular2[file.Path] == nil {
+			continue
+		}
+		if other, ok := regular1[file.Path]; ok && other.Size == file.Size || !ok && renames && removedSizes[file.Size] {
+			paths2 = append(paths2, file.Path)
+		}
+	}
+	return paths1, paths2
+}
+
+// regularFiles indexes the non empty regular files by path
+func regularFiles(files []FileInfo) map[string]*FileInfo {
+	regular := make(map[string]*FileInfo)
+	for i, file := range files {
+		if file.Type == "file" && file.Size > 0 {
+			regular[file.Path] = &files[i]
+		}
+	}
+	return regular
+}
+
+// addHashes inspects just the given paths of image again with SHA256
+// checksums and adds them to files
+func addHashes(image string, args Args, paths []string, files []FileInfo) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	hashArgs := args
+	hashArgs.SHA256 = true
+	hashArgs.PathsFrom = "-"
+	hashArgs.pathList = paths
+	hashArgs.OutputDir = ""
+	output, err := runInspector(image, hashArgs)
+	if err != nil {
+		return err
+	}
+	var hashed []FileInfo
+	if err := json.Unmarshal(output, &hashed); err != nil {
+		return fmt.Errorf("failed to parse inspection results: %v", err)
+	}
+
+	byPath := make(map[string]FileInfo, len(hashed))
+	for _, file := range hashed {
+		byPath[file.Path] = file
+	}
+	for i := range files {
+		if file, ok := byPath[files[i].Path]; ok {
+			files[i].SHA256 = file.SHA256
+			files[i].HashSkipped = file.HashSkipped
+		}
+	}
+	return nil
+}
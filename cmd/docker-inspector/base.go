@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// baseNameLabel is the OCI annotation some builders set to the FROM image
+const baseNameLabel = "org.opencontainers.image.base.name"
+
+// imageDetails holds the parts of "docker image inspect" used to find the
+// base image and the platform of an image
+type imageDetails struct {
+	ID           string   `json:"Id"`
+	RepoTags     []string `json:"RepoTags"`
+	Os           string   `json:"Os"`
+	Architecture string   `json:"Architecture"`
+	Variant      string   `json:"Variant"`
+	Config       struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+	RootFS struct {
+		Layers []string `json:"Layers"`
+	} `json:"RootFS"`
+}
+
+// baseImage determines the image the given image was built FROM. The OCI
+// base name label is used when present, otherwise the local image whose
+// layers are the longest prefix of the image layers.
+func baseImage(image string) (string, error) {
+	details, err := inspectImages(image)
+	if err != nil {
+		return "", err
+	}
+	if len(details) != 1 {
+		return "", fmt.Errorf("failed to inspect image %s", image)
+	}
+	target := details[0]
+	if name := target.Config.Labels[baseNameLabel]; name != "" {
+		return name, nil
+	}
+
+	engine, err := containerEngine()
+	if err != nil {
+		return "", err
+	}
+	ids, err := engine.imageIDs()
+	if err != nil {
+		return "", fmt.Errorf("failed to list images: %v", err)
+	}
+	if len(ids) == 0 {
+		return "", fmt.Errorf("no local images found")
+	}
+	candidates, err := inspectImages(ids...)
+	if err != nil {
+		return "", err
+	}
+
+	var base *imageDetails
+	for i := range candidates {
+		layers := candidates[i].RootFS.Layers
+		if candidates[i].ID == target.ID || len(layers) == 0 || len(layers) >= len(target.RootFS.Layers) ||
+			!slices.Equal(layers, target.RootFS.Layers[:len(layers)]) {
+			continue
+		}
+		if base == nil || len(layers) > len(base.RootFS.Layers) {
+			base = &candidates[i]
+		}
+	}
+	if base == nil {
+		return "", fmt.Errorf("no local image found that %s was built on, pull the base image first", image)
+	}
+	if len(base.RepoTags) > 0 {
+		return base.RepoTags[0], nil
+	}
+	return base.ID, nil
+}
+
+func inspectImages(images ...string) ([]imageDetails, error) {
+	engine, err := containerEngine()
+	if err != nil {
+		return nil, err
+	}
+	details := make([]imageDetails, len(images))
+	for i, image := range images {
+		if details[i], err = engine.imageDetails(image); err != nil {
+			return nil, fmt.Errorf("failed to inspect image %s: %v", image, err)
+		}
+	}
+	return details, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// extractCacheVersion is increased on incompatible cache changes
+const extractCacheVersion = 2
+
+// extractCacheSuffix names the cache written next to the output directory
+const extractCacheSuffix = ".cache.json"
+
+// ExtractCache records which files an extraction of an image wrote, so
+// extracting the same image digest again can skip the unchanged ones
+// (--incremental)
+type ExtractCache struct {
+	Version     int    `json:"version"`
+	ImageDigest string `json:"imageDigest"`
+	CacheOptions
+	Entries []CacheEntry `json:"entries"`
+}
+
+// CacheOptions are the options that change where and how files are
+// extracted, a cache written with other options is not used
+type CacheOptions struct {
+	StripComponents     int    `json:"stripComponents"`
+	PreservePermissions bool   `json:"preservePerms"`
+	PreserveOwner       bool   `json:"preserveOwner"`
+	PreserveXattrs      bool   `json:"preserveXattrs"`
+	PreserveTimes       bool   `json:"preserveTimes"`
+	OwnerMap            string `json:"ownerMap,omitempty"`
+}
+
+// cacheOptions returns the options of the extraction to record in the cache
+func cacheOptions(args Args) CacheOptions {
+	return CacheOptions{
+		StripComponents:     args.StripComponents,
+		PreservePermissions: args.PreservePermissions,
+		PreserveOwner:       args.PreserveOwner,
+		PreserveXattrs:      args.PreserveXattrs,
+		PreserveTimes:       args.PreserveTimes,
+		OwnerMap:            args.OwnerMap,
+	}
+}
+
+// CacheEntry holds a file of the image and the size and modification time
+// of its extracted copy, which show whether the copy was changed since
+type CacheEntry struct {
+	Path    string    `json:"path"`
+	Dest    string    `json:"dest"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// extractCachePath returns the cache name for an output directory
+func extractCachePath(outputDir string) string {
+	return filepath.Clean(outputDir) + extractCacheSuffix
+}
+
+// unchangedFiles returns the paths of the image that were extracted from
+// the same image digest before and whose copies are still untouched. A
+// missing or outdated cache, or one of other extraction options, means
+// everything is extracted.
+func unchangedFiles(digest string, args Args) []string {
+	data, err := os.ReadFile(extractCachePath(args.OutputDir))
+	if err != nil {
+		return nil
+	}
+	var cache ExtractCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Ignoring invalid extraction cache: %v\n", err)
+		return nil
+	}
+	if cache.Version != extractCacheVersion || cache.ImageDigest != digest || cache.CacheOptions != cacheOptions(args) {
+		return nil
+	}
+	var paths []string
+	for _, entry := range cache.Entries {
+		info, err := os.Lstat(filepath.Join(args.OutputDir, filepath.FromSlash(entry.Dest)))
+		if err != nil || info.Size() != entry.Size || !info.ModTime().Equal(entry.ModTime) {
+			continue
+		}
+		paths = append(paths, entry.Path)
+	}
+	return paths
+}
+
+// writeExtractCache records the extracted files of the image with the
+// current state of their copies. Without digest (the image was pulled by
+// the inspection) it is asked for now.
+func writeExtractCache(files []FileInfo, digest string, args Args) error {
+	if digest == "" {
+		var err error
+		if digest, err = imageDigest(args.Image1); err != nil {
+			return err
+		}
+	}
+	cache := ExtractCache{
+		Version:      extractCacheVersion,
+		ImageDigest:  digest,
+		CacheOptions: cacheOptions(args),
+		Entries:      []CacheEntry{},
+	}
+	for _, file := range files {
+		destPath := getDestPath(file.Path, args.StripComponents)
+		if destPath == "" || file.IsDir {
+			continue
+		}
+		// Files that failed to extract are not recorded, so they are
+		// tried again next time
+		info, err := os.Lstat(filepath.Join(args.OutputDir, destPath))
+		if err != nil || !sameType(file, info) {
+			continue
+		}
+		cache.Entries = append(cache.Entries, CacheEntry{
+			Path:    file.Path,
+			Dest:    strings.TrimPrefix(destPath, "/"),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(extractCachePath(args.OutputDir), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write extraction cache: %v", err)
+	}
+	return nil
+}
+
+// sameType reports whether the extracted copy is of the listed file's kind
+func sameType(file FileInfo, info os.FileInfo) bool {
+	if file.SymlinkTo != "" {
+		return info.Mode()&os.ModeSymlink != 0
+	}
+	return info.Mode().IsRegular() && info.Size() == file.Size
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+)
+
+// maxCatSymlinks limits how many symlinks --cat follows, like the kernel
+// does for loops
+const maxCatSymlinks = 40
+
+// catFile streams the contents of one file of the image to w. Symlinks are
+// followed inside the image, so /etc/os-release works on distributions
+// linking it to /usr/lib/os-release.
+func catFile(w io.Writer, image string, args Args) error {
+	name := path.Clean("/" + args.Cat)
+	for i := 0; i <= maxCatSymlinks; i++ {
+		target, err := catEntry(w, image, name, args)
+		if err != nil || target == "" {
+			return err
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(name), target)
+		}
+		name = path.Clean(target)
+	}
+	return fmt.Errorf("too many levels of symlinks at %s", args.Cat)
+}
+
+// catEntry streams the file name of the image to w, or returns the target
+// if it is a symlink. The filters of the listing do not apply.
+func catEntry(w io.Writer, image, name string, args Args) (string, error) {
+	catArgs := Args{RuntimeArgs: args.RuntimeArgs, Keep: args.Keep, Tar: "-", PathsFrom: "-", pathList: []string{name}}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(streamInspector(image, catArgs, pw))
+	}()
+	// Drain the rest, so the inspector does not block on a full pipe
+	defer io.Copy(io.Discard, pr)
+
+	tr := tar.NewReader(pr)
+	header, err := tr.Next()
+	if err == io.EOF {
+		return "", fmt.Errorf("%s not found in %s", name, image)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", name, err)
+	}
+	switch header.Typeflag {
+	case tar.TypeReg:
+		if _, err := io.Copy(w, tr); err != nil {
+			return "", fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		return "", nil
+	case tar.TypeSymlink:
+		return header.Linkname, nil
+	case tar.TypeDir:
+		return "", fmt.Errorf("%s is a directory", name)
+	default:
+		return "", fmt.Errorf("%s is not a regular file", name)
+	}
+}
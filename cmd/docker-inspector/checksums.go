@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Digests of empty content, the inspector does not hash empty files
+const (
+	emptyMD5    = "d41d8cd98f00b204e9800998ecf8427e"
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// printChecksums writes a manifest in the format of sha256sum/md5sum. Paths
+// are relative and honor --strip-components, so the manifest can be checked
+// with "sha256sum -c" inside of an extracted tree.
+func printChecksums(w io.Writer, files []FileInfo, args Args) error {
+	for _, file := range files {
+		digest, empty := file.SHA256, emptySHA256
+		if !args.SHA256 {
+			digest, empty = file.MD5, emptyMD5
+		}
+		if digest == "" && file.Type == "file" && file.Size == 0 {
+			digest = empty
+		}
+		if !isChecksum(digest) {
+			continue
+		}
+		destPath := getDestPath(file.Path, args.StripComponents)
+		if destPath == "" {
+			continue
+		}
+		name := strings.TrimPrefix(destPath, "/")
+
+		// GNU coreutils marks lines with escaped file names with a backslash
+		prefix := ""
+		if strings.ContainsAny(name, "\\\n\r") {
+			prefix = "\\"
+			name = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r").Replace(name)
+		}
+		if _, err := fmt.Fprintf(w, "%s%s  %s\n", prefix, digest, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// compareSide is one side of a comparison
+type compareSide struct {
+	// name identifies the side in reports
+	name string
+	// image is inspected again for checksums and file contents, it is
+	// empty for sides that were loaded from a file
+	image string
+	// root is a local directory inspected in a container of image
+	root  string
+	files []FileInfo
+}
+
+// inspectArgs returns args for inspecting this side again, its messages
+// are prefixed with the name as both sides are inspected concurrently
+func (s compareSide) inspectArgs(args Args) Args {
+	args.root = s.root
+	return withPrefix(args, s.name)
+}
+
+// runComparison compares old with new, writes the results in the selected
+// formats and returns the exit status, which is 1 if differences were found
+func runComparison(old, new compareSide, args Args, out *outputFile) int {
+	files1 := withoutDiffIgnored(old.files, args.DiffIgnores)
+	files2 := withoutDiffIgnored(new.files, args.DiffIgnores)
+
+	// Files with the same size are hashed in a second pass, so content
+	// changes are found without hashing everything
+	if !args.MD5 && !args.SHA256 && !args.NoAutoHash && old.image != "" && new.image != "" {
+		paths1, paths2 := hashCandidates(files1, files2, !args.NoRenames)
+		err := runConcurrently(
+			func() error { return addHashes(old.image, old.inspectArgs(args), paths1, files1) },
+			func() error { return addHashes(new.image, new.inspectArgs(args), paths2, files2) })
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Hashing failed: %v\n", err)
+			return 1
+		}
+	}
+
+	// Compare the results
+	opts := compareOptions(args)
+	result, err := Compare(files1, files2, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error comparing images: %v\n", err)
+		return 1
+	}
+	if !args.NoRenames {
+		DetectRenames(result, opts)
+	}
+	if len(args.only) > 0 {
+		FilterChanges(result, args.only)
+	}
+	for _, diff := range result.Differences {
+		result.Summary.SizeDelta += contentSize(diff.NewFile) - contentSize(diff.OldFile)
+	}
+	rollupDirectories(result, args.RollupDepth)
+	rollupExtensions(result)
+	if args.Growth > 0 {
+		result.Growth = growthReport(files1, files2, result, args.Growth, max(args.RollupDepth, 1))
+	}
+	// Content diffs are not fetched when only the summary is shown
+	contentDiff := args.ContentDiff && !args.SummaryOnly
+	if contentDiff && (old.image == "" || new.image == "") {
+		fmt.Fprintf(os.Stderr, "Warning: --content-diff needs the file contents of both sides\n")
+	} else if contentDiff {
+		if err := addContentDiffs(result, args, old, new); err != nil {
+			fmt.Fprintf(os.Stderr, "Error fetching file contents: %v\n", err)
+			return 1
+		}
+	}
+
+	if args.SQLite != "" {
+		listings := [][]FileInfo{files1, files2}
+		if err := writeSQLite(args.SQLite, []string{old.name, new.name}, listings, result); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing SQLite database: %v\n", err)
+			return 1
+		}
+	}
+
+	if args.HTML != "" {
+		title := fmt.Sprintf("Comparison of %s and %s", old.name, new.name)
+		if err := writeHTML(args.HTML, title, nil, result); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	// Output the comparison results
+	// JSON is complete unless it is limited explicitly, the summary always
+	// counts all differences
+	limited := *result
+	if args.MaxDiffsJSON && args.MaxDiffs > 0 && len(result.Differences) > args.MaxDiffs {
+		limited.Differences = result.Differences[:args.MaxDiffs]
+		limited.Truncated = len(result.Differences) - args.MaxDiffs
+	}
+	if args.SummaryOnly {
+		limited.Differences = nil
+		limited.Truncated = 0
+	}
+	switch args.Format {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(limited)
+	case "ndjson":
+		encoder := json.NewEncoder(out)
+		if args.SummaryOnly {
+			encoder.Encode(result.Summary)
+		}
+		for _, diff := range limited.Differences {
+			encoder.Encode(diff)
+		}
+	case "porcelain":
+		printPorcelain(out, &limited)
+	default:
+		printDiffText(out, result, args)
+	}
+	closeOutput(out)
+
+	if failsPolicy(result, args.FailOn) {
+		return 1
+	}
+	return 0
+}
+
+// failsPolicy reports whether the differences fail the run according to
+// --fail-on, by default any difference does
+func failsPolicy(result *Result, failOn []string) bool {
+	if len(failOn) == 0 {
+		return result.Summary.TotalDifferences > 0
+	}
+	for _, diff := range result.Differences {
+		for _, policy := range failOn {
+			switch policy {
+			case "content":
+				if diff.Type == Modified && contentChanged(diff.Changes) {
+					return true
+				}
+			case string(diff.Type):
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compareOptions returns the comparison options selected by the flags
+func compareOptions(args Args) Options {
+	mode := CompareAll
+	if args.NoTimes {
+		mode |= CompareNoTimes
+	}
+	if args.IgnoreOwnership {
+		mode |= CompareNoOwnership
+	}
+	if args.IgnorePerms {
+		mode |= CompareNoPerms
+	}
+	if args.IgnoreSize {
+		mode |= CompareNoSize
+	}
+	opts := Options{Mode: mode, TimeTolerance: args.TimeTolerance}
+	switch args.CompareOwners {
+	case "ids":
+		opts.Owners = OwnersByID
+	case "names":
+		opts.Owners = OwnersByName
+	}
+	return opts
+}
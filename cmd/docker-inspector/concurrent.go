@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// runConcurrently runs the tasks in parallel and returns the first error
+func runConcurrently(tasks ...func() error) error {
+	errs := make([]error, len(tasks))
+	var wg sync.WaitGroup
+	for i, task := range tasks {
+		i, task := i, task
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = task()
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSequentially runs the tasks one after another, it stops at the first error
+func runSequentially(tasks ...func() error) error {
+	for _, task := range tasks {
+		if err := task(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inspectFiles runs the inspector on image and parses the listing
+func inspectFiles(image string, args Args) ([]FileInfo, error) {
+	output, err := runInspector(image, args)
+	if err != nil {
+		return nil, err
+	}
+	var files []FileInfo
+	if err := json.Unmarshal(output, &files); err != nil {
+		return nil, fmt.Errorf("failed to parse inspection results: %v", err)
+	}
+	return files, nil
+}
+
+// stderrMutex keeps the lines of concurrent inspections apart
+var stderrMutex sync.Mutex
+
+// prefixWriter writes complete lines with a prefix to stderr, so the
+// messages of concurrent inspections do not get mixed up
+type prefixWriter struct {
+	prefix string
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.writeLine(w.buf[:i+1])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes an incomplete last line
+func (w *prefixWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.writeLine(append(w.buf, '\n'))
+		w.buf = nil
+	}
+}
+
+func (w *prefixWriter) writeLine(line []byte) {
+	stderrMutex.Lock()
+	defer stderrMutex.Unlock()
+	io.WriteString(os.Stderr, w.prefix)
+	os.Stderr.Write(line)
+}
+
+// withPrefix returns args that prefix the messages of the inspector with
+// name, for inspections running concurrently
+func withPrefix(args Args, name string) Args {
+	args.stderr = &prefixWriter{prefix: "[" + name + "] "}
+	return args
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"sync"
+	"time"
+)
+
+// containerSpec describes the container the inspector runs in
+type containerSpec struct {
+	Image string
+	// Cmd are the arguments of the inspector
+	Cmd []string
+	// Binds are host directories mounted like docker run -v does
+	Binds []string
+	// Files are copied into the container before it is started
+	Files []containerFile
+	// Keep leaves the container behind after it exited
+	Keep bool
+	// Platform selects the variant of a multi-arch image, like linux/arm64
+	Platform string
+	// Pull is the --pull policy: always, missing (also when empty) or never
+	Pull string
+	// User runs the inspector as another user than the USER of the image,
+	// like docker run --user
+	User string
+}
+
+// containerFile is a file copied into the container
+type containerFile struct {
+	Path string
+	Mode int64
+	Data []byte
+}
+
+// run runs the container like "docker run" and writes its stdout and
+// stderr to the writers while it runs. The image is pulled as the pull
+// policy of the spec says.
+func (e *engineClient) run(spec containerSpec, stdout, stderr io.Writer) error {
+	if spec.Pull == "always" {
+		if err := e.pullImage(spec.Image, spec.Platform, stderr); err != nil {
+			return err
+		}
+	}
+	id, err := e.createContainer(spec)
+	if isNotFound(err) && spec.Pull != "always" {
+		if spec.Pull == "never" {
+			return fmt.Errorf("image %s is not available locally and is not pulled with --pull never", spec.Image)
+		}
+		fmt.Fprintf(stderr, "Unable to find image '%s' locally\n", spec.Image)
+		if err = e.pullImage(spec.Image, spec.Platform, stderr); err == nil {
+			id, err = e.createContainer(spec)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if !spec.Keep {
+		defer e.removeContainer(id)
+		defer removeOnInterrupt(func() { e.removeContainer(id) })()
+	}
+
+	if len(spec.Files) > 0 {
+		if err := e.copyToContainer(id, spec.Files); err != nil {
+			return err
+		}
+	}
+	// Attaching before the start makes sure no output is missed
+	resp, err := e.request("POST", "/containers/"+id+"/attach",
+		url.Values{"stream": {"1"}, "stdout": {"1"}, "stderr": {"1"}}, nil,
+		http.Header{"Connection": {"Upgrade"}, "Upgrade": {"tcp"}})
+	if err != nil {
+		return fmt.Errorf("failed to attach to container: %v", err)
+	}
+	defer resp.Body.Close()
+	if err := e.call("POST", "/containers/"+id+"/start", nil, nil, nil); err != nil {
+		return fmt.Errorf("failed to start container: %v", err)
+	}
+	if err := demuxStreams(resp.Body, stdout, stderr); err != nil {
+		return err
+	}
+
+	var result struct {
+		StatusCode int `json:"StatusCode"`
+		Error      *struct {
+			Message string `json:"Message"`
+		} `json:"Error"`
+	}
+	if err := e.call("POST", "/containers/"+id+"/wait", nil, nil, &result); err != nil {
+		return fmt.Errorf("failed to wait for container: %v", err)
+	}
+	if result.Error != nil && result.Error.Message != "" {
+		return fmt.Errorf("container failed: %s", result.Error.Message)
+	}
+	if result.StatusCode != 0 {
+		return fmt.Errorf("exit status %d", result.StatusCode)
+	}
+	return nil
+}
+
+// createContainer creates the container of the spec and returns its id
+func (e *engineClient) createContainer(spec containerSpec) (string, error) {
+	config := map[string]any{
+		"Image":        spec.Image,
+		"Entrypoint":   []string{"/inspect"},
+		"Cmd":          spec.Cmd,
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"HostConfig": map[string]any{
+			"Binds": spec.Binds,
+			// SELinux (Fedora, RHEL, Podman's default) would deny access to
+			// the mounted directories, which must not be relabeled
+			"SecurityOpt": []string{"label=disable"},
+		},
+	}
+	if spec.User != "" {
+		config["User"] = spec.User
+	}
+	var query url.Values
+	if spec.Platform != "" {
+		// A local image of another platform counts as missing and is pulled
+		query = url.Values{"platform": {spec.Platform}}
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := e.call("POST", "/containers/create", query, config, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// copyToContainer puts the files into the container filesystem as a tar
+// archive, like "docker cp" does
+func (e *engineClient) copyToContainer(id string, files []containerFile) error {
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	dirs := make(map[string]bool)
+	now := time.Now()
+	for _, file := range files {
+		if dir := path.Dir(file.Path); dir != "." && !dirs[dir] {
+			dirs[dir] = true
+			if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir + "/", Mode: 0755, ModTime: now}); err != nil {
+				return err
+			}
+		}
+		header := &tar.Header{Name: file.Path, Mode: file.Mode, Size: int64(len(file.Data)), ModTime: now}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(file.Data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	resp, err := e.request("PUT", "/containers/"+id+"/archive", url.Values{"path": {"/"}}, &archive,
+		http.Header{"Content-Type": {"application/x-tar"}})
+	if err != nil {
+		return fmt.Errorf("failed to copy the inspector into the container: %v", err)
+	}
+	return resp.Body.Close()
+}
+
+// removeContainer deletes the container with its anonymous volumes, like
+// docker run --rm does
+func (e *engineClient) removeContainer(id string) {
+	err := e.call("DELETE", "/containers/"+id, url.Values{"force": {"1"}, "v": {"1"}}, nil, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to remove container %s: %v\n", id, err)
+	}
+}
+
+// interruptRemovals are the containers removed when the inspection is
+// interrupted, keyed by their registration
+var interruptRemovals = struct {
+	sync.Mutex
+	once   sync.Once
+	next   int
+	remove map[int]func()
+}{remove: map[int]func(){}}
+
+// removeOnInterrupt registers remove to be called when the inspection is
+// interrupted, so it does not leave a running container behind. One
+// handler for the process calls every registered remove and exits. The
+// returned function unregisters remove.
+func removeOnInterrupt(remove func()) func() {
+	r := &interruptRemovals
+	r.once.Do(func() {
+		interrupted := make(chan os.Signal, 1)
+		signal.Notify(interrupted, os.Interrupt)
+		go func() {
+			<-interrupted
+			// Holding the lock keeps runs from registering new containers
+			r.Lock()
+			var wg sync.WaitGroup
+			for _, remove := range r.remove {
+				wg.Add(1)
+				go func(remove func()) {
+					defer wg.Done()
+					remove()
+				}(remove)
+			}
+			wg.Wait()
+			os.Exit(130)
+		}()
+	})
+	r.Lock()
+	defer r.Unlock()
+	r.next++
+	id := r.next
+	r.remove[id] = remove
+	return func() {
+		r.Lock()
+		delete(r.remove, id)
+		r.Unlock()
+	}
+}
+
+// demuxStreams splits the multiplexed attach stream of a container without
+// tty into stdout and stderr. Every frame starts with the stream number
+// and the big endian size of the payload.
+func demuxStreams(r io.Reader, stdout, stderr io.Writer) error {
+	var header [8]byte
+	for {
+		_, err := io.ReadFull(r, header[:])
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read container output: %v", err)
+		}
+		w := stdout
+		if header[0] == 2 {
+			w = stderr
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return fmt.Errorf("failed to read container output: %v", err)
+		}
+	}
+}
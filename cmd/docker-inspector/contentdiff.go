@@ -0,0 +1,310 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// diffContext is the number of unchanged lines around a change
+	diffContext = 3
+	// maxDiffCells limits the line comparisons of a single diff
+	maxDiffCells = 25_000_000
+)
+
+// addContentDiffs adds a unified diff to every modified text file whose
+// content changed and that is not bigger than --content-diff-max
+func addContentDiffs(result *Result, args Args, old, new compareSide) error {
+	var oldPaths, newPaths []string
+	var candidates []*FileDiff
+	for i := range result.Differences {
+		diff := &result.Differences[i]
+		if diff.Type != Modified && diff.Type != Renamed {
+			continue
+		}
+		if diff.OldFile.Type != "file" || diff.NewFile.Type != "file" ||
+			diff.OldFile.Size > int64(args.ContentDiffMax) || diff.NewFile.Size > int64(args.ContentDiffMax) ||
+			!contentChanged(diff.Changes) {
+			continue
+		}
+		candidates = append(candidates, diff)
+		oldPaths = append(oldPaths, diff.OldFile.Path)
+		newPaths = append(newPaths, diff.NewFile.Path)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	var oldContents, newContents map[string][]byte
+	err := runConcurrently(
+		func() (err error) {
+			oldContents, err = fetchContents(old.image, old.inspectArgs(args), oldPaths)
+			return err
+		},
+		func() (err error) {
+			newContents, err = fetchContents(new.image, new.inspectArgs(args), newPaths)
+			return err
+		})
+	if err != nil {
+		return err
+	}
+	for _, diff := range candidates {
+		a, okA := oldContents[diff.OldFile.Path]
+		b, okB := newContents[diff.NewFile.Path]
+		if !okA || !okB || !isTextContent(a) || !isTextContent(b) {
+			continue
+		}
+		diff.ContentDiff = unifiedDiff("a"+diff.OldFile.Path, "b"+diff.NewFile.Path, a, b)
+	}
+	return nil
+}
+
+// contentChanged reports whether the changes of a diff include a change
+// of the file contents (size or checksum)
+func contentChanged(changes []FieldChange) bool {
+	for _, change := range changes {
+		switch change.Field {
+		case "size", "sha256", "md5", "content":
+			return true
+		}
+	}
+	return false
+}
+
+// fetchContents reads the given files of image from a tar stream of the
+// inspector, keyed by their path
+func fetchContents(image string, args Args, paths []string) (map[string][]byte, error) {
+	fetchArgs := args
+	fetchArgs.Tar = "-"
+	fetchArgs.PathsFrom = "-"
+	fetchArgs.pathList = paths
+	fetchArgs.StripComponents = 0
+	fetchArgs.OutputDir = ""
+	fetchArgs.MD5 = false
+	fetchArgs.SHA256 = false
+	output, err := runInspector(image, fetchArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	contents := make(map[string][]byte)
+	tr := tar.NewReader(bytes.NewReader(output))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file contents: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file contents: %v", err)
+		}
+		contents["/"+header.Name] = data
+	}
+	return contents, nil
+}
+
+// isTextContent reports whether data looks like text worth diffing
+func isTextContent(data []byte) bool {
+	return bytes.IndexByte(data, 0) < 0 && utf8.Valid(data)
+}
+
+// splitLines splits data into lines keeping their line endings
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, string(data))
+			break
+		}
+		lines = append(lines, string(data[:i+1]))
+		data = data[i+1:]
+	}
+	return lines
+}
+
+// diffOp is a line of a diff: ' ' unchanged, '-' removed or '+' added
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// unifiedDiff returns the differences of a and b in unified diff format
+func unifiedDiff(oldName, newName string, a, b []byte) string {
+	ops, ok := diffLines(splitLines(a), splitLines(b))
+	if !ok {
+		return fmt.Sprintf("--- %s\n+++ %s\n(too many lines to diff)\n", oldName, newName)
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+	// Hunks are the changes with their context, merged when they overlap
+	var hunks [][2]int
+	for i, op := range ops {
+		if op.kind == ' ' {
+			continue
+		}
+		start, end := max(i-diffContext, 0), min(i+diffContext+1, len(ops))
+		if len(hunks) > 0 && start <= hunks[len(hunks)-1][1] {
+			hunks[len(hunks)-1][1] = end
+		} else {
+			hunks = append(hunks, [2]int{start, end})
+		}
+	}
+	for _, hunk := range hunks {
+		writeHunk(&out, ops, hunk[0], hunk[1])
+	}
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, ops []diffOp, start, end int) {
+	// Line numbers of the hunk start are counted from the beginning
+	oldLine, newLine := 1, 1
+	for _, op := range ops[:start] {
+		if op.kind != '+' {
+			oldLine++
+		}
+		if op.kind != '-' {
+			newLine++
+		}
+	}
+	oldCount, newCount := 0, 0
+	for _, op := range ops[start:end] {
+		if op.kind != '+' {
+			oldCount++
+		}
+		if op.kind != '-' {
+			newCount++
+		}
+	}
+	// Empty ranges refer to the line before, like diff -u does
+	if oldCount == 0 {
+		oldLine--
+	}
+	if newCount == 0 {
+		newLine--
+	}
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(oldLine, oldCount), hunkRange(newLine, newCount))
+	for _, op := range ops[start:end] {
+		out.WriteByte(op.kind)
+		out.WriteString(op.line)
+		if !strings.HasSuffix(op.line, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+func hunkRange(line, count int) string {
+	if count == 1 {
+		return fmt.Sprint(line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
+
+// diffLines computes a shortest edit script of the lines with the algorithm
+// of Myers, which takes time by the number of differences instead of the
+// product of the line counts. The lines both have at the beginning and end
+// are kept out of it. ok is false if the lines differ too much.
+func diffLines(a, b []string) (ops []diffOp, ok bool) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ops, ok = diffMiddle(ops, a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+	if !ok {
+		return nil, false
+	}
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops, true
+}
+
+// diffMiddle appends the edit script of a and b to ops. It follows the
+// furthest reaching path of every diagonal k = x - y for d = 0, 1, ...
+// differences until one reaches the end of both.
+func diffMiddle(ops []diffOp, a, b []string) ([]diffOp, bool) {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	// trace has the furthest x of the diagonals -d..d after each d
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		if d*(n+m) > maxDiffCells {
+			return nil, false
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return append(ops, backtrackDiff(trace, a, b, d)...), true
+			}
+		}
+		trace = append(trace, slices.Clone(v[offset-d:offset+d+1]))
+	}
+	return ops, true
+}
+
+// backtrackDiff walks the path of diffMiddle back from the end and returns
+// its edit script
+func backtrackDiff(trace [][]int, a, b []string, d int) []diffOp {
+	var ops []diffOp
+	x, y := len(a), len(b)
+	for ; d > 0; d-- {
+		// v holds the diagonals -(d-1)..d-1 of the step before
+		v := trace[d-1]
+		at := func(k int) int { return v[k+d-1] }
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{' ', a[x]})
+		}
+		if prevK == k+1 {
+			ops = append(ops, diffOp{'+', b[prevY]})
+		} else {
+			ops = append(ops, diffOp{'-', a[prevX]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 {
+		x--
+		ops = append(ops, diffOp{' ', a[x]})
+	}
+	slices.Reverse(ops)
+	return ops
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// dockerContext is the Docker endpoint of a context created with
+// "docker context create"
+type dockerContext struct {
+	Name string
+	Host string
+	// SkipTLSVerify accepts any server certificate
+	SkipTLSVerify bool
+	// TLSDir holds ca.pem, cert.pem and key.pem if the context has them
+	TLSDir string
+}
+
+// dockerConfigDir returns the directory of the docker CLI configuration,
+// DOCKER_CONFIG or ~/.docker
+func dockerConfigDir() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker"), nil
+}
+
+// currentDockerContext returns the context the docker CLI uses when none is
+// given: DOCKER_CONTEXT or the one selected with "docker context use"
+func currentDockerContext() string {
+	if name := os.Getenv("DOCKER_CONTEXT"); name != "" {
+		return name
+	}
+	dir, err := dockerConfigDir()
+	if err != nil {
+		return "default"
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return "default"
+	}
+	var config struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	if json.Unmarshal(data, &config) != nil || config.CurrentContext == "" {
+		return "default"
+	}
+	return config.CurrentContext
+}
+
+// loadDockerContext reads a context from the context store of the docker
+// CLI, where it is kept in a directory named by the digest of its name
+func loadDockerContext(name string) (dockerContext, error) {
+	dir, err := dockerConfigDir()
+	if err != nil {
+		return dockerContext{}, err
+	}
+	digest := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(digest[:])
+	data, err := os.ReadFile(filepath.Join(dir, "contexts", "meta", id, "meta.json"))
+	if os.IsNotExist(err) {
+		return dockerContext{}, fmt.Errorf("docker context %q does not exist", name)
+	}
+	if err != nil {
+		return dockerContext{}, fmt.Errorf("failed to read docker context %q: %v", name, err)
+	}
+	var meta struct {
+		Endpoints map[string]struct {
+			Host          string `json:"Host"`
+			SkipTLSVerify bool   `json:"SkipTLSVerify"`
+		} `json:"Endpoints"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return dockerContext{}, fmt.Errorf("failed to parse docker context %q: %v", name, err)
+	}
+	endpoint, ok := meta.Endpoints["docker"]
+	if !ok || endpoint.Host == "" {
+		return dockerContext{}, fmt.Errorf("docker context %q has no docker endpoint", name)
+	}
+	context := dockerContext{Name: name, Host: endpoint.Host, SkipTLSVerify: endpoint.SkipTLSVerify}
+	tlsDir := filepath.Join(dir, "contexts", "tls", id, "docker")
+	if _, err := os.Stat(tlsDir); err == nil {
+		context.TLSDir = tlsDir
+	}
+	return context, nil
+}
+
+// tlsConfig returns the TLS settings of the context, or nil if it has none
+func (c dockerContext) tlsConfig() (*tls.Config, error) {
+	if c.TLSDir == "" {
+		if c.SkipTLSVerify {
+			return &tls.Config{InsecureSkipVerify: true}, nil
+		}
+		return nil, nil
+	}
+	return loadTLSConfig(c.TLSDir, !c.SkipTLSVerify)
+}
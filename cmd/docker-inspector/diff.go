@@ -3,20 +3,70 @@ package main
 
 import (
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 )
 
-// Mode specifies what attributes to compare
+// Mode specifies what attributes to compare, the flags can be combined
 type Mode int
 
 const (
 	// CompareAll includes all attributes including modification times
-	CompareAll Mode = iota
-	// CompareNoTimes excludes modification time comparisons
-	CompareNoTimes
+	CompareAll Mode = 0
+	// CompareNoTimes excludes modification time comparisons, it keeps the
+	// value 1 it had before the mode became a set of flags
+	CompareNoTimes Mode = 1 << (iota - 1)
+	// CompareNoOwnership excludes user and group comparisons
+	CompareNoOwnership
+	// CompareNoPerms excludes permission comparisons
+	CompareNoPerms
+	// CompareNoSize excludes size comparisons
+	CompareNoSize
 )
 
+// Options configures a comparison
+type Options struct {
+	// Mode selects the compared attributes
+	Mode Mode
+	// TimeTolerance is the largest modification time difference not reported
+	TimeTolerance time.Duration
+	// Owners selects how users and groups are matched
+	Owners OwnerMatch
+}
+
+// OwnerMatch selects which part of the "name(id)" owners is compared
+type OwnerMatch int
+
+const (
+	// OwnersExact compares names and numeric ids
+	OwnersExact OwnerMatch = iota
+	// OwnersByID compares the numeric ids only
+	OwnersByID
+	// OwnersByName compares the names only
+	OwnersByName
+)
+
+// ownerKey returns the part of a "name(id)" owner selected by match
+func ownerKey(owner string, match OwnerMatch) string {
+	name, id, found := strings.Cut(owner, "(")
+	if !found {
+		return owner
+	}
+	switch match {
+	case OwnersByID:
+		return strings.TrimSuffix(id, ")")
+	case OwnersByName:
+		// Owners without a name are matched by their id
+		if name == "" {
+			return owner
+		}
+		return name
+	}
+	return owner
+}
+
 // Change represents the type of difference found
 type Change string
 
@@ -24,16 +74,58 @@ const (
 	Added    Change = "added"
 	Removed  Change = "removed"
 	Modified Change = "modified"
+	// Renamed files have the same content at a new path
+	Renamed Change = "renamed"
+)
+
+// ChangeClass classifies modifications
+type ChangeClass string
+
+const (
+	MetadataOnly       ChangeClass = "metadata-only"
+	ContentOnly        ChangeClass = "content"
+	ContentAndMetadata ChangeClass = "both"
 )
 
+// contentFields are the fields that change with the content of a file
+var contentFields = []string{"type", "device", "symlink", "size", "sha256", "md5", "content", "contentType", "elf"}
+
+// changeClass classifies changes by the fields they touch
+func changeClass(changes []FieldChange) ChangeClass {
+	content, metadata := false, false
+	for _, change := range changes {
+		if slices.Contains(contentFields, change.Field) {
+			content = true
+		} else {
+			metadata = true
+		}
+	}
+	switch {
+	case content && metadata:
+		return ContentAndMetadata
+	case content:
+		return ContentOnly
+	}
+	return MetadataOnly
+}
+
 // FileDiff represents a difference between two versions of a file
 type FileDiff struct {
-	Path    string   `json:"path"`
+	Path string `json:"path"`
+	// OldPath is the path of a renamed file in the old image
+	OldPath string   `json:"oldPath,omitempty"`
 	Type    Change   `json:"type"`
 	OldFile FileInfo `json:"oldFile,omitempty"`
 	NewFile FileInfo `json:"newFile,omitempty"`
+	// ChangeClass tells whether a modification changed the metadata, the
+	// content or both
+	ChangeClass ChangeClass `json:"changeClass,omitempty"`
 	// Details contains human-readable descriptions of the changes
 	Details []string `json:"details,omitempty"`
+	// Changes describes the same changes as Details in a structured way
+	Changes []FieldChange `json:"changes,omitempty"`
+	// ContentDiff is a unified diff of text files (with --content-diff)
+	ContentDiff string `json:"contentDiff,omitempty"`
 }
 
 // Summary contains statistical information about the differences
@@ -42,29 +134,113 @@ type Summary struct {
 	AddedFiles       int `json:"addedFiles"`
 	RemovedFiles     int `json:"removedFiles"`
 	ModifiedFiles    int `json:"modifiedFiles"`
+	RenamedFiles     int `json:"renamedFiles"`
+	// The modified files by their change class
+	MetadataOnlyFiles       int `json:"metadataOnlyFiles"`
+	ContentModifiedFiles    int `json:"contentModifiedFiles"`
+	ContentAndMetadataFiles int `json:"contentAndMetadataFiles"`
+	// SizeDelta is the change of the total file size in bytes
+	SizeDelta int64 `json:"sizeDelta"`
+	// Directories aggregates the differences per directory (see --rollup-depth)
+	Directories []DirectorySummary `json:"directories,omitempty"`
+	// Extensions aggregates the differences of files per extension
+	Extensions []ExtensionSummary `json:"extensions,omitempty"`
 }
 
 // Result contains the complete diff information
 type Result struct {
 	Differences []FileDiff `json:"differences"`
 	Summary     Summary    `json:"summary"`
+	// Growth attributes the size change to files and directories (with --growth)
+	Growth *GrowthReport `json:"growth,omitempty"`
+	// Truncated is the number of differences left out by --max-diffs
+	Truncated int `json:"truncated,omitempty"`
 }
 
 // FileInfo mirrors the internal inspector's FileInfo structure
 type FileInfo struct {
-	Path      string     `json:"path"`
-	Size      int64      `json:"size"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// AllocatedSize is the disk usage based on st_blocks
+	AllocatedSize int64 `json:"allocatedSize"`
+	// Sparse is set for regular files that use less disk space than their size
+	Sparse    bool       `json:"sparse,omitempty"`
 	Mode      string     `json:"mode"`
+	Type      string     `json:"type"`
 	ModTime   *time.Time `json:"modTime,omitempty"`
 	IsDir     bool       `json:"isDir"`
 	SymlinkTo string     `json:"symlinkTo,omitempty"`
-	User      string     `json:"user"`
-	Group     string     `json:"group"`
-	MD5       string     `json:"md5,omitempty"`
+	// SymlinkBroken is set when the symlink target does not exist
+	SymlinkBroken bool `json:"symlinkBroken,omitempty"`
+	// SymlinkResolved is the absolute path the symlink points to
+	SymlinkResolved string `json:"symlinkResolved,omitempty"`
+	// SymlinkEscapes is set when the resolved target is outside of --path
+	SymlinkEscapes bool   `json:"symlinkEscapes,omitempty"`
+	User           string `json:"user"`
+	Group          string `json:"group"`
+	// UID and GID are the numeric owner ids, UserName and GroupName their
+	// names in the image (empty if unknown), User and Group combine both
+	UID       *uint32 `json:"uid,omitempty"`
+	GID       *uint32 `json:"gid,omitempty"`
+	UserName  string  `json:"userName,omitempty"`
+	GroupName string  `json:"groupName,omitempty"`
+	MD5       string  `json:"md5,omitempty"`
+	SHA256    string  `json:"sha256,omitempty"`
+	// HashSkipped is set when the file exceeded --max-hash-size
+	HashSkipped bool `json:"hashSkipped,omitempty"`
+	// Xattrs holds the extended attributes of the file (with --xattrs)
+	Xattrs map[string]string `json:"xattrs,omitempty"`
+	// Capabilities lists file capabilities in getcap notation
+	Capabilities string `json:"capabilities,omitempty"`
+	// SecurityLabel is the SELinux/AppArmor/Smack label (with --labels)
+	SecurityLabel string `json:"securityLabel,omitempty"`
+	// AttrFlags holds the lsattr letters of the inode flags (with --attr-flags)
+	AttrFlags string `json:"attrFlags,omitempty"`
+	// Entropy is the Shannon entropy in bits per byte (with --entropy)
+	Entropy float64 `json:"entropy,omitempty"`
+	// ContentType classifies the file contents (with --detect-types)
+	ContentType string `json:"contentType,omitempty"`
+	// MountType is the filesystem type of mount points (e.g. tmpfs for volumes)
+	MountType string `json:"mountType,omitempty"`
+	// Elf describes ELF binaries (with --elf)
+	Elf *ElfInfo `json:"elf,omitempty"`
+	// Matches are the lines matching --grep
+	Matches []GrepMatch `json:"matches,omitempty"`
+	// Inode, Device and Links identify hardlinked files
+	Inode  uint64 `json:"inode,omitempty"`
+	Device uint64 `json:"device,omitempty"`
+	Links  uint64 `json:"links,omitempty"`
+	// HardlinkTo names the first path of the hardlink group this file belongs to
+	HardlinkTo string `json:"hardlinkTo,omitempty"`
+	// Major and Minor are the device numbers of device nodes
+	Major uint32 `json:"major,omitempty"`
+	Minor uint32 `json:"minor,omitempty"`
+}
+
+// ElfInfo mirrors the internal inspector's ElfInfo structure
+type ElfInfo struct {
+	Machine     string `json:"machine"`
+	Class       int    `json:"class"`
+	Type        string `json:"type"`
+	Linkage     string `json:"linkage"`
+	Interpreter string `json:"interpreter,omitempty"`
+	Stripped    bool   `json:"stripped"`
+}
+
+// String returns a short description like "x86_64 dynamic stripped"
+func (e *ElfInfo) String() string {
+	if e == nil {
+		return "none"
+	}
+	s := e.Machine + " " + e.Linkage
+	if e.Stripped {
+		s += " stripped"
+	}
+	return s
 }
 
 // Compare performs a comparison of two sets of FileInfo records
-func Compare(old, new []FileInfo, mode Mode) (*Result, error) {
+func Compare(old, new []FileInfo, opts Options) (*Result, error) {
 	result := &Result{}
 
 	// Create maps for faster lookups
@@ -111,16 +287,19 @@ func Compare(old, new []FileInfo, mode Mode) (*Result, error) {
 		}
 
 		// Check for modifications
-		if differences := compareFiles(oldFile, newFile, mode); len(differences) > 0 {
+		if changes := compareFields(oldFile, newFile, opts); len(changes) > 0 {
 			diff := FileDiff{
-				Path:    path,
-				Type:    Modified,
-				OldFile: oldFile,
-				NewFile: newFile,
-				Details: differences,
+				Path:        path,
+				Type:        Modified,
+				OldFile:     oldFile,
+				NewFile:     newFile,
+				Details:     changeDetails(changes),
+				Changes:     changes,
+				ChangeClass: changeClass(changes),
 			}
 			result.Differences = append(result.Differences, diff)
 			result.Summary.ModifiedFiles++
+			result.Summary.countClass(diff.ChangeClass)
 		}
 	}
 
@@ -131,41 +310,315 @@ func Compare(old, new []FileInfo, mode Mode) (*Result, error) {
 	return result, nil
 }
 
+// DetectRenames replaces pairs of removed and added regular files with the
+// same size and checksum by a single rename
+func DetectRenames(result *Result, opts Options) {
+	type content struct {
+		size int64
+		hash string
+	}
+	removed := make(map[content][]int)
+	for i, diff := range result.Differences {
+		if diff.Type == Removed && diff.OldFile.Type == "file" {
+			if hash := contentHash(diff.OldFile); hash != "" {
+				key := content{diff.OldFile.Size, hash}
+				removed[key] = append(removed[key], i)
+			}
+		}
+	}
+	if len(removed) == 0 {
+		return
+	}
+	// Pair the paths in order, so the result does not depend on map order
+	for _, indexes := range removed {
+		sort.Slice(indexes, func(a, b int) bool {
+			return result.Differences[indexes[a]].Path < result.Differences[indexes[b]].Path
+		})
+	}
+	var added []int
+	for i, diff := range result.Differences {
+		if diff.Type == Added && diff.NewFile.Type == "file" {
+			added = append(added, i)
+		}
+	}
+	sort.Slice(added, func(a, b int) bool {
+		return result.Differences[added[a]].Path < result.Differences[added[b]].Path
+	})
+
+	drop := make(map[int]bool)
+	for _, i := range added {
+		newFile := result.Differences[i].NewFile
+		key := content{newFile.Size, contentHash(newFile)}
+		if key.hash == "" || len(removed[key]) == 0 {
+			continue
+		}
+		j := removed[key][0]
+		removed[key] = removed[key][1:]
+		oldFile := result.Differences[j].OldFile
+
+		var changes []FieldChange
+		for _, change := range compareFields(oldFile, newFile, opts) {
+			if change.Field != "hardlink" {
+				changes = append(changes, change)
+			}
+		}
+		result.Differences[i] = FileDiff{
+			Path:    newFile.Path,
+			OldPath: oldFile.Path,
+			Type:    Renamed,
+			OldFile: oldFile,
+			NewFile: newFile,
+			Details: changeDetails(changes),
+			Changes: changes,
+		}
+		drop[j] = true
+		result.Summary.AddedFiles--
+		result.Summary.RemovedFiles--
+		result.Summary.RenamedFiles++
+	}
+
+	differences := result.Differences[:0]
+	for i, diff := range result.Differences {
+		if !drop[i] {
+			differences = append(differences, diff)
+		}
+	}
+	result.Differences = differences
+	result.Summary.TotalDifferences = result.Summary.AddedFiles +
+		result.Summary.RemovedFiles +
+		result.Summary.ModifiedFiles +
+		result.Summary.RenamedFiles
+}
+
+// countClass counts a modification by its change class
+func (s *Summary) countClass(class ChangeClass) {
+	switch class {
+	case MetadataOnly:
+		s.MetadataOnlyFiles++
+	case ContentOnly:
+		s.ContentModifiedFiles++
+	case ContentAndMetadata:
+		s.ContentAndMetadataFiles++
+	}
+}
+
+// FilterChanges keeps only the differences of the given change types and
+// updates the summary accordingly
+func FilterChanges(result *Result, types []Change) {
+	differences := result.Differences[:0]
+	result.Summary.AddedFiles = 0
+	result.Summary.RemovedFiles = 0
+	result.Summary.ModifiedFiles = 0
+	result.Summary.RenamedFiles = 0
+	result.Summary.MetadataOnlyFiles = 0
+	result.Summary.ContentModifiedFiles = 0
+	result.Summary.ContentAndMetadataFiles = 0
+	for _, diff := range result.Differences {
+		if !slices.Contains(types, diff.Type) {
+			continue
+		}
+		differences = append(differences, diff)
+		switch diff.Type {
+		case Added:
+			result.Summary.AddedFiles++
+		case Removed:
+			result.Summary.RemovedFiles++
+		case Modified:
+			result.Summary.ModifiedFiles++
+			result.Summary.countClass(diff.ChangeClass)
+		case Renamed:
+			result.Summary.RenamedFiles++
+		}
+	}
+	result.Differences = differences
+	result.Summary.TotalDifferences = len(differences)
+}
+
+// contentHash returns the strongest checksum known for a file
+func contentHash(file FileInfo) string {
+	if isChecksum(file.SHA256) {
+		return "sha256:" + file.SHA256
+	}
+	if isChecksum(file.MD5) {
+		return "md5:" + file.MD5
+	}
+	return ""
+}
+
+// FieldChange describes a changed attribute of a file in a structured way
+type FieldChange struct {
+	// Field is the name of the attribute, e.g. size, mode, owner or sha256
+	Field string `json:"field"`
+	// Key is the name of the extended attribute for xattr changes
+	Key string `json:"key,omitempty"`
+	Old any    `json:"old,omitempty"`
+	New any    `json:"new,omitempty"`
+	// detail is the human-readable description used for Details
+	detail string
+}
+
 // compareFiles returns a list of differences between two files
-func compareFiles(old, new FileInfo, mode Mode) []string {
-	var differences []string
+func compareFiles(old, new FileInfo, opts Options) []string {
+	return changeDetails(compareFields(old, new, opts))
+}
+
+// changeDetails returns the human-readable descriptions of changes
+func changeDetails(changes []FieldChange) []string {
+	var details []string
+	for _, change := range changes {
+		details = append(details, change.detail)
+	}
+	return details
+}
+
+// compareFields returns the changed attributes of two versions of a file
+func compareFields(old, new FileInfo, opts Options) []FieldChange {
+	mode := opts.Mode
+	var changes []FieldChange
+	// change adds a change of field described as "<what> changed: old -> new"
+	change := func(field, what string, oldValue, newValue any) {
+		changes = append(changes, FieldChange{
+			Field:  field,
+			Old:    oldValue,
+			New:    newValue,
+			detail: fmt.Sprintf("%s changed: %v -> %v", what, displayValue(oldValue), displayValue(newValue)),
+		})
+	}
+
+	// A type change makes most other comparisons meaningless, an unknown
+	// type is not a change
+	if old.Type != new.Type && old.Type != "" && new.Type != "" {
+		change("type", "type", old.Type, new.Type)
+	}
+	if old.Major != new.Major || old.Minor != new.Minor {
+		change("device", "device", fmt.Sprintf("%d,%d", old.Major, old.Minor), fmt.Sprintf("%d,%d", new.Major, new.Minor))
+	}
+
+	if old.SymlinkTo != new.SymlinkTo {
+		change("symlink", "symlink target", old.SymlinkTo, new.SymlinkTo)
+	}
 
 	// Compare basic attributes
-	if old.Size != new.Size {
-		differences = append(differences,
-			fmt.Sprintf("size changed: %d -> %d", old.Size, new.Size))
+	if mode&CompareNoSize == 0 && old.Size != new.Size {
+		change("size", "size", old.Size, new.Size)
 	}
-	if old.Mode != new.Mode {
-		differences = append(differences,
-			fmt.Sprintf("permissions changed: %s -> %s", old.Mode, new.Mode))
+	if mode&CompareNoPerms == 0 && old.Mode != new.Mode {
+		change("mode", "permissions", old.Mode, new.Mode)
 	}
-	if old.User != new.User || old.Group != new.Group {
-		differences = append(differences,
-			fmt.Sprintf("ownership changed: %s:%s -> %s:%s",
-				old.User, old.Group, new.User, new.Group))
+	if mode&CompareNoOwnership == 0 && (ownerKey(old.User, opts.Owners) != ownerKey(new.User, opts.Owners) ||
+		ownerKey(old.Group, opts.Owners) != ownerKey(new.Group, opts.Owners)) {
+		change("owner", "ownership", old.User+":"+old.Group, new.User+":"+new.Group)
 	}
 
 	// Compare modification times if requested
-	if mode == CompareAll && old.ModTime != nil && new.ModTime != nil {
-		if !old.ModTime.Equal(*new.ModTime) {
-			differences = append(differences,
-				fmt.Sprintf("modification time changed: %s -> %s",
-					old.ModTime.Format(time.RFC3339),
-					new.ModTime.Format(time.RFC3339)))
+	if mode&CompareNoTimes == 0 && old.ModTime != nil && new.ModTime != nil {
+		if delta := old.ModTime.Sub(*new.ModTime).Abs(); delta > opts.TimeTolerance {
+			change("mtime", "modification time",
+				old.ModTime.Format(time.RFC3339), new.ModTime.Format(time.RFC3339))
+		}
+	}
+
+	// Inode numbers differ between images, only the link structure matters
+	if old.HardlinkTo != new.HardlinkTo {
+		change("hardlink", "hardlink", old.HardlinkTo, new.HardlinkTo)
+	}
+
+	if old.ContentType != new.ContentType {
+		change("contentType", "content type", old.ContentType, new.ContentType)
+	}
+
+	if old.Elf != nil || new.Elf != nil {
+		if old.Elf == nil || new.Elf == nil || *old.Elf != *new.Elf {
+			change("elf", "elf", old.Elf, new.Elf)
+		}
+	}
+
+	if old.AttrFlags != new.AttrFlags {
+		change("attrFlags", "attribute flags", old.AttrFlags, new.AttrFlags)
+	}
+
+	if old.Capabilities != new.Capabilities {
+		change("capabilities", "capabilities", old.Capabilities, new.Capabilities)
+	}
+
+	if old.SecurityLabel != new.SecurityLabel {
+		change("securityLabel", "security label", old.SecurityLabel, new.SecurityLabel)
+	}
+
+	// Compare extended attributes
+	changes = append(changes, compareXattrs(old.Xattrs, new.Xattrs)...)
+
+	// Compare checksums if available
+	if old.SHA256 != "" && new.SHA256 != "" && old.SHA256 != new.SHA256 {
+		changes = append(changes, FieldChange{Field: "sha256", Old: old.SHA256, New: new.SHA256,
+			detail: "content changed (different SHA256)"})
+	} else if old.MD5 != "" && new.MD5 != "" && old.MD5 != new.MD5 {
+		changes = append(changes, FieldChange{Field: "md5", Old: old.MD5, New: new.MD5,
+			detail: "content changed (different MD5)"})
+	} else if mode&CompareNoSize != 0 && old.Type == "file" && new.Type == "file" && old.Size != new.Size {
+		// Files of different size always have a different content
+		changes = append(changes, FieldChange{Field: "content", Old: old.Size, New: new.Size,
+			detail: "content changed (different size)"})
+	}
+
+	return changes
+}
+
+// compareXattrs returns the differences between two sets of extended attributes
+func compareXattrs(old, new map[string]string) []FieldChange {
+	var changes []FieldChange
+	for _, name := range sortedKeys(old, new) {
+		// Reported as capability or label change already
+		switch name {
+		case "security.capability", "security.selinux", "security.apparmor", "security.SMACK64":
+			continue
+		}
+		oldValue, inOld := old[name]
+		newValue, inNew := new[name]
+		switch {
+		case !inOld:
+			changes = append(changes, FieldChange{Field: "xattr", Key: name, New: newValue,
+				detail: fmt.Sprintf("xattr added: %s=%s", name, newValue)})
+		case !inNew:
+			changes = append(changes, FieldChange{Field: "xattr", Key: name, Old: oldValue,
+				detail: fmt.Sprintf("xattr removed: %s", name)})
+		case oldValue != newValue:
+			changes = append(changes, FieldChange{Field: "xattr", Key: name, Old: oldValue, New: newValue,
+				detail: fmt.Sprintf("xattr changed: %s: %s -> %s", name, oldValue, newValue)})
 		}
 	}
+	return changes
+}
+
+// displayValue shows empty strings as none in details
+func displayValue(v any) any {
+	if s, ok := v.(string); ok {
+		return orNone(s)
+	}
+	return v
+}
 
-	// Compare MD5 if available
-	if old.MD5 != "" && new.MD5 != "" && old.MD5 != new.MD5 {
-		differences = append(differences, "content changed (different MD5)")
+func orNone(s string) string {
+	if s == "" {
+		return "none"
 	}
+	return s
+}
 
-	return differences
+// sortedKeys returns the union of the keys of the given maps in sorted order
+func sortedKeys(maps ...map[string]string) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range maps {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 // isSpecialFile returns true for files we want to ignore
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// validatePatterns checks glob patterns before any image is inspected
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if !doublestar.ValidatePattern(pattern) {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// withoutDiffIgnored drops the files matching one of the --diff-ignore
+// patterns, so they are neither compared nor reported. files is left as it
+// is, the listings written next to the comparison still need all of them.
+func withoutDiffIgnored(files []FileInfo, patterns []string) []FileInfo {
+	if len(patterns) == 0 {
+		return files
+	}
+	var kept []FileInfo
+	for _, file := range files {
+		if !matchesAnyPattern(patterns, file.Path) {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
+
+// extractedFiles returns the files selected for extraction by
+// --extract-glob and --extract-exclude, like the inspector selects them
+func extractedFiles(files []FileInfo, args Args) []FileInfo {
+	if len(args.ExtractGlobs) == 0 && len(args.ExtractExcludes) == 0 {
+		return files
+	}
+	var selected []FileInfo
+	for _, file := range files {
+		if len(args.ExtractGlobs) > 0 && !matchesAnyPattern(args.ExtractGlobs, file.Path) {
+			continue
+		}
+		if !matchesAnyPattern(args.ExtractExcludes, file.Path) {
+			selected = append(selected, file)
+		}
+	}
+	return selected
+}
+
+// matchesAnyPattern reports whether path matches one of the validated patterns
+func matchesAnyPattern(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if match, _ := doublestar.Match(pattern, path); match {
+			return true
+		}
+	}
+	return false
+}
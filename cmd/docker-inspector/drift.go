@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"github.com/alexflint/go-arg"
+	"os"
+)
+
+type DriftArgs struct {
+	Container      string   `arg:"positional,required" help:"running (or stopped) container to check"`
+	Paths          []string `arg:"--path,separate" help:"path inside the container to compare (repeatable) [default: /]"`
+	Excludes       []string `arg:"--exclude,separate" help:"glob pattern for files to skip (repeatable)"`
+	DiffIgnores    []string `arg:"--diff-ignore,separate" help:"glob pattern for paths to leave out of the comparison (repeatable)"`
+	IgnorePresets  []string `arg:"--ignore-preset,separate" help:"leave known noisy files out, e.g. container-runtime,logs (see \"docker-inspector presets\")"`
+	SHA256         bool     `arg:"--sha256" help:"hash all files instead of only those with an unchanged size"`
+	NoTimes        bool     `arg:"--no-times" help:"ignore modification times"`
+	ContentDiff    bool     `arg:"--content-diff" help:"show unified diffs of modified text files"`
+	ContentDiffMax ByteSize `arg:"--content-diff-max" default:"256K" help:"largest file size for --content-diff"`
+	Format         string   `arg:"--format" help:"output format: text, json, ndjson or porcelain [default: text]"`
+	Output         string   `arg:"-o,--output" help:"write the results to this file, gzip compressed if it ends with .gz"`
+	RuntimeArgs
+}
+
+func (DriftArgs) Description() string {
+	return "Compares the filesystem of a container with the image it was created from. " +
+		"Files in volumes are not part of the container filesystem and are not compared."
+}
+
+// runDrift implements "docker-inspector drift CONTAINER"
+func runDrift(cmdArgs []string) {
+	var driftArgs DriftArgs
+	parser, err := arg.NewParser(arg.Config{Program: "docker-inspector drift"}, &driftArgs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	parser.MustParse(cmdArgs)
+	driftArgs.RuntimeArgs.apply(parser)
+	switch driftArgs.Format {
+	case "", "text":
+		driftArgs.Format = "table"
+	case "json", "ndjson", "porcelain":
+	default:
+		parser.Fail(fmt.Sprintf("unknown format %q", driftArgs.Format))
+	}
+	if len(driftArgs.IgnorePresets) > 0 {
+		if err := loadPresets(""); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		patterns, err := presetPatterns(driftArgs.IgnorePresets)
+		if err != nil {
+			parser.Fail(err.Error())
+		}
+		driftArgs.DiffIgnores = append(driftArgs.DiffIgnores, patterns...)
+	}
+	if err := validatePatterns(driftArgs.DiffIgnores); err != nil {
+		parser.Fail(err.Error())
+	}
+
+	source, err := containerImage(driftArgs.Container)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The container filesystem is inspected through a temporary image, so
+	// nothing has to be copied into the container itself
+	committed, err := commitContainer(driftArgs.Container)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	status := checkDrift(driftArgs, source, committed)
+	if err := removeImage(committed); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to remove temporary image %s: %v\n", committed, err)
+	}
+	os.Exit(status)
+}
+
+// checkDrift compares the committed container with its source image and
+// returns the exit status
+func checkDrift(driftArgs DriftArgs, source, committed string) int {
+	args := Args{
+		RuntimeArgs:    driftArgs.RuntimeArgs,
+		Paths:          driftArgs.Paths,
+		Excludes:       driftArgs.Excludes,
+		DiffIgnores:    driftArgs.DiffIgnores,
+		SHA256:         driftArgs.SHA256,
+		NoTimes:        driftArgs.NoTimes,
+		ContentDiff:    driftArgs.ContentDiff,
+		ContentDiffMax: driftArgs.ContentDiffMax,
+		Format:         driftArgs.Format,
+		Output:         driftArgs.Output,
+	}
+
+	sides := []compareSide{
+		{name: source, image: source},
+		{name: driftArgs.Container, image: committed},
+	}
+	err := runConcurrently(
+		func() (err error) {
+			sides[0].files, err = inspectFiles(source, sides[0].inspectArgs(args))
+			return err
+		},
+		func() (err error) {
+			// The committed image only exists locally
+			committedArgs := sides[1].inspectArgs(args)
+			committedArgs.Pull = "missing"
+			sides[1].files, err = inspectFiles(committed, committedArgs)
+			return err
+		})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Inspection failed: %v\n", err)
+		return 1
+	}
+
+	out, err := openOutput(args.Output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return runComparison(sides[0], sides[1], args, out)
+}
+
+// containerImage returns the id of the image a container was created from
+func containerImage(container string) (string, error) {
+	engine, err := containerEngine()
+	if err != nil {
+		return "", err
+	}
+	image, err := engine.containerImage(container)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect container %s: %v", container, err)
+	}
+	return image, nil
+}
+
+// commitContainer saves the filesystem of the container as an untagged
+// image and returns its id
+func commitContainer(container string) (string, error) {
+	engine, err := containerEngine()
+	if err != nil {
+		return "", err
+	}
+	image, err := engine.commitContainer(container)
+	if err != nil {
+		return "", fmt.Errorf("failed to commit container %s: %v", container, err)
+	}
+	return image, nil
+}
+
+// removeImage deletes the temporary image of a container
+func removeImage(image string) error {
+	engine, err := containerEngine()
+	if err != nil {
+		return err
+	}
+	return engine.removeImage(image)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+// printDryRun lists which files an extraction would write to which
+// destination, after all filters and --strip-components were applied
+func printDryRun(w io.Writer, files []FileInfo, args Args) {
+	fileCount, dirCount := 0, 0
+	for _, file := range files {
+		destPath := getDestPath(file.Path, args.StripComponents)
+		if destPath == "" {
+			continue
+		}
+		dest := filepath.Join(args.OutputDir, destPath)
+		switch {
+		case file.IsDir:
+			dirCount++
+			fmt.Fprintf(w, "%s/ -> %s/\n", file.Path, dest)
+		case file.HardlinkTo != "":
+			fileCount++
+			fmt.Fprintf(w, "%s -> %s (hardlink to %s)\n", file.Path, dest, file.HardlinkTo)
+		default:
+			fileCount++
+			fmt.Fprintf(w, "%s -> %s\n", file.Path, dest)
+		}
+	}
+	fmt.Fprintf(w, "\nWould extract %d files and %d directories to %s\n", fileCount, dirCount, args.OutputDir)
+}
+
+// printStaleFiles lists the files --sync would delete from the output
+// directory
+func printStaleFiles(w io.Writer, files []FileInfo, args Args) error {
+	stale, err := staleFiles(files, args)
+	if err != nil {
+		return fmt.Errorf("failed to scan output directory: %v", err)
+	}
+	for _, p := range stale {
+		fmt.Fprintf(w, "delete %s\n", p)
+	}
+	fmt.Fprintf(w, "Would delete %d files no longer in the image\n", len(stale))
+	return nil
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// engineAPIVersion is the highest Docker Engine API version used, it is
+// supported since Docker 20.10. Older daemons are talked to in their own
+// version, like the docker CLI negotiates it.
+const engineAPIVersion = "1.41"
+
+// engineClient talks to the Docker Engine API of Docker or Podman, like the
+// docker CLI does
+type engineClient struct {
+	client *http.Client
+	// base is the URL the API paths are appended to
+	base string
+	// host is the daemon address
+	host string
+	// remote is set for daemons not reached through a local socket
+	remote bool
+	// version is the negotiated API version, see apiVersion
+	version   string
+	negotiate sync.Once
+}
+
+// engineError is an error response of the Docker Engine API
+type engineError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *engineError) Error() string {
+	return e.Message
+}
+
+// isNotFound reports whether err is a 404 of the Docker Engine API, like a
+// missing image or container
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*engineError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// newEngineClient creates a client for a daemon address like
+// unix:///var/run/docker.sock, tcp://host:2376, ssh://user@host or
+// npipe:////./pipe/docker_engine, tcp addresses use TLS with tlsConfig if it is not nil
+func newEngineClient(host string, tlsConfig *tls.Config) (*engineClient, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host %q: %v", host, err)
+	}
+	transport := &http.Transport{}
+	base := "http://docker"
+	switch u.Scheme {
+	case "unix":
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", u.Path)
+		}
+	case "npipe":
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialPipe(strings.ReplaceAll(u.Path, "/", `\`))
+		}
+	case "ssh":
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialSSH(u)
+		}
+	case "tcp", "http", "https":
+		base = "http://" + u.Host
+		if tlsConfig != nil || u.Scheme == "https" {
+			base = "https://" + u.Host
+			transport.TLSClientConfig = tlsConfig
+		}
+	default:
+		return nil, fmt.Errorf("unsupported docker host %q", host)
+	}
+	return &engineClient{
+		client: &http.Client{Transport: transport},
+		base:   base,
+		host:   host,
+		remote: u.Scheme != "unix" && u.Scheme != "npipe",
+	}, nil
+}
+
+// isRemote reports whether host directories can not be mounted, as they
+// would be taken from the daemon's host
+func (e *engineClient) isRemote() bool {
+	return e.remote
+}
+
+func (e *engineClient) endpoint() string {
+	return e.host
+}
+
+// dockerTLSConfig returns the client certificates configured like for the
+// docker CLI with DOCKER_TLS_VERIFY and DOCKER_CERT_PATH, or nil
+func dockerTLSConfig() (*tls.Config, error) {
+	certPath := os.Getenv("DOCKER_CERT_PATH")
+	verify := os.Getenv("DOCKER_TLS_VERIFY") != ""
+	if certPath == "" && !verify {
+		return nil, nil
+	}
+	if certPath == "" {
+		dir, err := dockerConfigDir()
+		if err != nil {
+			return nil, err
+		}
+		certPath = dir
+	}
+	return loadTLSConfig(certPath, verify)
+}
+
+// loadTLSConfig loads the client certificate (cert.pem and key.pem) and
+// the CA (ca.pem) the server is verified with from dir, missing files are
+// left out
+func loadTLSConfig(dir string, verify bool) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: !verify}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if _, err := os.Stat(certFile); err == nil {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load docker client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if !verify {
+		return config, nil
+	}
+	ca, err := os.ReadFile(filepath.Join(dir, "ca.pem"))
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load docker CA certificate: %v", err)
+	}
+	config.RootCAs = x509.NewCertPool()
+	config.RootCAs.AppendCertsFromPEM(ca)
+	return config, nil
+}
+
+// request sends an API request and returns the response of a successful
+// one, the caller has to close its body. Failures are returned as
+// *engineError with the message of the daemon.
+func (e *engineClient) request(method, path string, query url.Values, body io.Reader, header http.Header) (*http.Response, error) {
+	target := e.base + "/v" + e.apiVersion() + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to the Docker daemon at %s: %v", e.host, err)
+	}
+	if resp.StatusCode < 400 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	var message struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(data, &message) != nil || message.Message == "" {
+		message.Message = strings.TrimSpace(string(data))
+	}
+	return nil, &engineError{StatusCode: resp.StatusCode, Message: message.Message}
+}
+
+// apiVersion returns the API version requests use: DOCKER_API_VERSION if it
+// is set, otherwise the version the daemon reports by /_ping if it is lower
+// than engineAPIVersion
+func (e *engineClient) apiVersion() string {
+	e.negotiate.Do(func() {
+		e.version = engineAPIVersion
+		if version := strings.TrimPrefix(os.Getenv("DOCKER_API_VERSION"), "v"); version != "" {
+			e.version = version
+			return
+		}
+		resp, err := e.client.Get(e.base + "/_ping")
+		if err != nil {
+			// The request that follows reports that the daemon is not reachable
+			return
+		}
+		resp.Body.Close()
+		if version := resp.Header.Get("Api-Version"); version != "" && compareAPIVersions(version, engineAPIVersion) < 0 {
+			e.version = version
+		}
+	})
+	return e.version
+}
+
+// compareAPIVersions compares API versions like "1.41" by their numbers
+func compareAPIVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
+
+// call sends in as JSON body (if not nil) and decodes the response into out
+// (if not nil)
+func (e *engineClient) call(method, path string, query url.Values, in, out any) error {
+	var body io.Reader
+	header := http.Header{}
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+		header.Set("Content-Type", "application/json")
+	}
+	resp, err := e.request(method, path, query, body, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// isRootless reports whether the daemon runs in a user namespace, like
+// rootless Podman and Docker do
+func (e *engineClient) isRootless() (bool, error) {
+	var info struct {
+		SecurityOptions []string `json:"SecurityOptions"`
+	}
+	if err := e.call("GET", "/info", nil, nil, &info); err != nil {
+		return false, err
+	}
+	for _, option := range info.SecurityOptions {
+		if option == "name=rootless" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (e *engineClient) arch() (string, error) {
+	var version struct {
+		Arch string `json:"Arch"`
+	}
+	err := e.call("GET", "/version", nil, nil, &version)
+	return version.Arch, err
+}
+
+// imageDetails returns "docker image inspect" of one image
+func (e *engineClient) imageDetails(image string) (imageDetails, error) {
+	var details imageDetails
+	err := e.call("GET", "/images/"+image+"/json", nil, nil, &details)
+	return details, err
+}
+
+// imageIDs lists the ids of all local images
+func (e *engineClient) imageIDs() ([]string, error) {
+	var images []struct {
+		ID string `json:"Id"`
+	}
+	if err := e.call("GET", "/images/json", nil, nil, &images); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(images))
+	for i, image := range images {
+		ids[i] = image.ID
+	}
+	return ids, nil
+}
+
+// removeImage deletes an image like "docker image rm"
+func (e *engineClient) removeImage(image string) error {
+	return e.call("DELETE", "/images/"+image, nil, nil, nil)
+}
+
+// containerImage returns the id of the image a container was created from
+func (e *engineClient) containerImage(container string) (string, error) {
+	var details struct {
+		Image string `json:"Image"`
+	}
+	err := e.call("GET", "/containers/"+container+"/json", nil, nil, &details)
+	return details.Image, err
+}
+
+// commitContainer saves the filesystem of the container as an untagged
+// image and returns its id. The container keeps running, the API default
+// would pause it while its filesystem is committed.
+func (e *engineClient) commitContainer(container string) (string, error) {
+	var result struct {
+		ID string `json:"Id"`
+	}
+	err := e.call("POST", "/commit", url.Values{"container": {container}, "pause": {"false"}}, struct{}{}, &result)
+	return result.ID, err
+}
+
+// pullImage pulls the image like "docker pull" and reports its progress on
+// stderr. The platform selects the variant of multi-arch images, the
+// daemon's own one is used if it is empty.
+func (e *engineClient) pullImage(image, platform string, stderr io.Writer) error {
+	name, tag := splitImageTag(image)
+	fmt.Fprintf(stderr, "Pulling %s\n", image)
+	query := url.Values{"fromImage": {name}, "tag": {tag}}
+	if platform != "" {
+		query.Set("platform", platform)
+	}
+	// The daemon does not know the credentials, they come with the request
+	header := http.Header{}
+	auth, ok, err := registryCredentials(imageRegistry(image))
+	if err != nil {
+		return err
+	}
+	if ok {
+		encoded, err := auth.header()
+		if err != nil {
+			return err
+		}
+		header.Set("X-Registry-Auth", encoded)
+	}
+	resp, err := e.request("POST", "/images/create", query, nil, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// The progress is streamed as JSON messages, errors come as one of them.
+	// Only changes of the layer states are printed, not every byte count.
+	decoder := json.NewDecoder(resp.Body)
+	states := make(map[string]string)
+	for {
+		var message struct {
+			ID     string `json:"id"`
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}
+		err := decoder.Decode(&message)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to pull %s: %v", image, err)
+		}
+		if message.Error != "" {
+			return fmt.Errorf("failed to pull %s: %s", image, message.Error)
+		}
+		if message.Status == "" || states[message.ID] == message.Status {
+			continue
+		}
+		states[message.ID] = message.Status
+		if message.ID != "" {
+			fmt.Fprintf(stderr, "%s: %s\n", message.ID, message.Status)
+		} else {
+			fmt.Fprintln(stderr, message.Status)
+		}
+	}
+}
+
+// splitImageTag splits an image reference into the name and the tag or
+// digest to pull, which is latest if none is given
+func splitImageTag(image string) (string, string) {
+	if name, digest, ok := strings.Cut(image, "@"); ok {
+		return name, digest
+	}
+	// A colon before the last slash belongs to a registry port
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, "latest"
+}
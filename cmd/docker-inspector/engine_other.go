@@ -0,0 +1,33 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// defaultDockerHost is the daemon used without DOCKER_HOST
+const defaultDockerHost = "unix:///var/run/docker.sock"
+
+// podmanSocket returns the API socket of rootless Podman (preferred) or
+// of rootful Podman, or "" if none is running
+func podmanSocket() string {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = fmt.Sprintf("/run/user/%d", os.Getuid())
+	}
+	for _, socket := range []string{filepath.Join(runtimeDir, "podman", "podman.sock"), "/run/podman/podman.sock"} {
+		if info, err := os.Stat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+			return socket
+		}
+	}
+	return ""
+}
+
+// dialPipe connects to a Windows named pipe, which only exists on Windows
+func dialPipe(name string) (net.Conn, error) {
+	return nil, fmt.Errorf("named pipes are only supported on Windows")
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// defaultDockerHost is the daemon used without DOCKER_HOST
+const defaultDockerHost = "npipe:////./pipe/docker_engine"
+
+// errorPipeBusy is returned while all instances of the pipe are connected
+const errorPipeBusy = syscall.Errno(231)
+
+// pipeBusyTimeout is how long dialPipe waits for a free pipe instance
+const pipeBusyTimeout = 2 * time.Second
+
+var (
+	modkernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procCreateEventW        = modkernel32.NewProc("CreateEventW")
+	procGetOverlappedResult = modkernel32.NewProc("GetOverlappedResult")
+)
+
+// podmanSocket returns the Podman socket, on Windows Podman machines are
+// reached through DOCKER_HOST or CONTAINER_HOST
+func podmanSocket() string {
+	return ""
+}
+
+// dialPipe connects to the named pipe of the Docker daemon. The pipe is opened
+// for overlapped I/O, so reading and writing do not block each other and
+// deadlines can cancel pending operations.
+func dialPipe(name string) (net.Conn, error) {
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	busyUntil := time.Now().Add(pipeBusyTimeout)
+	for {
+		h, err := syscall.CreateFile(p, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+			syscall.OPEN_EXISTING, syscall.FILE_FLAG_OVERLAPPED, 0)
+		if err == nil {
+			return &pipeConn{h: h, name: name}, nil
+		}
+		if err != errorPipeBusy || time.Now().After(busyUntil) {
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// pipeConn is a connection over a named pipe opened for overlapped I/O
+type pipeConn struct {
+	h    syscall.Handle
+	name string
+	rd   pipeOp
+	wr   pipeOp
+}
+
+// pipeOp runs the reads or the writes of a pipeConn one at a time and
+// cancels the pending one when its deadline expires
+type pipeOp struct {
+	mu sync.Mutex // held for the whole operation
+
+	dmu      sync.Mutex // guards the fields below
+	closed   bool
+	deadline time.Time
+	pending  *syscall.Overlapped
+	timer    *time.Timer
+	expired  bool
+}
+
+// pipeAddr is the address of a named pipe connection
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+func (c *pipeConn) LocalAddr() net.Addr  { return pipeAddr(c.name) }
+func (c *pipeConn) RemoteAddr() net.Addr { return pipeAddr(c.name) }
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n, err := c.do(&c.rd, func(o *syscall.Overlapped, n *uint32) error {
+		return syscall.ReadFile(c.h, b, n, o)
+	})
+	if err == syscall.ERROR_BROKEN_PIPE || err == nil && n == 0 {
+		return n, io.EOF
+	}
+	return n, err
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		n, err := c.do(&c.wr, func(o *syscall.Overlapped, n *uint32) error {
+			return syscall.WriteFile(c.h, b[written:], n, o)
+		})
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Close cancels the pending operations and closes the pipe after they returned
+func (c *pipeConn) Close() error {
+	for _, op := range []*pipeOp{&c.rd, &c.wr} {
+		op.dmu.Lock()
+		if op.closed {
+			op.dmu.Unlock()
+			return net.ErrClosed
+		}
+		op.closed = true
+		op.dmu.Unlock()
+	}
+	syscall.CancelIoEx(c.h, nil)
+	c.rd.mu.Lock()
+	c.wr.mu.Lock()
+	defer c.rd.mu.Unlock()
+	defer c.wr.mu.Unlock()
+	return syscall.CloseHandle(c.h)
+}
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.rd.setDeadline(c.h, t)
+	c.wr.setDeadline(c.h, t)
+	return nil
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.rd.setDeadline(c.h, t)
+	return nil
+}
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	c.wr.setDeadline(c.h, t)
+	return nil
+}
+
+// do starts one overlapped operation with start and waits for its result
+func (c *pipeConn) do(op *pipeOp, start func(*syscall.Overlapped, *uint32) error) (int, error) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	ev, err := createEvent()
+	if err != nil {
+		return 0, err
+	}
+	defer syscall.CloseHandle(ev)
+	o := &syscall.Overlapped{HEvent: ev}
+	var n uint32
+
+	op.dmu.Lock()
+	switch {
+	case op.closed:
+		op.dmu.Unlock()
+		return 0, net.ErrClosed
+	case !op.deadline.IsZero() && !time.Now().Before(op.deadline):
+		op.dmu.Unlock()
+		return 0, os.ErrDeadlineExceeded
+	}
+	op.expired = false
+	err = start(o, &n)
+	if err == syscall.ERROR_IO_PENDING {
+		op.pending = o
+		op.arm(c.h)
+		op.dmu.Unlock()
+		err = getOverlappedResult(c.h, o, &n)
+		op.dmu.Lock()
+		op.pending = nil
+		op.arm(c.h)
+	}
+	closed, expired := op.closed, op.expired
+	op.dmu.Unlock()
+
+	if err == syscall.ERROR_OPERATION_ABORTED {
+		switch {
+		case closed:
+			err = net.ErrClosed
+		case expired:
+			err = os.ErrDeadlineExceeded
+		}
+	}
+	return int(n), err
+}
+
+// setDeadline changes the deadline, a pending operation is cancelled when it expires
+func (op *pipeOp) setDeadline(h syscall.Handle, t time.Time) {
+	op.dmu.Lock()
+	defer op.dmu.Unlock()
+	op.deadline = t
+	op.arm(h)
+}
+
+// arm starts the timer that cancels the pending operation at the deadline,
+// it is called with dmu held
+func (op *pipeOp) arm(h syscall.Handle) {
+	if op.timer != nil {
+		op.timer.Stop()
+		op.timer = nil
+	}
+	if op.pending == nil || op.deadline.IsZero() {
+		return
+	}
+	o := op.pending
+	op.timer = time.AfterFunc(time.Until(op.deadline), func() {
+		op.dmu.Lock()
+		defer op.dmu.Unlock()
+		if op.pending == o {
+			op.expired = true
+			syscall.CancelIoEx(h, o)
+		}
+	})
+}
+
+// createEvent creates the manual reset event signalled when an overlapped
+// operation completes
+func createEvent() (syscall.Handle, error) {
+	r, _, err := procCreateEventW.Call(0, 1, 0, 0)
+	if r == 0 {
+		return 0, err
+	}
+	return syscall.Handle(r), nil
+}
+
+// getOverlappedResult waits for the overlapped operation o and stores the
+// transferred bytes in n
+func getOverlappedResult(h syscall.Handle, o *syscall.Overlapped, n *uint32) error {
+	r, _, err := procGetOverlappedResult.Call(uintptr(h), uintptr(unsafe.Pointer(o)), uintptr(unsafe.Pointer(n)), 1)
+	if r == 0 {
+		var errno syscall.Errno
+		if errors.As(err, &errno) && errno != 0 {
+			return errno
+		}
+		return syscall.EINVAL
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// schemaVersion is increased on incompatible changes of the JSON output
+const schemaVersion = 1
+
+// Envelope wraps the JSON listing with the provenance of the results
+type Envelope struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Tool          string `json:"tool"`
+	Image         string `json:"image"`
+	// ImageDigest is the content addressed image id
+	ImageDigest string `json:"imageDigest,omitempty"`
+	// Platform is the os/architecture of the inspected image variant
+	Platform    string    `json:"platform,omitempty"`
+	InspectedAt time.Time `json:"inspectedAt"`
+	// Args are the command line arguments the results were created with
+	Args  []string   `json:"args"`
+	Files []FileInfo `json:"files"`
+}
+
+// newEnvelope collects the metadata of an inspection of image
+func newEnvelope(image string, files []FileInfo) Envelope {
+	details, err := lookupImage(image)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Cannot determine digest of %s: %v\n", image, err)
+	}
+	if files == nil {
+		files = []FileInfo{}
+	}
+	return Envelope{
+		SchemaVersion: schemaVersion,
+		Tool:          Args{}.Version(),
+		Image:         image,
+		ImageDigest:   details.ID,
+		Platform:      details.platform(),
+		InspectedAt:   time.Now().UTC(),
+		Args:          os.Args[1:],
+		Files:         files,
+	}
+}
+
+// platform returns the platform of the image like --platform takes it
+func (d imageDetails) platform() string {
+	if d.Os == "" || d.Architecture == "" {
+		return ""
+	}
+	platform := d.Os + "/" + d.Architecture
+	if d.Variant != "" {
+		platform += "/" + d.Variant
+	}
+	return platform
+}
+
+// imageDigest asks docker or the registry for the id of the image
+func imageDigest(image string) (string, error) {
+	details, err := lookupImage(image)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image: %v", err)
+	}
+	return details.ID, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// GrepMatch is a line of a file matching --grep
+type GrepMatch struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// printGrepMatches prints the matching lines of the files like grep -n
+// does for several files: path, line number and line
+func printGrepMatches(w io.Writer, files []FileInfo) error {
+	for _, file := range files {
+		for _, match := range file.Matches {
+			if _, err := fmt.Fprintf(w, "%s:%d:%s\n", file.Path, match.Line, match.Text); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// GrowthReport attributes the size change between two images to the files
+// and directories that contributed most to it
+type GrowthReport struct {
+	OldSize     int64         `json:"oldSize"`
+	NewSize     int64         `json:"newSize"`
+	Delta       int64         `json:"delta"`
+	Files       []GrowthEntry `json:"files"`
+	Directories []GrowthEntry `json:"directories"`
+}
+
+type GrowthEntry struct {
+	Path  string `json:"path"`
+	Delta int64  `json:"delta"`
+}
+
+// growthReport returns the total size change and the n paths and
+// directories (with depth path components) that grew the most
+func growthReport(files1, files2 []FileInfo, result *Result, n, depth int) *GrowthReport {
+	report := &GrowthReport{
+		OldSize: buildSizeTree(files1).Size,
+		NewSize: buildSizeTree(files2).Size,
+	}
+	report.Delta = report.NewSize - report.OldSize
+
+	byDir := make(map[string]int64)
+	var files []GrowthEntry
+	for _, diff := range result.Differences {
+		delta := contentSize(diff.NewFile) - contentSize(diff.OldFile)
+		if delta == 0 {
+			continue
+		}
+		files = append(files, GrowthEntry{Path: diff.Path, Delta: delta})
+		byDir[rollupDir(diff.Path, depth)] += delta
+	}
+	var dirs []GrowthEntry
+	for dir, delta := range byDir {
+		dirs = append(dirs, GrowthEntry{Path: dir, Delta: delta})
+	}
+	report.Files = topGrowth(files, n)
+	report.Directories = topGrowth(dirs, n)
+	return report
+}
+
+// topGrowth returns the n entries that grew the most, biggest first
+func topGrowth(entries []GrowthEntry, n int) []GrowthEntry {
+	top := []GrowthEntry{}
+	for _, entry := range entries {
+		if entry.Delta > 0 {
+			top = append(top, entry)
+		}
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Delta != top[j].Delta {
+			return top[i].Delta > top[j].Delta
+		}
+		return top[i].Path < top[j].Path
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+func printGrowthReport(w io.Writer, report *GrowthReport, human bool) {
+	fmt.Fprintf(w, "Size growth: %s (%s -> %s)\n", formatDelta(report.Delta, human),
+		formatTotal(report.OldSize, human), formatTotal(report.NewSize, human))
+	for _, section := range []struct {
+		title   string
+		entries []GrowthEntry
+	}{{"Directories", report.Directories}, {"Files", report.Files}} {
+		if len(section.entries) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s that grew the most:\n", section.title)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		for _, entry := range section.entries {
+			fmt.Fprintf(tw, "%s\t  %s\n", formatDelta(entry.Delta, human), entry.Path)
+		}
+		tw.Flush()
+	}
+	fmt.Fprintln(w)
+}
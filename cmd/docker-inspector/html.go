@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"time"
+)
+
+// htmlReport is the data of the --html report
+type htmlReport struct {
+	Title     string
+	Generated string
+	Files     []FileInfo
+	Tree      *sizeNode
+	Result    *Result
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"human": humanSize,
+	"time": func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format("2006-01-02 15:04:05")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+table { border-collapse: collapse; font-family: monospace; }
+th { cursor: pointer; text-align: left; background: #eee; position: sticky; top: 0; }
+th, td { padding: 2px 8px; border-bottom: 1px solid #ddd; white-space: nowrap; }
+td.num { text-align: right; }
+tr.added td { background: #e6ffe6; }
+tr.removed td { background: #ffe6e6; }
+tr.modified td { background: #fff8e0; }
+ul.tree { list-style: none; font-family: monospace; padding-left: 1.2em; }
+input { margin: 0.5em 0; width: 30em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Generated {{.Generated}}</p>
+{{with .Result}}
+<h2>Differences</h2>
+<p>{{.Summary.TotalDifferences}} differences: {{.Summary.AddedFiles}} added, {{.Summary.RemovedFiles}} removed, {{.Summary.ModifiedFiles}} modified</p>
+<input type="search" placeholder="Filter" oninput="filterTable('diffs', this.value)">
+<table id="diffs">
+<thead><tr><th>Change</th><th>Path</th><th>Details</th></tr></thead>
+<tbody>
+{{range .Differences}}<tr class="{{.Type}}"><td>{{.Type}}</td><td>{{.Path}}</td><td>{{range $i, $d := .Details}}{{if $i}}<br>{{end}}{{$d}}{{end}}</td></tr>
+{{end}}</tbody>
+</table>
+{{end}}
+{{if .Files}}
+<h2>Directory sizes</h2>
+<ul class="tree">{{template "node" .Tree}}</ul>
+<h2>Files</h2>
+<input type="search" placeholder="Filter" oninput="filterTable('files', this.value)">
+<table id="files">
+<thead><tr><th>Mode</th><th>Size</th><th>Modified</th><th>User</th><th>Group</th><th>Path</th><th>Link</th></tr></thead>
+<tbody>
+{{range .Files}}<tr><td>{{.Mode}}</td><td class="num" data-value="{{.Size}}">{{.Size}}</td><td>{{time .ModTime}}</td><td>{{.User}}</td><td>{{.Group}}</td><td>{{.Path}}</td><td>{{if .SymlinkTo}}-&gt; {{.SymlinkTo}}{{else if .HardlinkTo}}=&gt; {{.HardlinkTo}}{{end}}</td></tr>
+{{end}}</tbody>
+</table>
+{{end}}
+<script>
+function filterTable(id, text) {
+	text = text.toLowerCase();
+	for (const row of document.getElementById(id).tBodies[0].rows) {
+		row.style.display = row.textContent.toLowerCase().includes(text) ? "" : "none";
+	}
+}
+for (const th of document.querySelectorAll("th")) {
+	th.addEventListener("click", () => {
+		const table = th.closest("table");
+		const body = table.tBodies[0];
+		const column = th.cellIndex;
+		const ascending = table.dataset.column != column || table.dataset.order != "asc";
+		const value = cell => cell.dataset.value !== undefined ? Number(cell.dataset.value) : cell.textContent;
+		const rows = Array.from(body.rows).sort((a, b) => {
+			const x = value(a.cells[column]), y = value(b.cells[column]);
+			return (x < y ? -1 : x > y ? 1 : 0) * (ascending ? 1 : -1);
+		});
+		table.dataset.column = column;
+		table.dataset.order = ascending ? "asc" : "desc";
+		body.append(...rows);
+	});
+}
+</script>
+</body>
+</html>
+{{define "node"}}<li>{{if .Children}}<details{{if eq .Path "/"}} open{{end}}><summary>{{.Name}} ({{human .Size}})</summary><ul class="tree">{{range .Children}}{{template "node" .}}{{end}}</ul></details>{{else}}{{.Name}} ({{human .Size}}){{end}}</li>{{end}}
+`))
+
+// writeHTML renders the listing or the comparison result into a single
+// static HTML file
+func writeHTML(htmlPath, title string, files []FileInfo, result *Result) error {
+	report := htmlReport{
+		Title:     title,
+		Generated: time.Now().Format(time.RFC1123),
+		Files:     files,
+		Result:    result,
+	}
+	if files != nil {
+		report.Tree = buildSizeTree(files)
+	}
+
+	f, err := os.Create(htmlPath)
+	if err != nil {
+		return fmt.Errorf("failed to create HTML report: %v", err)
+	}
+	if err := htmlTemplate.Execute(f, report); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to render HTML report: %v", err)
+	}
+	return f.Close()
+}
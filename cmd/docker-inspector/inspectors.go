@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// inspectorFor returns the embedded inspector built for the architecture
+// of the image: the one of --platform, of the local image or, for images
+// that are pulled first, the daemon's own.
+func inspectorFor(image, platform string) ([]byte, error) {
+	arch, err := imageArch(image, platform)
+	if err != nil {
+		return nil, err
+	}
+	inspector, err := internalInspectors.ReadFile("internal-inspector-" + arch)
+	if err != nil {
+		return nil, fmt.Errorf("no inspector for %s images, available are: %s", arch, strings.Join(inspectorArchs(), ", "))
+	}
+	return inspector, nil
+}
+
+// imageArch returns the GOARCH of the image the inspector runs in
+func imageArch(image, platform string) (string, error) {
+	if platform != "" {
+		return strings.Split(platform, "/")[1], nil
+	}
+	engine, err := containerEngine()
+	if err != nil {
+		return "", err
+	}
+	if details, err := engine.imageDetails(image); err == nil && details.Architecture != "" {
+		return details.Architecture, nil
+	}
+	arch, err := engine.arch()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine the architecture of the daemon: %v", err)
+	}
+	return arch, nil
+}
+
+// inspectorArchs lists the architectures there are inspectors for
+func inspectorArchs() []string {
+	var archs []string
+	names, _ := fs.Glob(internalInspectors, "internal-inspector-*")
+	for _, name := range names {
+		archs = append(archs, strings.TrimPrefix(name, "internal-inspector-"))
+	}
+	return archs
+}
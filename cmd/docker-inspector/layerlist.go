@@ -0,0 +1,474 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/oderwat/docker-inspector/internal/filter"
+	"github.com/oderwat/docker-inspector/internal/xattr"
+)
+
+// maxSymlinkHops limits following chains of symlinks like the kernel does
+const maxSymlinkHops = 40
+
+// blockSize is the allocation unit disk usage of files read from layers
+// is estimated with, as they have no st_blocks
+const blockSize = 4096
+
+// listLayerFiles lists the merged files of image layers like the inspector
+// lists the files of a container, with the same filters
+func listLayerFiles(entries map[string]*layerEntry, args Args) ([]FileInfo, error) {
+	userNames := parseIDNames(entries["/etc/passwd"])
+	groupNames := parseIDNames(entries["/etc/group"])
+
+	// Case insensitive matching compares lowercased patterns and paths
+	patterns, iglobs, excludes, exprs := args.Patterns, args.IGlobs, args.Excludes, args.Regexes
+	if args.IgnoreCase {
+		iglobs = append(append([]string{}, iglobs...), patterns...)
+		patterns = nil
+		excludes = filter.LowerAll(excludes)
+		exprs = make([]string, len(args.Regexes))
+		for i, expr := range args.Regexes {
+			exprs[i] = "(?i)" + expr
+		}
+	}
+	iglobs = filter.LowerAll(iglobs)
+	regexes, err := filter.CompileRegexes(exprs)
+	if err != nil {
+		return nil, err
+	}
+	ignoreRules := filter.ParseIgnoreRules(args.ignores)
+	typeFilter, err := filter.ParseTypeFilter(args.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// Hardlinks get the inode of the file they point to, so they are
+	// grouped like in a container
+	links := make(map[string]*layerInode)
+	for _, name := range names {
+		key := contentPath(entries, entries[name])
+		if links[key] == nil {
+			links[key] = &layerInode{number: uint64(len(links) + 1)}
+		}
+		links[key].count++
+	}
+	// Directories are linked by their name and by the ".." of every
+	// subdirectory
+	subdirs := make(map[string]uint64)
+	for _, name := range names {
+		if name != "/" && entries[name].header.Typeflag == tar.TypeDir {
+			subdirs[path.Dir(name)]++
+		}
+	}
+	describe := func(entry *layerEntry, root string) FileInfo {
+		file := layerFileInfo(entries, entry, root, userNames, groupNames, args)
+		if file.IsDir {
+			file.Links = 2 + subdirs[file.Path]
+		} else {
+			inode := links[contentPath(entries, entry)]
+			file.Inode, file.Links = inode.number, inode.count
+		}
+		return file
+	}
+
+	// matches applies the filters of the inspector, pruned reports
+	// directories whose contents are skipped
+	matches := func(name string, entry *layerEntry) (match, prune bool, err error) {
+		header := entry.header
+		isDir := header.Typeflag == tar.TypeDir
+		// The inspector skips the mounts of the container
+		if name == "/proc" || name == "/sys" || name == "/dev" {
+			return false, true, nil
+		}
+		if filter.IsIgnored(ignoreRules, name, isDir) {
+			return false, isDir, nil
+		}
+		if len(excludes) > 0 {
+			excludePath := name
+			if args.IgnoreCase {
+				excludePath = strings.ToLower(name)
+			}
+			excluded, err := filter.MatchesAny(excludes, excludePath)
+			if err != nil || excluded {
+				return false, isDir, err
+			}
+		}
+		if len(patterns) > 0 || len(iglobs) > 0 || len(regexes) > 0 {
+			match, err := filter.MatchesAny(patterns, name)
+			if err != nil {
+				return false, false, err
+			}
+			if !match {
+				if match, err = filter.MatchesAny(iglobs, strings.ToLower(name)); err != nil {
+					return false, false, err
+				}
+			}
+			if !match && !filter.MatchesAnyRegex(regexes, name) {
+				return false, false, nil
+			}
+		}
+		if typeFilter != nil && !typeFilter[entryType(header)] {
+			return false, false, nil
+		}
+		if args.MinSize != nil || args.MaxSize != nil {
+			size := entrySize(entries, entry)
+			if isDir || (args.MinSize != nil && size < int64(*args.MinSize)) || (args.MaxSize != nil && size > int64(*args.MaxSize)) {
+				return false, false, nil
+			}
+		}
+		if (args.NewerThan != nil && !header.ModTime.After(args.NewerThan.Time)) ||
+			(args.OlderThan != nil && !header.ModTime.Before(args.OlderThan.Time)) {
+			return false, false, nil
+		}
+		uid, gid := uint32(header.Uid), uint32(header.Gid)
+		if (args.UID != nil && uid != *args.UID) || (args.GID != nil && gid != *args.GID) ||
+			(args.User != "" && userNames[uid] != args.User) || (args.Group != "" && groupNames[gid] != args.Group) {
+			return false, false, nil
+		}
+		return true, false, nil
+	}
+
+	var files []FileInfo
+	listed := make(map[string]bool)
+	visit := func(name, root string) error {
+		if listed[name] {
+			return nil
+		}
+		entry := entries[name]
+		match, _, err := matches(name, entry)
+		if err != nil || !match {
+			return err
+		}
+		listed[name] = true
+		files = append(files, describe(entry, root))
+		return nil
+	}
+
+	if args.PathsFrom != "" {
+		// An explicit list of paths is looked up without walking
+		for _, name := range args.pathList {
+			name = path.Clean(name)
+			if _, ok := entries[name]; !ok {
+				fmt.Fprintf(os.Stderr, "Warning: Cannot access %s: no such file or directory\n", name)
+				continue
+			}
+			if err := visit(name, "/"); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		for _, root := range layerRoots(args.Paths) {
+			if _, ok := entries[root]; !ok {
+				fmt.Fprintf(os.Stderr, "Warning: Cannot access %s: no such file or directory\n", root)
+				continue
+			}
+			var pruned []string
+			for _, name := range names {
+				if !isBelow(name, root) || isBelowAny(name, pruned) {
+					continue
+				}
+				// Directories at the depth limit are listed, not their contents
+				if args.MaxDepth > 0 && pathDepth(root, name) > args.MaxDepth {
+					continue
+				}
+				entry := entries[name]
+				match, prune, err := matches(name, entry)
+				if err != nil {
+					return nil, err
+				}
+				if prune {
+					pruned = append(pruned, name)
+				}
+				if !match || listed[name] {
+					continue
+				}
+				listed[name] = true
+				files = append(files, describe(entry, root))
+			}
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	markLayerHardlinks(files)
+	return files, nil
+}
+
+// layerFileInfo describes a merged file like the inspector describes the
+// files of a container
+func layerFileInfo(entries map[string]*layerEntry, entry *layerEntry, root string, userNames, groupNames map[uint32]string, args Args) FileInfo {
+	header := entry.header
+	name := header.Name
+	// Hardlinks share the inode of the file they point to, with its
+	// metadata, contents and hashes
+	content := entries[contentPath(entries, entry)]
+	inode := content.header
+	uid, gid := uint32(inode.Uid), uint32(inode.Gid)
+	mode := inode.FileInfo().Mode()
+	file := FileInfo{
+		Path:      name,
+		Size:      entrySize(entries, entry),
+		Mode:      mode.String(),
+		Type:      entryType(header),
+		IsDir:     header.Typeflag == tar.TypeDir,
+		UID:       &uid,
+		GID:       &gid,
+		UserName:  userNames[uid],
+		GroupName: groupNames[gid],
+		User:      fmt.Sprintf("%s(%d)", userNames[uid], uid),
+		Group:     fmt.Sprintf("%s(%d)", groupNames[gid], gid),
+	}
+	if file.Type == "file" || file.IsDir {
+		file.AllocatedSize = (file.Size + blockSize - 1) / blockSize * blockSize
+		if file.IsDir {
+			file.AllocatedSize = blockSize
+		}
+	}
+	if header.Typeflag == tar.TypeSymlink {
+		file.SymlinkTo = header.Linkname
+		resolved, ok := resolveLayerPath(entries, name)
+		if !ok {
+			file.SymlinkBroken = true
+			resolved = header.Linkname
+			if !path.IsAbs(resolved) {
+				resolved = path.Join(resolveLayerDir(entries, path.Dir(name)), resolved)
+			}
+			resolved = path.Clean(resolved)
+		}
+		file.SymlinkResolved = resolved
+		// The root is compared resolved like the link, so links under a
+		// --path like /bin -> usr/bin don't escape it
+		file.SymlinkEscapes = !isBelow(resolved, resolveLayerDir(entries, root))
+	}
+	if inode.Typeflag == tar.TypeChar || inode.Typeflag == tar.TypeBlock {
+		file.Major, file.Minor = uint32(inode.Devmajor), uint32(inode.Devminor)
+	}
+	if !args.NoTimes {
+		modTime := inode.ModTime.UTC()
+		file.ModTime = &modTime
+	}
+
+	if (args.MD5 || args.SHA256) && file.Type == "file" && file.Size > 0 {
+		file.MD5, file.SHA256, file.HashSkipped = content.md5, content.sha256, content.hashSkipped
+	}
+
+	// Extended attributes are stored as PAX records
+	xattrs := make(map[string]string)
+	for key, value := range inode.PAXRecords {
+		if name, ok := strings.CutPrefix(key, "SCHILY.xattr."); ok {
+			xattrs[name] = xattr.EncodeValue([]byte(value))
+		}
+	}
+	if file.Type == "file" {
+		if value, ok := inode.PAXRecords["SCHILY.xattr.security.capability"]; ok {
+			if caps, err := xattr.DecodeCapabilities([]byte(value)); err == nil {
+				file.Capabilities = caps
+			}
+		}
+	}
+	if args.Xattrs && file.SymlinkTo == "" && len(xattrs) > 0 {
+		file.Xattrs = xattrs
+	}
+	return file
+}
+
+// entryType names the type of a layer entry like the inspector does
+func entryType(header *tar.Header) string {
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeChar:
+		return "chardev"
+	case tar.TypeBlock:
+		return "blockdev"
+	case tar.TypeFifo:
+		return "fifo"
+	}
+	if header.FileInfo().Mode()&fs.ModeSocket != 0 {
+		return "socket"
+	}
+	return "file"
+}
+
+// layerInode is the inode shared by a file and its hardlinks
+type layerInode struct {
+	number uint64
+	count  uint64
+}
+
+// contentPath returns the path of the file holding the contents of an
+// entry, which is the file a hardlink points to
+func contentPath(entries map[string]*layerEntry, entry *layerEntry) string {
+	if entry.header.Typeflag == tar.TypeLink {
+		if target := layerPath(entry.header.Linkname); entries[target] != nil {
+			return target
+		}
+	}
+	return entry.header.Name
+}
+
+// entrySize returns the size of a file, hardlinks are stored without
+// contents and have the size of the file they point to
+func entrySize(entries map[string]*layerEntry, entry *layerEntry) int64 {
+	switch entry.header.Typeflag {
+	case tar.TypeDir:
+		return blockSize
+	case tar.TypeSymlink:
+		return int64(len(entry.header.Linkname))
+	}
+	return entries[contentPath(entries, entry)].header.Size
+}
+
+// resolveLayerPath resolves the symlinks of a path in the merged files like
+// filepath.EvalSymlinks does in a container, it reports false if a part of
+// it does not exist
+func resolveLayerPath(entries map[string]*layerEntry, name string) (string, bool) {
+	resolved := "/"
+	rest := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	for hops := 0; len(rest) > 0; {
+		part := rest[0]
+		rest = rest[1:]
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." {
+			resolved = path.Dir(resolved)
+			continue
+		}
+		next := path.Join(resolved, part)
+		entry, ok := entries[next]
+		if !ok {
+			return "", false
+		}
+		if entry.header.Typeflag != tar.TypeSymlink {
+			resolved = next
+			continue
+		}
+		if hops++; hops > maxSymlinkHops {
+			return "", false
+		}
+		target := entry.header.Linkname
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+	return resolved, true
+}
+
+// resolveLayerDir returns dir with its symlinks resolved, or dir itself if it
+// can't be resolved
+func resolveLayerDir(entries map[string]*layerEntry, dir string) string {
+	if resolved, ok := resolveLayerPath(entries, dir); ok {
+		return resolved
+	}
+	return dir
+}
+
+// markLayerHardlinks points every additional member of a hardlink group to
+// the first listed path of that group, like the inspector does
+func markLayerHardlinks(files []FileInfo) {
+	first := make(map[uint64]string)
+	for i := range files {
+		file := &files[i]
+		if file.IsDir || file.SymlinkTo != "" || file.Links < 2 {
+			continue
+		}
+		if path, ok := first[file.Inode]; ok {
+			file.HardlinkTo = path
+		} else {
+			first[file.Inode] = file.Path
+		}
+	}
+}
+
+// parseIDNames reads the names of a passwd or group file of the image, the
+// first entry of an id wins like with getpwuid
+func parseIDNames(entry *layerEntry) map[uint32]string {
+	names := make(map[uint32]string)
+	if entry == nil {
+		return names
+	}
+	for _, line := range strings.Split(string(entry.data), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		if _, ok := names[uint32(id)]; !ok {
+			names[uint32(id)] = fields[0]
+		}
+	}
+	return names
+}
+
+// layerRoots cleans the --path roots and drops those inside of another one,
+// so no file is listed twice
+func layerRoots(paths []string) []string {
+	if len(paths) == 0 {
+		return []string{"/"}
+	}
+	cleaned := make([]string, len(paths))
+	for i, p := range paths {
+		cleaned[i] = path.Clean("/" + p)
+	}
+	var roots []string
+	for _, p := range cleaned {
+		nested := false
+		for _, other := range cleaned {
+			if other != p && isBelow(p, other) {
+				nested = true
+			}
+		}
+		if !nested && !slices.Contains(roots, p) {
+			roots = append(roots, p)
+		}
+	}
+	return roots
+}
+
+// isBelow reports whether name is root or inside of root
+func isBelow(name, root string) bool {
+	return root == "/" || name == root || strings.HasPrefix(name, root+"/")
+}
+
+// isBelowAny reports whether name is inside of one of the directories
+func isBelowAny(name string, dirs []string) bool {
+	for _, dir := range dirs {
+		if isBelow(name, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathDepth returns the number of path components of name below root
+func pathDepth(root, name string) int {
+	rel := strings.TrimPrefix(strings.TrimPrefix(name, root), "/")
+	if rel == "" {
+		return 0
+	}
+	return strings.Count(rel, "/") + 1
+}
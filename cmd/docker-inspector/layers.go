@@ -0,0 +1,207 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// Whiteouts of the OCI image spec: a ".wh.NAME" entry deletes NAME of the
+// lower layers, an opaque whiteout hides everything lower layers put into
+// its directory
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// imageLayer is a filesystem layer of an image read without a container
+// runtime, from a registry or an image archive
+type imageLayer struct {
+	// Digest identifies the layer in messages
+	Digest string
+	open   func() (io.ReadCloser, error)
+}
+
+// layerEntry is a file of the merged layers
+type layerEntry struct {
+	header *tar.Header
+	// layer is the index of the layer the entry comes from
+	layer       int
+	md5         string
+	sha256      string
+	hashSkipped bool
+	// data holds the contents of the passwd and group files, which are
+	// needed for the owner names
+	data []byte
+}
+
+// mergeLayers applies the layers on top of each other like the overlay
+// filesystem of a container does and returns the resulting files by their
+// absolute path. Regular files are hashed while they are read, if --md5 or
+// --sha256 ask for it.
+func mergeLayers(layers []imageLayer, args Args) (map[string]*layerEntry, error) {
+	entries := make(map[string]*layerEntry)
+	for i, layer := range layers {
+		if err := applyLayer(entries, i, layer, args); err != nil {
+			return nil, fmt.Errorf("failed to read layer %s: %v", layer.Digest, err)
+		}
+	}
+	// Parent directories (and the root) are implied if no layer has an
+	// entry for them, like tar creates them when extracting
+	for name := range entries {
+		for dir := path.Dir(name); ; dir = path.Dir(dir) {
+			if _, ok := entries[dir]; ok {
+				break
+			}
+			entries[dir] = impliedDir(dir)
+			if dir == "/" {
+				break
+			}
+		}
+	}
+	if _, ok := entries["/"]; !ok {
+		entries["/"] = impliedDir("/")
+	}
+	return entries, nil
+}
+
+// impliedDir is a directory no layer has an entry for, it gets the epoch as
+// modification time like reproducible builds use
+func impliedDir(name string) *layerEntry {
+	return &layerEntry{header: &tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0755, ModTime: time.Unix(0, 0)}}
+}
+
+// applyLayer puts the entries of a layer into the merged files
+func applyLayer(entries map[string]*layerEntry, index int, layer imageLayer, args Args) error {
+	rc, err := layer.open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	r, err := decompressLayer(rc)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			// Reading up to the end lets the digest of the layer be verified
+			_, err = io.Copy(io.Discard, rc)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		name := layerPath(header.Name)
+		dir, base := path.Split(name)
+		if base == whiteoutOpaque {
+			removeBelow(entries, path.Clean(dir), index)
+			continue
+		}
+		if strings.HasPrefix(base, whiteoutPrefix) {
+			// Whiteouts hide what lower layers put there, never entries of
+			// their own layer
+			deleted := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+			if existing, ok := entries[deleted]; ok && existing.layer < index {
+				delete(entries, deleted)
+			}
+			removeBelow(entries, deleted, index)
+			continue
+		}
+		// A directory replaced by something else takes its contents along
+		if existing, ok := entries[name]; ok && existing.header.Typeflag == tar.TypeDir && header.Typeflag != tar.TypeDir {
+			removeBelow(entries, name, -1)
+		}
+		header.Name = name
+		entry := &layerEntry{header: header, layer: index}
+		if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA {
+			if err := readLayerFile(entry, tr, args); err != nil {
+				return fmt.Errorf("failed to read %s: %v", name, err)
+			}
+		}
+		entries[name] = entry
+	}
+}
+
+// readLayerFile hashes the contents of a regular file and keeps the
+// contents of the passwd and group files
+func readLayerFile(entry *layerEntry, r io.Reader, args Args) error {
+	var writers []io.Writer
+	var md5Hash, sha256Hash hash.Hash
+	if (args.MD5 || args.SHA256) && entry.header.Size > 0 {
+		if args.MaxHashSize > 0 && entry.header.Size > int64(args.MaxHashSize) {
+			entry.hashSkipped = true
+		} else {
+			if args.MD5 {
+				md5Hash = md5.New()
+				writers = append(writers, md5Hash)
+			}
+			if args.SHA256 {
+				sha256Hash = sha256.New()
+				writers = append(writers, sha256Hash)
+			}
+		}
+	}
+	var data bytes.Buffer
+	if entry.header.Name == "/etc/passwd" || entry.header.Name == "/etc/group" {
+		writers = append(writers, &data)
+	}
+	if len(writers) == 0 {
+		return nil
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return err
+	}
+	if md5Hash != nil {
+		entry.md5 = hex.EncodeToString(md5Hash.Sum(nil))
+	}
+	if sha256Hash != nil {
+		entry.sha256 = hex.EncodeToString(sha256Hash.Sum(nil))
+	}
+	if data.Len() > 0 {
+		entry.data = data.Bytes()
+	}
+	return nil
+}
+
+// removeBelow deletes everything inside of dir that comes from a layer
+// below the given one, -1 deletes it regardless of the layer
+func removeBelow(entries map[string]*layerEntry, dir string, layer int) {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	for name, entry := range entries {
+		if strings.HasPrefix(name, prefix) && (layer < 0 || entry.layer < layer) {
+			delete(entries, name)
+		}
+	}
+}
+
+// layerPath turns the name of a layer entry like "./usr/bin/" into the
+// absolute path in the image
+func layerPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// decompressLayer unpacks gzip compressed layers, which are recognized by
+// their magic bytes as the media types of archives are not reliable
+func decompressLayer(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return nil, fmt.Errorf("zstd compressed layers are not supported")
+	}
+	return br, nil
+}
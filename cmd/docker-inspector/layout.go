@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ociPrefix marks an OCI image layout directory, like buildx and skopeo
+// export images, as oci:/path/to/layout[:tag]
+const ociPrefix = "oci:"
+
+// Annotations naming the images of an OCI image layout
+const (
+	refNameAnnotation        = "org.opencontainers.image.ref.name"
+	containerdNameAnnotation = "io.containerd.image.name"
+)
+
+// localImage is an image read from local files, an image archive or an OCI
+// image layout directory
+type localImage struct {
+	name string
+	// open returns a reader of a file by its slash separated path
+	open func(name string) (io.ReadCloser, error)
+	// configPath and layerPaths are the files of the image
+	configPath string
+	layerPaths []string
+	tags       []string
+	config     imageConfig
+	// id is the digest of the config like docker uses it as image id
+	id string
+}
+
+// openLocalImage finds the image in the files: the manifest.json of docker
+// save, otherwise the index.json of an OCI layout with the manifest of the
+// tag (if not empty) and platform
+func openLocalImage(name string, open func(name string) (io.ReadCloser, error), tag, platform string) (*localImage, error) {
+	image := &localImage{name: name, open: open}
+	if data, err := image.read("manifest.json"); err == nil && tag == "" {
+		var manifests []struct {
+			Config   string   `json:"Config"`
+			RepoTags []string `json:"RepoTags"`
+			Layers   []string `json:"Layers"`
+		}
+		if err := json.Unmarshal(data, &manifests); err != nil {
+			return nil, fmt.Errorf("invalid manifest.json in %s: %v", name, err)
+		}
+		if len(manifests) != 1 {
+			return nil, fmt.Errorf("%s has %d images, save only the one to inspect", name, len(manifests))
+		}
+		image.configPath, image.layerPaths, image.tags = manifests[0].Config, manifests[0].Layers, manifests[0].RepoTags
+	} else {
+		m, err := resolveOCIIndex(image.read, tag, platform)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		if image.configPath, err = blobPath(m.Config.Digest); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		for _, layer := range m.Layers {
+			layerPath, err := blobPath(layer.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			image.layerPaths = append(image.layerPaths, layerPath)
+		}
+		if tag != "" {
+			image.tags = []string{tag}
+		}
+	}
+
+	data, err := image.read(image.configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the config of %s: %v", name, err)
+	}
+	if err := json.Unmarshal(data, &image.config); err != nil {
+		return nil, fmt.Errorf("invalid image config in %s: %v", name, err)
+	}
+	sum := sha256.Sum256(data)
+	image.id = "sha256:" + hex.EncodeToString(sum[:])
+	return image, nil
+}
+
+// read returns the contents of a file of the image
+func (i *localImage) read(name string) ([]byte, error) {
+	rc, err := i.openBlob(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// openBlob opens a file of the image, blobs named by their digest are
+// verified against it while they are read
+func (i *localImage) openBlob(name string) (io.ReadCloser, error) {
+	rc, err := i.open(name)
+	digest := blobDigest(name)
+	if err != nil || digest == "" {
+		return rc, err
+	}
+	r, err := newVerifiedReader(rc, digest)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, rc}, nil
+}
+
+func (i *localImage) layers() ([]imageLayer, error) {
+	layers := make([]imageLayer, len(i.layerPaths))
+	for n, name := range i.layerPaths {
+		name := name
+		layers[n] = imageLayer{Digest: name, open: func() (io.ReadCloser, error) {
+			return i.openBlob(name)
+		}}
+	}
+	return layers, nil
+}
+
+func (i *localImage) details() (imageDetails, error) {
+	details := imageDetails{
+		ID:           i.id,
+		RepoTags:     i.tags,
+		Os:           i.config.OS,
+		Architecture: i.config.Architecture,
+		Variant:      i.config.Variant,
+	}
+	details.Config.Labels = i.config.Config.Labels
+	details.RootFS.Layers = i.config.RootFS.DiffIDs
+	return details, nil
+}
+
+// openOCILayout reads the image of an oci:/path/to/layout[:tag] reference
+func openOCILayout(reference, platform string) (*localImage, error) {
+	dir, tag := parseOCIReference(reference)
+	if _, err := os.Stat(filepath.Join(dir, "index.json")); err != nil {
+		return nil, fmt.Errorf("%s is no OCI image layout: %v", dir, err)
+	}
+	open := func(name string) (io.ReadCloser, error) {
+		return os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+	}
+	return openLocalImage(dir, open, tag, platform)
+}
+
+// parseOCIReference splits an oci: reference into the layout directory and
+// the tag, a colon is part of the directory if the directory exists with it
+func parseOCIReference(reference string) (string, string) {
+	dir := strings.TrimPrefix(reference, ociPrefix)
+	if _, err := os.Stat(dir); err == nil {
+		return dir, ""
+	}
+	if i := strings.LastIndex(dir, ":"); i > strings.LastIndexAny(dir, `/\`) {
+		return dir[:i], dir[i+1:]
+	}
+	return dir, ""
+}
+
+// resolveOCIIndex follows the index.json of an OCI image layout to the
+// manifest of the tag and platform, through nested indexes like docker and
+// buildx write them
+func resolveOCIIndex(read func(name string) ([]byte, error), tag, platform string) (manifest, error) {
+	data, err := read("index.json")
+	if err != nil {
+		return manifest{}, fmt.Errorf("neither manifest.json of docker save nor index.json of an OCI layout found")
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return manifest{}, fmt.Errorf("invalid index.json: %v", err)
+	}
+	if tag != "" {
+		if m.Manifests, err = selectTag(m.Manifests, tag); err != nil {
+			return manifest{}, err
+		}
+	} else if names := imageNames(m.Manifests); len(names) > 1 {
+		return manifest{}, fmt.Errorf("the layout has the images %s, select one with oci:DIR:TAG", strings.Join(names, ", "))
+	}
+	for depth := 0; len(m.Manifests) > 0; depth++ {
+		if depth == 8 {
+			return manifest{}, fmt.Errorf("too deeply nested indexes")
+		}
+		selected := m.Manifests[0]
+		if len(m.Manifests) > 1 {
+			if selected, err = selectPlatform(m.Manifests, platform); err != nil {
+				return manifest{}, err
+			}
+		}
+		blob, err := blobPath(selected.Digest)
+		if err != nil {
+			return manifest{}, err
+		}
+		if data, err = read(blob); err != nil {
+			return manifest{}, err
+		}
+		m = manifest{}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return manifest{}, fmt.Errorf("invalid manifest %s: %v", selected.Digest, err)
+		}
+	}
+	if m.Config.Digest == "" {
+		return manifest{}, fmt.Errorf("no image manifest found")
+	}
+	return m, nil
+}
+
+// selectTag keeps the manifests of an index named by the tag, skopeo names
+// them with the tag and buildx and containerd also with the full reference
+func selectTag(manifests []descriptor, tag string) ([]descriptor, error) {
+	var selected []descriptor
+	for _, m := range manifests {
+		name := m.Annotations[refNameAnnotation]
+		if name == tag || strings.HasSuffix(m.Annotations[containerdNameAnnotation], ":"+tag) {
+			selected = append(selected, m)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no image tagged %s, available are %s", tag, strings.Join(imageNames(manifests), ", "))
+	}
+	return selected, nil
+}
+
+// imageNames returns the distinct names of the images of an index
+func imageNames(manifests []descriptor) []string {
+	var names []string
+	for _, m := range manifests {
+		if name := m.Annotations[refNameAnnotation]; name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// blobDigestPattern is the digest of a blob, anything else could lead the
+// blob path out of the layout
+var blobDigestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// blobPath is the file of a blob in an OCI image layout
+func blobPath(digest string) (string, error) {
+	if !blobDigestPattern.MatchString(digest) {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return "blobs/" + strings.Replace(digest, ":", "/", 1), nil
+}
+
+// blobDigest returns the digest of a blob file, it is empty for other files
+// like the layers of docker save before Docker 25
+func blobDigest(name string) string {
+	rest, ok := strings.CutPrefix(path.Clean(name), "blobs/")
+	digest := strings.Replace(rest, "/", ":", 1)
+	if !ok || !blobDigestPattern.MatchString(digest) {
+		return ""
+	}
+	return digest
+}
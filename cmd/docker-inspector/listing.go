@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// printListing writes the inspection results in the selected output format
+func printListing(w io.Writer, files []FileInfo, args Args) error {
+	switch args.Format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if args.BareJSON {
+			return encoder.Encode(files)
+		}
+		return encoder.Encode(newEnvelope(args.Image1, files))
+	case "ndjson":
+		encoder := json.NewEncoder(w)
+		for _, file := range files {
+			if err := encoder.Encode(file); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "mtree":
+		return printMtree(w, files)
+	case "checksums":
+		return printChecksums(w, files, args)
+	case "print0":
+		for _, file := range files {
+			if _, err := fmt.Fprintf(w, "%s\x00", file.Path); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "ls":
+		return printLs(w, files)
+	case "template":
+		return printTemplate(w, files, args.template)
+	default:
+		if args.Grep != "" {
+			return printGrepMatches(w, files)
+		}
+		printTable(w, files, args)
+		return nil
+	}
+}
+
+// tableColumn is a column of the table output
+type tableColumn struct {
+	header string
+	value  func(file FileInfo, args Args) string
+}
+
+// tableColumns are the columns selectable with --columns
+var tableColumns = map[string]tableColumn{
+	"mode":     {"Mode", func(file FileInfo, args Args) string { return file.Mode }},
+	"size":     {"Size", formatSize},
+	"modified": {"Modified", formatModTime},
+	"user":     {"User", func(file FileInfo, args Args) string { return file.User }},
+	"group":    {"Group", func(file FileInfo, args Args) string { return file.Group }},
+	"path":     {"Path", formatPath},
+	"symlink":  {"Symlink", formatLinkTarget},
+	"type":     {"Type", func(file FileInfo, args Args) string { return file.ContentType }},
+	"elf": {"ELF", func(file FileInfo, args Args) string {
+		if file.Elf == nil {
+			return ""
+		}
+		return file.Elf.String()
+	}},
+	"entropy": {"Entropy", func(file FileInfo, args Args) string { return fmt.Sprintf("%.3f", file.Entropy) }},
+	"md5": {"MD5", func(file FileInfo, args Args) string {
+		if file.HashSkipped {
+			return "(skipped)"
+		}
+		return file.MD5
+	}},
+	"sha256": {"SHA256", func(file FileInfo, args Args) string {
+		if file.HashSkipped {
+			return "(skipped)"
+		}
+		return file.SHA256
+	}},
+}
+
+// defaultColumns returns the columns shown without --columns, which depend
+// on the information that was requested
+func defaultColumns(args Args) []string {
+	columns := []string{"mode", "size"}
+	if !args.NoTimes {
+		columns = append(columns, "modified")
+	}
+	columns = append(columns, "user", "group", "path", "symlink")
+	if args.DetectTypes || args.ContentType != "" {
+		columns = append(columns, "type")
+	}
+	if args.Elf {
+		columns = append(columns, "elf")
+	}
+	if args.Entropy {
+		columns = append(columns, "entropy")
+	}
+	if args.MD5 {
+		columns = append(columns, "md5")
+	}
+	if args.SHA256 {
+		columns = append(columns, "sha256")
+	}
+	return columns
+}
+
+// parseColumns splits a comma separated --columns list and validates it
+func parseColumns(list string) ([]string, error) {
+	var columns []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := tableColumns[name]; !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns = append(columns, name)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no columns selected")
+	}
+	return columns, nil
+}
+
+func formatSize(file FileInfo, args Args) string {
+	// Device nodes show their device numbers like ls does
+	if file.Type == "chardev" || file.Type == "blockdev" {
+		return fmt.Sprintf("%d, %d", file.Major, file.Minor)
+	}
+	return formatBytes(file.Size, args.Human)
+}
+
+func formatModTime(file FileInfo, args Args) string {
+	if file.ModTime == nil {
+		return ""
+	}
+	return file.ModTime.Format("2006-01-02 15:04:05")
+}
+
+func formatPath(file FileInfo, args Args) string {
+	if file.Capabilities != "" {
+		return file.Path + " [" + file.Capabilities + "]"
+	}
+	return file.Path
+}
+
+func formatLinkTarget(file FileInfo, args Args) string {
+	if file.SymlinkTo != "" {
+		symlink := "-> " + file.SymlinkTo
+		if file.SymlinkBroken {
+			symlink += " (broken)"
+		}
+		if file.SymlinkEscapes {
+			symlink += " (escapes --path)"
+		}
+		return symlink
+	} else if file.HardlinkTo != "" {
+		return "=> " + file.HardlinkTo
+	}
+	return ""
+}
+
+// printTable writes the inspection results as table with an optional summary
+func printTable(out io.Writer, files []FileInfo, args Args) {
+	var totalSize, allocatedSize int64
+	sparseCount := 0
+	dirCount := 0
+	fileCount := 0
+	hardlinkCount := 0
+	columns := args.columns
+	if len(columns) == 0 {
+		columns = defaultColumns(args)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+	headers := make([]string, len(columns))
+	for i, name := range columns {
+		headers[i] = tableColumns[name].header
+	}
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+
+	values := make([]string, len(columns))
+	for _, file := range files {
+		if file.IsDir {
+			dirCount++
+		} else {
+			fileCount++
+		}
+		// Hardlinked files share their data with the first link
+		if file.HardlinkTo != "" {
+			hardlinkCount++
+		} else {
+			totalSize += file.Size
+			allocatedSize += file.AllocatedSize
+		}
+		if file.Sparse {
+			sparseCount++
+		}
+
+		for i, name := range columns {
+			values[i] = tableColumns[name].value(file, args)
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+	w.Flush()
+
+	// Print summary if requested
+	if args.Summary {
+		fmt.Fprintf(out, "\nSummary:\n")
+		fmt.Fprintf(out, "Total size: %s\n", formatTotal(totalSize, args.Human))
+		fmt.Fprintf(out, "Disk usage: %s\n", formatTotal(allocatedSize, args.Human))
+		fmt.Fprintf(out, "Directories: %d\n", dirCount)
+		fmt.Fprintf(out, "Files: %d\n", fileCount)
+		if sparseCount > 0 {
+			fmt.Fprintf(out, "Sparse files: %d\n", sparseCount)
+		}
+		if hardlinkCount > 0 {
+			fmt.Fprintf(out, "Hardlinks: %d (not counted in total size)\n", hardlinkCount)
+		}
+	}
+}
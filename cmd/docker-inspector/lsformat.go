@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// lsTypes maps our file types to the type character used by ls -l
+var lsTypes = map[string]byte{
+	"file":     '-',
+	"dir":      'd',
+	"symlink":  'l',
+	"chardev":  'c',
+	"blockdev": 'b',
+	"fifo":     'p',
+	"socket":   's',
+}
+
+// printLs writes the files like "ls -lAR --time-style=long-iso" does: one
+// block per directory with a "total" line, entries sorted by name and
+// subdirectories following their parent.
+func printLs(w io.Writer, files []FileInfo) error {
+	listed := make(map[string]bool)
+	entries := make(map[string][]FileInfo)
+	for _, file := range files {
+		listed[file.Path] = true
+		if file.IsDir {
+			entries[file.Path] = nil
+		}
+	}
+	for _, file := range files {
+		dir := path.Dir(file.Path)
+		// Like with "ls -R DIR", a walked directory is the start of a block
+		// and not an entry of its parent
+		if file.Path == "/" || (file.IsDir && !listed[dir]) {
+			continue
+		}
+		entries[dir] = append(entries[dir], file)
+	}
+
+	// Sorting the directories by their components gives the order of ls -R
+	dirs := make([]string, 0, len(entries))
+	for dir := range entries {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return lessComponents(dirs[i], dirs[j])
+	})
+
+	for i, dir := range dirs {
+		if i > 0 {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		if err := printLsDir(w, dir, entries[dir]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printLsDir(w io.Writer, dir string, files []FileInfo) error {
+	sort.Slice(files, func(i, j int) bool {
+		return path.Base(files[i].Path) < path.Base(files[j].Path)
+	})
+
+	// Columns are padded to the widest entry of the directory
+	var blocks int64
+	var linksWidth, userWidth, groupWidth, sizeWidth, majorWidth, minorWidth int
+	for _, file := range files {
+		// ls rounds the usage of every file up to whole 1K blocks
+		blocks += (file.AllocatedSize + 1023) / 1024
+		linksWidth = max(linksWidth, len(strconv.FormatUint(file.Links, 10)))
+		userWidth = max(userWidth, len(lsOwner(file.User)))
+		groupWidth = max(groupWidth, len(lsOwner(file.Group)))
+		if file.Type == "chardev" || file.Type == "blockdev" {
+			majorWidth = max(majorWidth, len(strconv.FormatUint(uint64(file.Major), 10)))
+			minorWidth = max(minorWidth, len(strconv.FormatUint(uint64(file.Minor), 10)))
+		} else {
+			sizeWidth = max(sizeWidth, len(strconv.FormatInt(file.Size, 10)))
+		}
+	}
+	if majorWidth > 0 {
+		sizeWidth = max(sizeWidth, majorWidth+2+minorWidth)
+	}
+
+	if _, err := fmt.Fprintf(w, "%s:\ntotal %d\n", dir, blocks); err != nil {
+		return err
+	}
+	for _, file := range files {
+		size := strconv.FormatInt(file.Size, 10)
+		if file.Type == "chardev" || file.Type == "blockdev" {
+			size = fmt.Sprintf("%*d, %*d", majorWidth, file.Major, minorWidth, file.Minor)
+		}
+		line := fmt.Sprintf("%s %*d %-*s %-*s %*s ",
+			lsMode(file), linksWidth, file.Links,
+			userWidth, lsOwner(file.User), groupWidth, lsOwner(file.Group),
+			sizeWidth, size)
+		if file.ModTime != nil {
+			line += file.ModTime.Format("2006-01-02 15:04") + " "
+		}
+		line += path.Base(file.Path)
+		if file.SymlinkTo != "" {
+			line += " -> " + file.SymlinkTo
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lsMode formats the type and permissions like ls -l (e.g. "-rwsr-xr-x")
+func lsMode(file FileInfo) string {
+	bits := permBits(file.Mode)
+	mode := []byte("?---------")
+	if t, ok := lsTypes[file.Type]; ok {
+		mode[0] = t
+	}
+	for i, c := range "rwxrwxrwx" {
+		if bits&(1<<(8-i)) != 0 {
+			mode[i+1] = byte(c)
+		}
+	}
+	// Special bits replace the execute permission and are uppercase without it
+	special := []struct {
+		bit uint32
+		pos int
+		c   byte
+	}{{04000, 3, 's'}, {02000, 6, 's'}, {01000, 9, 't'}}
+	for _, s := range special {
+		if bits&s.bit == 0 {
+			continue
+		}
+		if mode[s.pos] == '-' {
+			mode[s.pos] = s.c - 'a' + 'A'
+		} else {
+			mode[s.pos] = s.c
+		}
+	}
+	return string(mode)
+}
+
+// lsOwner returns the name of a "name(id)" owner or the numeric id of an
+// owner without name, like ls shows unknown owners
+func lsOwner(s string) string {
+	if name := ownerName(s); name != "" {
+		return name
+	}
+	return ownerKey(s, OwnersByID)
+}
+
+// lessComponents compares paths component by component, so a directory
+// sorts directly before its contents
+func lessComponents(a, b string) bool {
+	as := strings.Split(strings.Trim(a, "/"), "/")
+	bs := strings.Split(strings.Trim(b, "/"), "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
+}
@@ -1,38 +1,162 @@
 package main
 
 import (
-	_ "embed"
+	"bytes"
+	"embed"
 	"encoding/json"
 	"fmt"
 	"github.com/alexflint/go-arg"
+	"github.com/oderwat/docker-inspector/internal/filter"
+	"io"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
-	"text/tabwriter"
+	"text/template"
+	"time"
 )
 
-//go:embed internal-inspector
-var internalInspector []byte
+// internalInspectors holds the inspector for every image architecture as
+// internal-inspector-GOARCH
+//
+//go:embed internal-inspector-*
+var internalInspectors embed.FS
 
 type Args struct {
-	Image1  string `arg:"positional,required" help:"docker image to inspect (or first image when comparing)"`
-	Image2  string `arg:"positional" help:"second docker image (for comparison mode)"`
-	Path    string `arg:"--path" default:"/" help:"path inside the container to inspect"`
-	JSON    bool   `arg:"--json" help:"output in JSON format"`
-	Summary bool   `arg:"--summary" help:"show summary statistics"`
-	Pattern string `arg:"--glob" help:"glob pattern for matching files (supports **/)"`
-	MD5     bool   `arg:"--md5" help:"calculate MD5 checksums for files"`
-	Keep    bool   `arg:"--keep" help:"keep the temporary container after inspection"`
-	NoTimes bool   `arg:"--no-times" help:"exclude modification times from output"`
+	Image1      string   `arg:"positional,required" help:"docker image to inspect (or first image when comparing), registry://IMAGE, a docker save archive FILE.tar or an OCI layout oci:DIR[:TAG] is read without container runtime"`
+	Image2      string   `arg:"positional" help:"second docker image (for comparison mode)"`
+	Images      []string `arg:"positional" help:"more images for comparing a family of images (shows which files differ in which image)"`
+	Against     string   `arg:"--against" help:"compare the image against this saved JSON or NDJSON listing (may be gzip compressed)"`
+	AgainstDir  string   `arg:"--against-dir" help:"compare the image against this local directory, e.g. a checked out rootfs"`
+	AgainstBase bool     `arg:"--against-base" help:"compare the image against the base image it was built FROM (must be available locally)"`
+	// root is the local directory inspected instead of the image filesystem
+	root string
+	// stderr prefixes the messages of concurrent inspections
+	stderr         *prefixWriter
+	Baseline       string   `arg:"--baseline" help:"compare against this baseline listing and fail on differences, it is created if missing"`
+	UpdateBaseline bool     `arg:"--update-baseline" help:"rewrite the --baseline file from the image instead of comparing"`
+	Paths          []string `arg:"--path,separate" help:"path inside the container to inspect (repeatable) [default: /]"`
+	PathsFrom      string   `arg:"--paths-from" help:"inspect exactly the paths listed in this file (- for stdin) instead of walking"`
+	// pathList holds the paths read by --paths-from
+	pathList       []string
+	JSON           bool   `arg:"--json" help:"output in JSON format (same as --format json)"`
+	Format         string `arg:"--format" help:"output format: table, json, ndjson (one JSON object per line, streamed) mtree, ls (like ls -lAR --time-style=long-iso), checksums (for sha256sum -c) or porcelain (comparisons) [default: table]"`
+	BareJSON       bool   `arg:"--bare-json" help:"write the JSON listing as plain array without the metadata envelope like older versions did"`
+	Print0         bool   `arg:"--print0" help:"print only the paths, separated by NUL bytes (for xargs -0)"`
+	Porcelain      bool   `arg:"--porcelain" help:"print one stable, tab separated line per change like git status --porcelain (same as --format porcelain)"`
+	FormatTemplate string `arg:"--format-template" help:"print every file with this Go template, e.g. '{{.Path}}\\t{{.Size}}'"`
+	// template is the parsed --format-template
+	template *template.Template
+	// streamNDJSON makes the inspector print NDJSON while walking
+	streamNDJSON bool
+	Output       string `arg:"-o,--output" help:"write the results to this file instead of stdout, gzip compressed if it ends with .gz"`
+	SQLite       string `arg:"--sqlite" help:"also write the results into this SQLite database (requires the sqlite3 tool)"`
+	HTML         string `arg:"--html" help:"also write the listing or comparison as self-contained HTML report to this file"`
+	Treemap      string `arg:"--treemap" help:"also write the directory sizes as treemap to this file, SVG for .svg names and JSON (for d3) otherwise"`
+	Sort         string `arg:"--sort" help:"sort the listing by path, size, mtime or owner [default: path]"`
+	Reverse      bool   `arg:"--reverse" help:"reverse the sort order"`
+	Top          int    `arg:"--top" help:"only show the N largest files with their share of the total size"`
+	Human        bool   `arg:"-H,--human" help:"print sizes like 1.2K, 34M or 2.1G in text output"`
+	Columns      string `arg:"--columns" help:"comma separated table columns in order: mode, size, modified, user, group, path, symlink, type, elf, entropy, md5, sha256"`
+	// columns holds the parsed --columns
+	columns       []string
+	Summary       bool      `arg:"--summary" help:"show summary statistics"`
+	Patterns      []string  `arg:"--glob,separate" help:"glob pattern for matching files (supports **/, repeatable)"`
+	IGlobs        []string  `arg:"--iglob,separate" help:"case insensitive glob pattern for matching files (repeatable)"`
+	IgnoreCase    bool      `arg:"--ignore-case" help:"match --glob, --exclude and --regex case insensitive"`
+	Excludes      []string  `arg:"--exclude,separate" help:"glob pattern for files to skip, excluded directories are not descended into (repeatable)"`
+	Regexes       []string  `arg:"--regex,separate" help:"regular expression matched against the full path, alternative to --glob (repeatable)"`
+	MaxDepth      int       `arg:"--max-depth" help:"descend at most this many directory levels below --path"`
+	OneFileSystem bool      `arg:"--one-file-system" help:"stay on the image filesystem, skipping volumes and other mounts"`
+	MinSize       *ByteSize `arg:"--min-size" help:"only list files of at least this size (e.g. 50MB)"`
+	MaxSize       *ByteSize `arg:"--max-size" help:"only list files of at most this size (0 lists empty files)"`
+	NewerThan     *TimeSpec `arg:"--newer-than" help:"only list files modified after this time or age (e.g. 2024-01-31 or 30d)"`
+	OlderThan     *TimeSpec `arg:"--older-than" help:"only list files modified before this time or age (e.g. 2024-01-31 or 52w)"`
+	UID           *uint32   `arg:"--uid" help:"only list files owned by this user id"`
+	User          string    `arg:"--user" help:"only list files owned by this user name (as known in the image)"`
+	GID           *uint32   `arg:"--gid" help:"only list files owned by this group id"`
+	Group         string    `arg:"--group" help:"only list files owned by this group name (as known in the image)"`
+	Type          string    `arg:"--type" help:"only list entries of these types like find: f, d, l, c, b, p, s (comma separated)"`
+	Perm          string    `arg:"--perm" help:"only list entries matching a find style permission predicate, e.g. --perm=-4000 (setuid) or --perm=/o+w (world writable)"`
+	IgnoreFile    string    `arg:"--ignore-file" help:"file with gitignore style patterns to skip [default: .dockerinspectorignore if present]"`
+	// ignores holds the patterns read from the ignore file
+	ignores []string
+	MD5     bool `arg:"--md5" help:"calculate MD5 checksums for files"`
+	SHA256  bool `arg:"--sha256" help:"calculate SHA256 checksums for files"`
+	// MaxHashSize limits hashing, bigger files are compared by size only
+	MaxHashSize  ByteSize `arg:"--max-hash-size" help:"skip hashing of files larger than this size (e.g. 100MB)"`
+	NoAutoHash   bool     `arg:"--no-auto-hash" help:"when comparing without --md5 or --sha256, do not hash files of equal size to find content changes"`
+	NoRenames    bool     `arg:"--no-renames" help:"report moved files as removed and added instead of renamed when comparing"`
+	Only         []string `arg:"--only,separate" help:"show only these kinds of changes: added, removed, modified, renamed (comma separated or repeatable)"`
+	FailOn       []string `arg:"--fail-on,separate" help:"exit with status 1 only for these kinds of changes: added, removed, modified, renamed, content (modified contents) or none (comma separated or repeatable)"`
+	MaxDiffs     int      `arg:"--max-diffs" help:"show the details of at most this many differences (the summary counts all)"`
+	MaxDiffsJSON bool     `arg:"--max-diffs-json" help:"apply --max-diffs to JSON and NDJSON output too"`
+	SummaryOnly  bool     `arg:"--summary-only" help:"print only the counters and size changes of a comparison, without the details of every file"`
+	// only holds the change types parsed from --only
+	only            []Change
+	RollupDepth     int           `arg:"--rollup-depth" default:"2" help:"summarize the differences per directory with this many path components (0 disables)"`
+	Growth          int           `arg:"--growth" help:"report the total size change and the N files and directories that grew the most"`
+	DiffIgnores     []string      `arg:"--diff-ignore,separate" help:"glob pattern for files to leave out of comparisons only (repeatable)"`
+	DiffIgnoreFile  string        `arg:"--diff-ignore-file" help:"file with --diff-ignore patterns, one per line"`
+	IgnorePresets   []string      `arg:"--ignore-preset,separate" help:"leave known noisy files out of comparisons: container-runtime, apt, apk, dnf, pip, npm, ldconfig, logs (comma separated, see \"docker-inspector presets\")"`
+	PresetFile      string        `arg:"--preset-file" help:"file with additional or extended presets [default: .dockerinspectorpresets]"`
+	IgnoreOwnership bool          `arg:"--ignore-ownership" help:"do not report user and group changes when comparing"`
+	CompareOwners   string        `arg:"--compare-owners" help:"compare owners by numeric ids or by names only (ids or names), e.g. when /etc/passwd differs"`
+	IgnorePerms     bool          `arg:"--ignore-perms" help:"do not report permission changes when comparing"`
+	IgnoreSize      bool          `arg:"--ignore-size" help:"do not report size changes when comparing (content changes still are)"`
+	TimeTolerance   time.Duration `arg:"--time-tolerance" help:"do not report modification time differences up to this duration (e.g. 2s)"`
+	ContentDiff     bool          `arg:"--content-diff" help:"show a unified diff of modified text files when comparing"`
+	ContentDiffMax  ByteSize      `arg:"--content-diff-max" default:"256K" help:"largest file size for --content-diff"`
+	Keep            bool          `arg:"--keep" help:"keep the temporary container after inspection"`
+	RuntimeArgs
+	Username      string `arg:"--username" help:"registry user for pulling private images, the password is read with --password-stdin"`
+	PasswordStdin bool   `arg:"--password-stdin" help:"read the registry password or token for --username from stdin"`
+	CredHelper    string `arg:"--credential-helper" help:"get the registry credentials from docker-credential-HELPER instead of the docker config"`
+	Registry      string `arg:"--registry" help:"registry the --username or --credential-helper credentials are sent to, other registries use the docker config [default: the registry of the first image]"`
+	NoTimes       bool   `arg:"--no-times" help:"exclude modification times from output"`
+	Xattrs        bool   `arg:"--xattrs" help:"collect extended attributes of files"`
+	Labels        bool   `arg:"--labels" help:"collect SELinux/AppArmor security labels of files"`
+	AttrFlags     bool   `arg:"--attr-flags" help:"collect ext2/ext4 attribute flags like lsattr (immutable, append only, ...)"`
+	Entropy       bool   `arg:"--entropy" help:"calculate the (sampled) Shannon entropy of files in bits per byte"`
+	DetectTypes   bool   `arg:"--detect-types" help:"classify file contents by their magic bytes (elf, script/sh, text, gzip, ...)"`
+	ContentType   string `arg:"--content-type" help:"only list files with this content type, e.g. script or image/png (implies --detect-types)"`
+	Grep          string `arg:"--grep" help:"only list text files containing this regular expression and print the matching lines like grep -n"`
+	FixedStrings  bool   `arg:"-F,--fixed-strings" help:"take the --grep pattern literally instead of as regular expression"`
+	Elf           bool   `arg:"--elf" help:"report architecture, linkage, interpreter and stripped flag of ELF binaries"`
+	// BrokenSymlinks switches to a report of dangling symlinks only
+	BrokenSymlinks bool `arg:"--broken-symlinks" help:"only report symlinks whose target does not exist in the image"`
 	// for extraction
-	OutputDir           string `arg:"--output-dir" help:"extract matching files to this directory"`
-	StripComponents     int    `arg:"--strip-components" help:"strip NUMBER leading components from file names"`
-	PreserveOwner       bool   `arg:"--preserve-owner" help:"preserve user/group information when extracting"`
-	PreservePermissions bool   `arg:"--preserve-perms" help:"preserve file permissions when extracting"`
-	PreserveAll         bool   `arg:"--preserve-all" help:"preserve all file attributes"`
+	OutputDir           string   `arg:"--output-dir" help:"extract matching files to this directory"`
+	Overwrite           string   `arg:"--overwrite" help:"what to do with existing files when extracting: always (replace), never, update (only changed files) or backup (keep the old file with a ~ suffix) [default: always]"`
+	ExtractGlobs        []string `arg:"--extract-glob,separate" help:"only extract files matching this glob pattern, the listing still shows all matching files (repeatable)"`
+	ExtractExcludes     []string `arg:"--extract-exclude,separate" help:"do not extract files matching this glob pattern, they are still listed (repeatable)"`
+	FollowUnsafe        bool     `arg:"--follow-unsafe-symlinks" help:"extract through symlinks that point outside of --output-dir (only for trusted images)"`
+	Progress            bool     `arg:"--progress" help:"report the progress of extracting on stderr (files done, bytes copied and the current file)"`
+	Incremental         bool     `arg:"--incremental" help:"skip files that are unchanged since the last extraction of the same image digest (tracked in OUTPUT-DIR.cache.json)"`
+	Sync                bool     `arg:"--sync" help:"make --output-dir mirror the matching files: only changed files are copied and files no longer in the image are deleted"`
+	DryRun              bool     `arg:"--dry-run" help:"print which files --output-dir would extract to which destination without writing them"`
+	ExtractVia          string   `arg:"--extract-via" help:"how extracted files leave the container: mount (bind mount of --output-dir), tar (stream unpacked locally, works with remote daemons) or auto [default: auto]"`
+	ExtractBoth         string   `arg:"--extract-both" help:"when comparing two images, extract their matching files to DIR/old and DIR/new for diff -r or meld"`
+	StripComponents     int      `arg:"--strip-components" help:"strip NUMBER leading components from file names"`
+	PreserveOwner       bool     `arg:"--preserve-owner" help:"preserve user/group information when extracting"`
+	PreservePermissions bool     `arg:"--preserve-perms" help:"preserve file permissions when extracting"`
+	PreserveXattrs      bool     `arg:"--preserve-xattrs" help:"restore extended attributes when extracting (implies --xattrs)"`
+	PreserveTimes       bool     `arg:"--preserve-times" help:"preserve modification times of files and directories when extracting"`
+	PreserveAll         bool     `arg:"--preserve-all" help:"preserve all file attributes"`
+	DeferOwners         bool     `arg:"--defer-owners" help:"write the ownership to OUTPUT-DIR.owners.json instead of applying it, for \"docker-inspector apply-owners\" later (no root or sudo needed)"`
+	OwnerMap            string   `arg:"--owner-map" help:"translate owner ids when extracting, e.g. 0:1000,33:1001 (u or g prefix maps only user or group ids, implies --preserve-owner)"`
+	Tar                 string   `arg:"--tar" help:"write matching files as tar archive to this file (- for stdout) instead of listing them"`
+	Zip                 string   `arg:"--zip" help:"write matching files as zip archive to this file (- for stdout) instead of listing them"`
+	Cat                 string   `arg:"--cat" help:"print the contents of this file of the image to stdout instead of listing files (symlinks are followed)"`
+	// owners is the parsed --owner-map
+	owners ownerMap
+	// unchanged holds the paths --incremental does not extract again
+	unchanged []string
 }
 
 func (Args) Version() string {
@@ -40,180 +164,573 @@ func (Args) Version() string {
 }
 
 func (Args) Description() string {
-	return "Docker image content inspector - examines, extracts and compares files inside container images"
+	return "Docker image content inspector - examines, extracts and compares files inside container images\n" +
+		"Run \"docker-inspector snapshot IMAGE\" to save a listing for comparisons with --against\n" +
+		"Run \"docker-inspector drift CONTAINER\" to compare a container with its image\n" +
+		"Run \"docker-inspector presets\" to list the --ignore-preset patterns\n" +
+		"Run \"docker-inspector apply-owners DIR.owners.json\" to apply ownership recorded with --defer-owners"
 }
 
-func printDiffText(result *Result) {
+func printDiffText(w io.Writer, result *Result, args Args) {
 	// Print summary
-	fmt.Printf("\nComparison Summary:\n")
-	fmt.Printf("Total differences: %d\n", result.Summary.TotalDifferences)
-	fmt.Printf("Added files: %d\n", result.Summary.AddedFiles)
-	fmt.Printf("Removed files: %d\n", result.Summary.RemovedFiles)
-	fmt.Printf("Modified files: %d\n\n", result.Summary.ModifiedFiles)
+	fmt.Fprintf(w, "\nComparison Summary:\n")
+	fmt.Fprintf(w, "Total differences: %d\n", result.Summary.TotalDifferences)
+	fmt.Fprintf(w, "Added files: %d\n", result.Summary.AddedFiles)
+	fmt.Fprintf(w, "Removed files: %d\n", result.Summary.RemovedFiles)
+	fmt.Fprintf(w, "Modified files: %d\n", result.Summary.ModifiedFiles)
+	if result.Summary.ModifiedFiles > 0 {
+		fmt.Fprintf(w, "  metadata only: %d, content: %d, both: %d\n", result.Summary.MetadataOnlyFiles,
+			result.Summary.ContentModifiedFiles, result.Summary.ContentAndMetadataFiles)
+	}
+	fmt.Fprintf(w, "Renamed files: %d\n", result.Summary.RenamedFiles)
+	fmt.Fprintf(w, "Size change: %s\n\n", formatDelta(result.Summary.SizeDelta, args.Human))
+	if len(result.Summary.Directories) > 0 {
+		printDirectorySummary(w, result.Summary.Directories, args.Human)
+	}
+	if len(result.Summary.Extensions) > 0 {
+		printExtensionSummary(w, result.Summary.Extensions, args.Human)
+	}
+	if result.Growth != nil {
+		printGrowthReport(w, result.Growth, args.Human)
+	}
 
 	// Print detailed differences
-	if len(result.Differences) > 0 {
-		fmt.Println("Details:")
-		for _, diff := range result.Differences {
+	if len(result.Differences) > 0 && !args.SummaryOnly {
+		fmt.Fprintln(w, "Details:")
+		for i, diff := range result.Differences {
+			if args.MaxDiffs > 0 && i == args.MaxDiffs {
+				fmt.Fprintf(w, "… and %d more\n", len(result.Differences)-i)
+				break
+			}
 			switch diff.Type {
 			case Added:
-				fmt.Printf("+ %s\n", diff.Path)
-				fmt.Printf("  (%d bytes, %s:%s, mode %s)\n",
-					diff.NewFile.Size, diff.NewFile.User, diff.NewFile.Group, diff.NewFile.Mode)
+				fmt.Fprintf(w, "+ %s\n", diff.Path)
+				fmt.Fprintf(w, "  (%s, %s:%s, mode %s)\n",
+					formatTotal(diff.NewFile.Size, args.Human), diff.NewFile.User, diff.NewFile.Group, diff.NewFile.Mode)
 			case Removed:
-				fmt.Printf("- %s\n", diff.Path)
-				fmt.Printf("  (%d bytes, %s:%s, mode %s)\n",
-					diff.OldFile.Size, diff.OldFile.User, diff.OldFile.Group, diff.OldFile.Mode)
+				fmt.Fprintf(w, "- %s\n", diff.Path)
+				fmt.Fprintf(w, "  (%s, %s:%s, mode %s)\n",
+					formatTotal(diff.OldFile.Size, args.Human), diff.OldFile.User, diff.OldFile.Group, diff.OldFile.Mode)
+			case Renamed:
+				fmt.Fprintf(w, "R %s -> %s\n", diff.OldPath, diff.Path)
+				for _, detail := range diff.Details {
+					fmt.Fprintf(w, "  %s\n", detail)
+				}
+				fmt.Fprint(w, diff.ContentDiff)
 			case Modified:
-				fmt.Printf("M %s\n", diff.Path)
+				fmt.Fprintf(w, "M %s (%s)\n", diff.Path, diff.ChangeClass)
 				for _, detail := range diff.Details {
-					fmt.Printf("  %s\n", detail)
+					fmt.Fprintf(w, "  %s\n", detail)
 				}
+				fmt.Fprint(w, diff.ContentDiff)
 			}
 		}
 	}
 }
 
 func runInspector(image string, args Args) ([]byte, error) {
-	// Create a temporary directory for the inspector
-	tempDir, err := os.MkdirTemp("", "docker-inspector-*")
-	if err != nil {
-		return nil, fmt.Errorf("failed to create temp dir: %v", err)
-	}
-	defer os.RemoveAll(tempDir)
+	var output bytes.Buffer
+	err := streamInspector(image, args, &output)
+	return output.Bytes(), err
+}
 
-	// Write the embedded Linux inspector to the temp directory with executable permissions
-	inspectorPath := filepath.Join(tempDir, "internal-inspector")
-	if err := os.WriteFile(inspectorPath, internalInspector, 0755); err != nil {
-		return nil, fmt.Errorf("failed to write inspector: %v", err)
+// streamInspector runs the inspector in a container of image and writes its
+// output to w while it is produced
+func streamInspector(image string, args Args, w io.Writer) error {
+	if isDaemonless(image) {
+		return inspectImageSource(image, args, w)
 	}
-
-	// Ensure the inspector is executable on the host
-	if err := os.Chmod(inspectorPath, 0755); err != nil {
-		return nil, fmt.Errorf("failed to make inspector executable: %v", err)
+	inspector, err := inspectorFor(image, args.Platform)
+	if err != nil {
+		return err
 	}
-
-	// Start building the docker run command
-	dockerArgs := []string{"run"}
-	if !args.Keep {
-		dockerArgs = append(dockerArgs, "--rm")
+	// The inspector is copied into the container, host directories are
+	// mounted for extracting and inspecting local directories
+	spec := containerSpec{
+		Image:    image,
+		Files:    []containerFile{{Path: "inspect", Mode: 0755, Data: inspector}},
+		Keep:     args.Keep,
+		Platform: args.Platform,
+		Pull:     args.Pull,
 	}
 
 	// If output directory is specified, mount it
-	if args.OutputDir != "" {
+	tarExtract := args.OutputDir != "" && args.ExtractVia == "tar"
+	if args.OutputDir != "" && !tarExtract {
 		// Convert to absolute path
 		absPath, err := filepath.Abs(args.OutputDir)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get absolute path for output dir: %v", err)
+			return fmt.Errorf("failed to get absolute path for output dir: %v", err)
 		}
 
 		// Create the output directory if it doesn't exist
 		if err := os.Mkdir(absPath, 0755); err != nil && !os.IsExist(err) {
-			return nil, fmt.Errorf("failed to create output directory: %v", err)
+			return fmt.Errorf("failed to create output directory: %v", err)
 		}
 
-		dockerArgs = append(dockerArgs,
-			"-v", fmt.Sprintf("%s:/inspect-target", absPath))
+		spec.Binds = append(spec.Binds, fmt.Sprintf("%s:/inspect-target", absPath))
 	}
 
-	/*
-		// Add capabilities if we need to preserve ownership
-		if args.OutputDir != "" && args.PreserveOwner {
-			// Option 1: Full privileged mode (more than we need, but guaranteed to work)
-			//dockerArgs = append(dockerArgs, "--privileged")
-				// Option 2: Just the capabilities we need (more secure)
-				dockerArgs = append(dockerArgs,
-					"--cap-add=CHOWN",
-					"--cap-add=DAC_OVERRIDE",
-					"--cap-add=DAC_READ_SEARCH")
+	// A local directory is mounted read-only and inspected as root filesystem
+	if args.root != "" {
+		absPath, err := filepath.Abs(args.root)
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path for %s: %v", args.root, err)
 		}
-	*/
-
-	// Mount the inspector and set it as entrypoint
-	dockerArgs = append(dockerArgs,
-		"-v", fmt.Sprintf("%s:/inspect:ro", inspectorPath),
-		"--entrypoint", "/inspect",
-		image)
+		spec.Binds = append(spec.Binds, fmt.Sprintf("%s:/inspect-root:ro", absPath))
+		// chroot needs root, which images with another USER don't run as
+		spec.User = "0"
+	}
 
 	// Add inspector arguments
-	if args.Pattern != "" {
-		dockerArgs = append(dockerArgs, "--glob", args.Pattern)
+	var inspectorArgs []string
+	if args.root != "" {
+		inspectorArgs = append(inspectorArgs, "--chroot", "/inspect-root")
+	}
+	if args.OneFileSystem {
+		inspectorArgs = append(inspectorArgs, "--one-file-system")
+	}
+	if args.MaxDepth > 0 {
+		inspectorArgs = append(inspectorArgs, "--max-depth", strconv.Itoa(args.MaxDepth))
+	}
+	if args.MinSize != nil {
+		inspectorArgs = append(inspectorArgs, "--min-size", fmt.Sprintf("%d", *args.MinSize))
+	}
+	if args.MaxSize != nil {
+		inspectorArgs = append(inspectorArgs, "--max-size", fmt.Sprintf("%d", *args.MaxSize))
+	}
+	if args.NewerThan != nil {
+		inspectorArgs = append(inspectorArgs, "--newer-than", args.NewerThan.UTC().Format(time.RFC3339Nano))
+	}
+	if args.OlderThan != nil {
+		inspectorArgs = append(inspectorArgs, "--older-than", args.OlderThan.UTC().Format(time.RFC3339Nano))
+	}
+	if args.UID != nil {
+		inspectorArgs = append(inspectorArgs, "--uid", fmt.Sprintf("%d", *args.UID))
+	}
+	if args.User != "" {
+		inspectorArgs = append(inspectorArgs, "--user", args.User)
+	}
+	if args.GID != nil {
+		inspectorArgs = append(inspectorArgs, "--gid", fmt.Sprintf("%d", *args.GID))
+	}
+	if args.Group != "" {
+		inspectorArgs = append(inspectorArgs, "--group", args.Group)
+	}
+	if args.Type != "" {
+		inspectorArgs = append(inspectorArgs, "--type", args.Type)
+	}
+	if args.Perm != "" {
+		// Use the = form as the value may start with a dash
+		inspectorArgs = append(inspectorArgs, "--perm="+args.Perm)
+	}
+	for _, pattern := range args.ignores {
+		inspectorArgs = append(inspectorArgs, "--ignore", pattern)
+	}
+	for _, expr := range args.Regexes {
+		inspectorArgs = append(inspectorArgs, "--regex", expr)
+	}
+	for _, pattern := range args.IGlobs {
+		inspectorArgs = append(inspectorArgs, "--iglob", pattern)
+	}
+	if args.IgnoreCase {
+		inspectorArgs = append(inspectorArgs, "--ignore-case")
+	}
+	for _, pattern := range args.Excludes {
+		inspectorArgs = append(inspectorArgs, "--exclude", pattern)
+	}
+	for _, pattern := range args.Patterns {
+		inspectorArgs = append(inspectorArgs, "--glob", pattern)
 	}
 	if args.MD5 {
-		dockerArgs = append(dockerArgs, "--md5")
+		inspectorArgs = append(inspectorArgs, "--md5")
+	}
+	if args.SHA256 {
+		inspectorArgs = append(inspectorArgs, "--sha256")
+	}
+	if (args.MD5 || args.SHA256) && args.MaxHashSize > 0 {
+		inspectorArgs = append(inspectorArgs, "--max-hash-size", fmt.Sprintf("%d", args.MaxHashSize))
 	}
 	if args.NoTimes {
-		dockerArgs = append(dockerArgs, "--no-times")
+		inspectorArgs = append(inspectorArgs, "--no-times")
 	}
-	if args.Path != "/" {
-		dockerArgs = append(dockerArgs, "--path", args.Path)
+	if args.Xattrs {
+		inspectorArgs = append(inspectorArgs, "--xattrs")
 	}
-	if args.OutputDir != "" {
-		dockerArgs = append(dockerArgs, "--output-dir", "/inspect-target")
-		dockerArgs = append(dockerArgs, "--strip-components", fmt.Sprintf("%d", args.StripComponents))
+	if args.Labels {
+		inspectorArgs = append(inspectorArgs, "--labels")
+	}
+	if args.AttrFlags {
+		inspectorArgs = append(inspectorArgs, "--attr-flags")
+	}
+	if args.Entropy {
+		inspectorArgs = append(inspectorArgs, "--entropy")
+	}
+	if args.DetectTypes {
+		inspectorArgs = append(inspectorArgs, "--detect-types")
+	}
+	if args.ContentType != "" {
+		inspectorArgs = append(inspectorArgs, "--content-type", args.ContentType)
+	}
+	if args.Grep != "" {
+		// Use the = form as the pattern may start with a dash
+		inspectorArgs = append(inspectorArgs, "--grep="+args.Grep)
+		if args.FixedStrings {
+			inspectorArgs = append(inspectorArgs, "--fixed-strings")
+		}
+	}
+	if args.Elf {
+		inspectorArgs = append(inspectorArgs, "--elf")
+	}
+	for _, path := range args.Paths {
+		inspectorArgs = append(inspectorArgs, "--path", path)
+	}
+	// Lists of paths are copied next to the inspector to not hit argument
+	// length limits
+	if args.PathsFrom != "" {
+		spec.Files = append(spec.Files, containerFile{Path: "inspect-input/paths", Mode: 0644, Data: []byte(strings.Join(args.pathList, "\n"))})
+		inspectorArgs = append(inspectorArgs, "--paths-from", "/inspect-input/paths")
+	}
+	if args.streamNDJSON {
+		inspectorArgs = append(inspectorArgs, "--format", "ndjson")
+	}
+	for _, pattern := range args.ExtractGlobs {
+		inspectorArgs = append(inspectorArgs, "--extract-glob", pattern)
+	}
+	for _, pattern := range args.ExtractExcludes {
+		inspectorArgs = append(inspectorArgs, "--extract-exclude", pattern)
+	}
+	if args.Tar != "" {
+		inspectorArgs = append(inspectorArgs, "--tar", "--strip-components", fmt.Sprintf("%d", args.StripComponents))
+		// Zip archives have no hardlinks, every link is stored as copy
+		if args.Zip != "" {
+			inspectorArgs = append(inspectorArgs, "--no-hardlinks")
+		}
+	}
+	if tarExtract {
+		// The files are streamed and unpacked here, followed by the listing
+		inspectorArgs = append(inspectorArgs, "--tar", "--tar-listing")
+		inspectorArgs = append(inspectorArgs, "--strip-components", fmt.Sprintf("%d", args.StripComponents))
+	} else if args.OutputDir != "" {
+		inspectorArgs = append(inspectorArgs, "--output-dir", "/inspect-target")
+		inspectorArgs = append(inspectorArgs, "--strip-components", fmt.Sprintf("%d", args.StripComponents))
 		if args.PreserveOwner {
-			dockerArgs = append(dockerArgs, "--preserve-owner")
+			inspectorArgs = append(inspectorArgs, "--preserve-owner")
 		}
 		if args.PreservePermissions {
-			dockerArgs = append(dockerArgs, "--preserve-perms")
+			inspectorArgs = append(inspectorArgs, "--preserve-perms")
 		}
-	}
-	// Create a pipe for capturing stdout while also displaying it
-	cmd := exec.Command("docker", dockerArgs...)
-	cmd.Stderr = os.Stderr
-	cmd.Stderr = os.Stderr
-	output, err := cmd.Output()
-	return output, err
-	/*
-		// This is a version that lets us debug what the docker command is printing
-		stdout, err := cmd.StdoutPipe()
-		if err != nil {
-			return nil, fmt.Errorf("failed to create stdout pipe: %v", err)
+		if args.PreserveXattrs {
+			inspectorArgs = append(inspectorArgs, "--preserve-xattrs")
 		}
-
-		// Start the command
-		if err := cmd.Start(); err != nil {
-			return nil, fmt.Errorf("failed to start inspection: %v", err)
+		if args.PreserveTimes {
+			inspectorArgs = append(inspectorArgs, "--preserve-times")
 		}
-
-		// Create a buffer to store the JSON output
-		var output []byte
-		buf := make([]byte, 1024)
-		for {
-			n, err := stdout.Read(buf)
-			if n > 0 {
-				output = append(output, buf[:n]...)
-				os.Stdout.Write(buf[:n])
-			}
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return nil, fmt.Errorf("failed to read output: %v", err)
-			}
+		if args.Overwrite != "" {
+			inspectorArgs = append(inspectorArgs, "--overwrite", args.Overwrite)
 		}
-
-		// Wait for the command to complete
-		if err := cmd.Wait(); err != nil {
-			return nil, fmt.Errorf("inspection failed: %v", err)
+		if args.Progress {
+			inspectorArgs = append(inspectorArgs, "--progress")
+		}
+		if args.FollowUnsafe {
+			inspectorArgs = append(inspectorArgs, "--follow-unsafe-symlinks")
 		}
-		return output, nil
-	*/
+		if args.OwnerMap != "" {
+			inspectorArgs = append(inspectorArgs, "--owner-map", args.OwnerMap)
+		}
+	}
+	if len(args.unchanged) > 0 {
+		spec.Files = append(spec.Files, containerFile{Path: "inspect-input/unchanged", Mode: 0644, Data: []byte(strings.Join(args.unchanged, "\n"))})
+		inspectorArgs = append(inspectorArgs, "--skip-extract", "/inspect-input/unchanged")
+	}
+	spec.Cmd = inspectorArgs
+	stderr := io.Writer(os.Stderr)
+	if args.stderr != nil {
+		stderr = args.stderr
+		defer args.stderr.Flush()
+	}
+	if tarExtract {
+		return runTarExtraction(spec, args, stderr, w)
+	}
+	return runContainer(spec, w, stderr)
 }
 
 func main() {
 	var args Args
 	// Set defaults
 	args.Summary = false
-	args.Path = "/"
 
-	arg.MustParse(&args)
+	// Subcommands are recognized before the image arguments
+	if len(os.Args) > 1 && os.Args[1] == "snapshot" {
+		runSnapshot(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "drift" {
+		runDrift(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "presets" {
+		runPresets(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "apply-owners" {
+		runApplyOwners(os.Args[2:])
+		return
+	}
+	parser := arg.MustParse(&args)
+	args.RuntimeArgs.apply(parser)
+	if args.Username != "" && !args.PasswordStdin {
+		parser.Fail("--username needs the password from --password-stdin")
+	}
+	if args.PasswordStdin {
+		if args.Username == "" {
+			parser.Fail("--password-stdin needs --username")
+		}
+		if args.PathsFrom == "-" {
+			parser.Fail("--password-stdin and --paths-from - can not both read stdin")
+		}
+		if args.CredHelper != "" {
+			parser.Fail("--username and --credential-helper can not be used together")
+		}
+		password, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading password: %v\n", err)
+			os.Exit(1)
+		}
+		registryUsername = args.Username
+		registryPassword = strings.TrimRight(string(password), "\r\n")
+	}
+	credentialHelper = args.CredHelper
+	if args.Registry != "" && args.Username == "" && args.CredHelper == "" {
+		parser.Fail("--registry needs --username or --credential-helper")
+	}
+	credentialRegistry = args.Registry
+	if credentialRegistry == "" {
+		credentialRegistry = imageRegistry(strings.TrimPrefix(args.Image1, registryPrefix))
+	}
+	// Images read straight from a registry, an archive or a layout have no
+	// container for the features of the inspector that need one
+	if slices.ContainsFunc(append([]string{args.Image1, args.Image2}, args.Images...), isDaemonless) {
+		if option := daemonlessUnsupported(args); option != "" {
+			parser.Fail(fmt.Sprintf("%s can not be used with images read from a registry, an archive or an OCI layout", option))
+		}
+	}
+
+	// Validate regexes here to not fail inside the container
+	for _, expr := range args.Regexes {
+		if _, err := regexp.Compile(expr); err != nil {
+			parser.Fail(fmt.Sprintf("invalid regex %q: %v", expr, err))
+		}
+	}
+
+	if args.DiffIgnoreFile != "" {
+		patterns, err := readIgnoreFile(args.DiffIgnoreFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading diff ignore file: %v\n", err)
+			os.Exit(1)
+		}
+		args.DiffIgnores = append(args.DiffIgnores, patterns...)
+	}
+	if len(args.IgnorePresets) > 0 {
+		if err := loadPresets(args.PresetFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		patterns, err := presetPatterns(args.IgnorePresets)
+		if err != nil {
+			parser.Fail(err.Error())
+		}
+		args.DiffIgnores = append(args.DiffIgnores, patterns...)
+	}
+	if err := validatePatterns(args.DiffIgnores); err != nil {
+		parser.Fail(err.Error())
+	}
+	if err := validatePatterns(append(args.ExtractGlobs, args.ExtractExcludes...)); err != nil {
+		parser.Fail(err.Error())
+	}
+	// The inspector would only fail after the image was pulled and started
+	if _, err := filter.ParseTypeFilter(args.Type); err != nil {
+		parser.Fail(err.Error())
+	}
+
+	ignoreFile := args.IgnoreFile
+	if ignoreFile == "" {
+		if _, err := os.Stat(defaultIgnoreFile); err == nil {
+			ignoreFile = defaultIgnoreFile
+		}
+	}
+	if ignoreFile != "" {
+		patterns, err := readIgnoreFile(ignoreFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading ignore file: %v\n", err)
+			os.Exit(1)
+		}
+		args.ignores = patterns
+	}
+
+	if args.PathsFrom != "" {
+		paths, err := filter.ReadPathList(args.PathsFrom)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading path list: %v\n", err)
+			os.Exit(1)
+		}
+		args.pathList = paths
+	}
+
+	if args.JSON {
+		args.Format = "json"
+	}
+	if args.Print0 {
+		args.Format = "print0"
+	}
+	if args.Porcelain {
+		args.Format = "porcelain"
+	}
+	if args.Grep != "" && !args.FixedStrings {
+		if _, err := regexp.Compile(args.Grep); err != nil {
+			parser.Fail(fmt.Sprintf("invalid --grep pattern: %v", err))
+		}
+	}
+	if _, ok := sortKeys[args.Sort]; args.Sort != "" && !ok {
+		parser.Fail(fmt.Sprintf("unknown sort key %q", args.Sort))
+	}
+	if args.Columns != "" {
+		columns, err := parseColumns(args.Columns)
+		if err != nil {
+			parser.Fail(err.Error())
+		}
+		// Columns need the information they show to be collected
+		for _, column := range columns {
+			switch column {
+			case "type":
+				args.DetectTypes = true
+			case "elf":
+				args.Elf = true
+			case "entropy":
+				args.Entropy = true
+			case "md5":
+				args.MD5 = true
+			case "sha256":
+				args.SHA256 = true
+			}
+		}
+		args.columns = columns
+	}
+	if args.FormatTemplate != "" {
+		tmpl, err := parseFormatTemplate(args.FormatTemplate)
+		if err != nil {
+			parser.Fail(fmt.Sprintf("invalid format template: %v", err))
+		}
+		args.template = tmpl
+		args.Format = "template"
+	}
+	switch args.Format {
+	case "":
+		args.Format = "table"
+	case "table", "json", "ndjson", "mtree", "print0":
+	case "ls":
+		// ls -l always has the date column
+		if args.NoTimes {
+			parser.Fail("--format ls can not be used with --no-times")
+		}
+	case "template":
+		if args.template == nil {
+			parser.Fail("--format template requires --format-template")
+		}
+	case "porcelain":
+		if args.SummaryOnly {
+			parser.Fail("--porcelain and --summary-only can not be used together")
+		}
+		if args.Image2 == "" && args.Against == "" && args.Baseline == "" && args.AgainstDir == "" && !args.AgainstBase {
+			parser.Fail("--porcelain is only available when comparing")
+		}
+	case "checksums":
+		// Without an explicit choice the manifest uses SHA256
+		if !args.MD5 && !args.SHA256 {
+			args.SHA256 = true
+		}
+	default:
+		parser.Fail(fmt.Sprintf("unknown format %q", args.Format))
+	}
 
 	if args.PreserveAll {
 		args.PreserveOwner = true
 		args.PreservePermissions = true
+		args.PreserveXattrs = true
+		args.PreserveTimes = true
+	}
+	if args.PreserveXattrs {
+		args.Xattrs = true
+	}
+	owners, err := parseOwnerMap(args.OwnerMap)
+	if err != nil {
+		parser.Fail(err.Error())
+	}
+	args.owners = owners
+	if args.OwnerMap != "" {
+		args.PreserveOwner = true
+	}
+	// The ownership is recorded instead of applied while extracting
+	if args.DeferOwners {
+		if args.OutputDir == "" || args.Image2 != "" {
+			parser.Fail("--defer-owners requires --output-dir and a single image")
+		}
+		args.PreserveOwner = false
+	}
+	// Both the path list and the unchanged files would need stdin
+	if args.Incremental {
+		if args.OutputDir == "" || args.Image2 != "" || args.Against != "" || args.AgainstDir != "" {
+			parser.Fail("--incremental requires --output-dir and a single image")
+		}
+		if args.PathsFrom != "" {
+			parser.Fail("--incremental can not be used with --paths-from")
+		}
+	}
+	// A mirror only copies what changed, which needs the times to compare
+	if args.Sync {
+		if args.OutputDir == "" || args.Image2 != "" || args.Against != "" || args.AgainstDir != "" {
+			parser.Fail("--sync requires --output-dir and a single image")
+		}
+		switch args.Overwrite {
+		case "", "update", "always":
+		default:
+			parser.Fail("--sync can not be used with --overwrite " + args.Overwrite)
+		}
+		if args.Overwrite == "" {
+			args.Overwrite = "update"
+		}
+		args.PreserveTimes = true
 	}
 	// check if we actually can handle the owner preservation
+	switch args.Overwrite {
+	case "", "always", "never", "update", "backup":
+	default:
+		parser.Fail(fmt.Sprintf("--overwrite must be always, never, update or backup, not %q", args.Overwrite))
+	}
+	// Without the times of the image every copy would look changed to
+	// update the next time
+	if args.Overwrite == "update" {
+		args.PreserveTimes = true
+	}
+	extractVia, err := extractVia(args.ExtractVia)
+	if err != nil {
+		parser.Fail(err.Error())
+	}
+	args.ExtractVia = extractVia
+	if args.Pull == "never" {
+		if err := requireLocalImages(append([]string{args.Image1, args.Image2}, args.Images...)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	// Rootless engines write the owners shifted into their subordinate ids
+	if args.OutputDir != "" && args.PreserveOwner && args.ExtractVia == "mount" {
+		if engine, err := containerEngine(); err == nil {
+			if rootless, _ := engine.isRootless(); rootless {
+				fmt.Fprintf(os.Stderr, "Warning: The container engine runs rootless, preserved owners become its subordinate ids on the host (use --defer-owners to apply the real ones)\n")
+			}
+		}
+	}
 	if runtime.GOOS == "darwin" && args.OutputDir != "" && args.PreserveOwner {
 		if !isOwnershipSupported(args.OutputDir) {
 			fmt.Fprintf(os.Stderr, "filesystem of %q does not support ownership changes\n", args.OutputDir)
@@ -221,164 +738,502 @@ func main() {
 		}
 	}
 
-	// Run inspection on first image
-	files1JSON, err := runInspector(args.Image1, args)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Inspection failed: %v\n", err)
-		os.Exit(1)
+	// A missing baseline is created like --update-baseline does, otherwise
+	// it is compared like a snapshot
+	if args.UpdateBaseline && args.Baseline == "" {
+		parser.Fail("--update-baseline requires --baseline")
+	}
+	if args.Baseline != "" {
+		if args.Image2 != "" || args.Against != "" {
+			parser.Fail("--baseline can not be used with a second image or --against")
+		}
+		if _, err := os.Stat(args.Baseline); os.IsNotExist(err) || args.UpdateBaseline {
+			count, err := writeSnapshot(args, args.Baseline)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "Baseline of %d files written to %s\n", count, args.Baseline)
+			return
+		}
+		args.Against = args.Baseline
 	}
 
-	if args.Image2 != "" {
-		// Parse the JSON outputs
-		var files1 []FileInfo
-		if err := json.Unmarshal(files1JSON, &files1); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to parse inspection results: %v", err)
-			os.Exit(1)
+	if args.AgainstDir != "" {
+		if args.Image2 != "" || args.Against != "" {
+			parser.Fail("--against-dir can not be used with a second image or --against")
+		}
+		if info, err := os.Stat(args.AgainstDir); err != nil || !info.IsDir() {
+			parser.Fail(fmt.Sprintf("--against-dir %s is not a directory", args.AgainstDir))
+		}
+		if engine, err := containerEngine(); err == nil && engine.isRemote() {
+			parser.Fail(fmt.Sprintf("--against-dir mounts the local directory, which the remote daemon %s can not do", engine.endpoint()))
 		}
+	}
+
+	for _, list := range args.Only {
+		for _, name := range strings.Split(list, ",") {
+			change := Change(strings.TrimSpace(name))
+			switch change {
+			case Added, Removed, Modified, Renamed:
+				args.only = append(args.only, change)
+			default:
+				parser.Fail(fmt.Sprintf("unknown change type %q for --only", name))
+			}
+		}
+	}
 
-		// Run inspection on second image
-		files2JSON, err := runInspector(args.Image2, args)
+	var failOn []string
+	for _, list := range args.FailOn {
+		for _, name := range strings.Split(list, ",") {
+			name = strings.TrimSpace(name)
+			switch name {
+			case "added", "removed", "modified", "renamed", "content", "none":
+				failOn = append(failOn, name)
+			default:
+				parser.Fail(fmt.Sprintf("unknown change type %q for --fail-on", name))
+			}
+		}
+	}
+	args.FailOn = failOn
+
+	// The matrix of more than two images has variants instead of changes
+	if len(args.Images) > 0 {
+		switch {
+		case len(args.only) > 0:
+			parser.Fail("--only can not be used with more than two images")
+		case len(args.FailOn) > 0:
+			parser.Fail("--fail-on can not be used with more than two images")
+		case args.MaxDiffs > 0 || args.MaxDiffsJSON:
+			parser.Fail("--max-diffs can not be used with more than two images")
+		case args.OutputDir != "":
+			// The images would be extracted into the directory at the same time
+			parser.Fail("--output-dir can not be used with more than two images")
+		case args.HTML != "":
+			parser.Fail("--html can not be used with more than two images")
+		case args.SQLite != "":
+			parser.Fail("--sqlite can not be used with more than two images")
+		case args.Treemap != "":
+			parser.Fail("--treemap can not be used with more than two images")
+		case args.ContentDiff:
+			parser.Fail("--content-diff can not be used with more than two images")
+		case args.SummaryOnly:
+			parser.Fail("--summary-only can not be used with more than two images")
+		case args.Growth > 0:
+			parser.Fail("--growth can not be used with more than two images")
+		case args.Format != "table" && args.Format != "json" && args.Format != "ndjson":
+			parser.Fail(fmt.Sprintf("--format %s can not be used with more than two images, use table, json or ndjson", args.Format))
+		}
+	}
+
+	switch args.CompareOwners {
+	case "", "ids", "names":
+	default:
+		parser.Fail(fmt.Sprintf("--compare-owners must be ids or names, not %q", args.CompareOwners))
+	}
+
+	// The base image becomes the first image, so the comparison shows what
+	// was added on top of it
+	if args.AgainstBase {
+		if args.Image2 != "" || args.Against != "" || args.AgainstDir != "" {
+			parser.Fail("--against-base can not be used with a second image, --against or --against-dir")
+		}
+		base, err := baseImage(args.Image1)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Second inspection failed: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
-		var files2 []FileInfo
-		if err := json.Unmarshal(files2JSON, &files2); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to parse inspection results: %v", err)
+		fmt.Fprintf(os.Stderr, "Comparing against base image %s\n", base)
+		args.Image1, args.Image2 = base, args.Image1
+	}
+
+	if args.ExtractBoth != "" {
+		if args.Image2 == "" || len(args.Images) > 0 {
+			parser.Fail("--extract-both requires exactly two images")
+		}
+		if args.OutputDir != "" {
+			parser.Fail("--extract-both and --output-dir can not be used together")
+		}
+		if err := os.MkdirAll(args.ExtractBoth, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to create %s: %v\n", args.ExtractBoth, err)
 			os.Exit(1)
 		}
+	}
 
-		// Compare the results
-		mode := CompareAll
-		if args.NoTimes {
-			mode = CompareNoTimes
+	// A snapshot replaces the first image, the image is inspected with the
+	// checksums the snapshot has
+	var snapshot []FileInfo
+	if args.Against != "" {
+		if args.Image2 != "" {
+			parser.Fail("--against can not be used with a second image")
 		}
+		var err error
+		if snapshot, err = loadSnapshot(args.Against); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		md5, sha256 := snapshotHashes(snapshot)
+		args.MD5 = args.MD5 || md5
+		args.SHA256 = args.SHA256 || sha256
+	}
 
-		result, err := Compare(files1, files2, mode)
+	if args.Cat != "" {
+		if args.Image2 != "" || args.Against != "" || args.AgainstDir != "" {
+			parser.Fail("--cat can not be used when comparing images")
+		}
+		if err := catFile(os.Stdout, args.Image1, args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if args.Tar != "" {
+		if args.Image2 != "" || args.Against != "" || args.AgainstDir != "" {
+			parser.Fail("--tar can not be used when comparing images")
+		}
+		if args.OutputDir != "" {
+			parser.Fail("--tar and --output-dir can not be used together")
+		}
+		if args.Zip != "" {
+			parser.Fail("--tar and --zip can not be used together")
+		}
+		if err := writeTarArchive(args); err != nil {
+			fmt.Fprintf(os.Stderr, "Inspection failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if args.Zip != "" {
+		if args.Image2 != "" || args.Against != "" || args.AgainstDir != "" {
+			parser.Fail("--zip can not be used when comparing images")
+		}
+		if args.OutputDir != "" {
+			parser.Fail("--zip and --output-dir can not be used together")
+		}
+		if err := writeZipArchive(args); err != nil {
+			fmt.Fprintf(os.Stderr, "Inspection failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	out, err := openOutput(args.Output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// A dry run inspects the image like the extraction would, but does not
+	// hand the output directory to the inspector
+	if args.DryRun {
+		if args.OutputDir == "" {
+			parser.Fail("--dry-run requires --output-dir")
+		}
+		if args.Image2 != "" || args.Against != "" || args.AgainstDir != "" {
+			parser.Fail("--dry-run can not be used when comparing images")
+		}
+		dryArgs := args
+		dryArgs.OutputDir = ""
+		files, err := inspectFiles(args.Image1, dryArgs)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error comparing images: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Inspection failed: %v\n", err)
 			os.Exit(1)
 		}
+		printDryRun(out, extractedFiles(files, args), args)
+		if args.Sync {
+			if err := printStaleFiles(out, extractedFiles(files, args), args); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		closeOutput(out)
+		return
+	}
 
-		// Output the comparison results
-		if args.JSON {
-			encoder := json.NewEncoder(os.Stdout)
-			encoder.SetIndent("", "  ")
-			encoder.Encode(result)
-		} else {
-			printDiffText(result)
+	// NDJSON listings are streamed while the inspector walks the image
+	// Sorted listings need all results before printing
+	sorted := args.Sort != "" || args.Reverse || args.Top > 0
+	if args.Image2 == "" && args.Against == "" && args.AgainstDir == "" && args.Format == "ndjson" && !sorted && args.SQLite == "" && args.HTML == "" && args.Treemap == "" && !args.BrokenSymlinks && !needsOwnershipFix(args) && !verifiesExtraction(args) && !args.Sync && !args.Incremental && (args.OutputDir == "" || args.ExtractVia != "tar") {
+		args.streamNDJSON = true
+		if err := streamInspector(args.Image1, args, out); err != nil {
+			fmt.Fprintf(os.Stderr, "Inspection failed: %v\n", err)
+			os.Exit(1)
 		}
+		closeOutput(out)
+		return
+	}
 
-		// Exit with status 1 if differences were found
-		if result.Summary.TotalDifferences > 0 {
+	// More than two images are compared all at once
+	if len(args.Images) > 0 {
+		images := append([]string{args.Image1, args.Image2}, args.Images...)
+		os.Exit(runMatrix(images, args, out))
+	}
+
+	// Both sides of a comparison are inspected at the same time, unless
+	// both extract files into the same directory
+	inspectSides := runConcurrently
+	if args.OutputDir != "" {
+		inspectSides = runSequentially
+	}
+	if args.Image2 != "" {
+		// With --extract-both every image gets its own directory
+		args1, args2 := withPrefix(args, args.Image1), withPrefix(args, args.Image2)
+		if args.ExtractBoth != "" {
+			args1.OutputDir = filepath.Join(args.ExtractBoth, "old")
+			args2.OutputDir = filepath.Join(args.ExtractBoth, "new")
+		}
+		var files1, files2 []FileInfo
+		err := inspectSides(
+			func() (err error) {
+				files1, err = inspectFiles(args.Image1, args1)
+				return err
+			},
+			func() (err error) {
+				files2, err = inspectFiles(args.Image2, args2)
+				return err
+			})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Inspection failed: %v\n", err)
 			os.Exit(1)
 		}
+		if needsOwnershipFix(args1) {
+			fmt.Fprintf(os.Stderr, "\nFixing file ownership on macOS...")
+			for _, side := range []struct {
+				files []FileInfo
+				dir   string
+			}{{files1, args1.OutputDir}, {files2, args2.OutputDir}} {
+				if err := fixOwnershipWithSudo(extractedFiles(side.files, args), side.dir, args); err != nil {
+					fmt.Fprintf(os.Stderr, "\nError fixing ownership: %v\n", err)
+					os.Exit(1)
+				}
+			}
+			fmt.Fprintf(os.Stderr, " Done!\n")
+		}
+		os.Exit(runComparison(
+			compareSide{name: args.Image1, image: args.Image1, files: files1},
+			compareSide{name: args.Image2, image: args.Image2, files: files2},
+			args, out))
+	}
+	if args.AgainstDir != "" {
+		// The directory is inspected in a container of the image
+		dirArgs := args
+		dirArgs.root = args.AgainstDir
+		dirArgs.OutputDir = ""
+		var files, dirFiles []FileInfo
+		err := runConcurrently(
+			func() (err error) {
+				files, err = inspectFiles(args.Image1, withPrefix(args, args.Image1))
+				return err
+			},
+			func() (err error) {
+				dirFiles, err = inspectFiles(args.Image1, withPrefix(dirArgs, args.AgainstDir))
+				return err
+			})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Inspection failed: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(runComparison(
+			compareSide{name: args.AgainstDir, image: args.Image1, root: args.AgainstDir, files: dirFiles},
+			compareSide{name: args.Image1, image: args.Image1, files: files},
+			args, out))
+	}
+
+	// An image that is not pulled yet has no cache to use
+	var digest string
+	if args.Incremental {
+		if digest, err = imageDigest(args.Image1); err == nil {
+			args.unchanged = unchangedFiles(digest, args)
+		}
+	}
+
+	// Files kept by --overwrite are not verified after the extraction
+	var existing map[string]os.FileInfo
+	if verifiesExtraction(args) {
+		existing = existingOutput(args)
+	}
+
+	// Run inspection on first image
+	files1JSON, err := runInspector(args.Image1, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Inspection failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	if snapshot != nil {
+		var files []FileInfo
+		if err := json.Unmarshal(files1JSON, &files); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to parse inspection results: %v", err)
+			os.Exit(1)
+		}
+		os.Exit(runComparison(
+			compareSide{name: args.Against, files: snapshot},
+			compareSide{name: args.Image1, image: args.Image1, files: files},
+			args, out))
 	} else {
 		var files1 []FileInfo
-		if args.JSON {
-			// we just print what we got
-			fmt.Print(string(files1JSON))
-		} else {
-			if err := json.Unmarshal(files1JSON, &files1); err != nil {
-				fmt.Fprintf(os.Stderr, "failed to parse inspection results: %v", err)
+		if err := json.Unmarshal(files1JSON, &files1); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to parse inspection results: %v", err)
+			os.Exit(1)
+		}
+
+		if args.SQLite != "" {
+			if err := writeSQLite(args.SQLite, []string{args.Image1}, [][]FileInfo{files1}, nil); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing SQLite database: %v\n", err)
 				os.Exit(1)
 			}
-			// Output the inspection results
-			var totalSize int64
-			dirCount := 0
-			fileCount := 0
-			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
-			header := "Mode\tSize\tModified\tUser\tGroup\tPath\tSymlink"
-			if args.MD5 {
-				header += "\tMD5"
+		}
+		if args.HTML != "" {
+			if err := writeHTML(args.HTML, "Contents of "+args.Image1, files1, nil); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
 			}
-			fmt.Fprintln(w, header)
-
-			for _, file := range files1 {
-				symlink := ""
-				if file.SymlinkTo != "" {
-					symlink = "-> " + file.SymlinkTo
-				}
-				// Build the line string, conditionally including the time field.
-				// When NoTimes is true, timeStr will be empty and won't add a tab,
-				// otherwise it adds both the formatted time and a tab.
-				timeStr := ""
-				if !args.NoTimes {
-					timeStr = file.ModTime.Format("2006-01-02 15:04:05") + "\t"
-				}
-				if file.IsDir {
-					dirCount++
-				} else {
-					fileCount++
-				}
-				totalSize += file.Size
-
-				line := fmt.Sprintf("%s\t%d\t%s%s\t%s\t%s\t%s",
-					file.Mode,
-					file.Size,
-					timeStr,
-					file.User,
-					file.Group,
-					file.Path,
-					symlink,
-				)
-				if args.MD5 {
-					line += fmt.Sprintf("\t%s", file.MD5)
-				}
-				fmt.Fprintln(w, line)
+		}
+		if args.Treemap != "" {
+			if err := writeTreemap(args.Treemap, files1); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
 			}
-			w.Flush()
-
-			// Print summary if requested
-			if args.Summary {
-				fmt.Printf("\nSummary:\n")
-				fmt.Printf("Total size: %d bytes\n", totalSize)
-				fmt.Printf("Directories: %d\n", dirCount)
-				fmt.Printf("Files: %d\n", fileCount)
+		}
+		if sorted {
+			sortFiles(files1, args.Sort, args.Reverse)
+		}
+
+		if args.BrokenSymlinks {
+			printBrokenSymlinks(out, files1, args)
+		} else if args.Top > 0 {
+			// Other formats get the selected files, biggest first
+			top, total := largestFiles(files1, args.Top)
+			if args.Format == "table" {
+				printTop(out, top, total, args)
+			} else if err := printListing(out, top, args); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+				os.Exit(1)
 			}
+		} else if err := printListing(out, files1, args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+			os.Exit(1)
 		}
 
+		closeOutput(out)
+
 		// If we're on macOS and files were copied with ownership preservation requested,
 		// fix ownership using sudo
-		if runtime.GOOS == "darwin" && args.OutputDir != "" &&
-			args.PreserveOwner {
-			// Test if ownership changes are supported
-			if args.JSON {
-				if err := json.Unmarshal(files1JSON, &files1); err != nil {
-					fmt.Fprintf(os.Stderr, "failed to parse inspection results: %v", err)
-					os.Exit(1)
-				}
-			}
+		if needsOwnershipFix(args) {
 			fmt.Fprintf(os.Stderr, "\nFixing file ownership on macOS...")
-			if err := fixOwnershipWithSudo(files1, args.OutputDir, args.StripComponents); err != nil {
+			if err := fixOwnershipWithSudo(extractedFiles(files1, args), args.OutputDir, args); err != nil {
 				fmt.Fprintf(os.Stderr, "\nError fixing ownership: %v\n", err)
 				os.Exit(1)
 			}
 			fmt.Fprintf(os.Stderr, " Done!\n")
 		}
+
+		if args.Incremental {
+			if err := writeExtractCache(extractedFiles(files1, args), digest, args); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: Extraction cache not written: %v\n", err)
+			}
+		}
+
+		if args.Sync {
+			if err := syncOutputDir(extractedFiles(files1, args), args); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		if args.DeferOwners {
+			manifest, err := writeOwnersManifest(extractedFiles(files1, args), args)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "Ownership recorded in %s, apply it with: sudo docker-inspector apply-owners %s\n", manifest, manifest)
+		}
+
+		// Copies over flaky bind mounts may be incomplete
+		mismatches := 0
+		if verifiesExtraction(args) {
+			mismatches = verifyExtraction(extractedFiles(files1, args), existing, args)
+		}
+
+		// Exit with status 1 if bad copies were found
+		if mismatches > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+// closeOutput finishes the --output file and exits on failure, as the
+// results would be incomplete
+func closeOutput(out *outputFile) {
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// writeTarArchive streams the matching files of the image as tar archive
+// to the --tar file, which is written directly as the inspector walks
+func writeTarArchive(args Args) error {
+	if args.Tar == "-" {
+		return streamInspector(args.Image1, args, os.Stdout)
+	}
+	f, err := os.Create(args.Tar)
+	if err != nil {
+		return fmt.Errorf("failed to create tar file: %v", err)
+	}
+	if err := streamInspector(args.Image1, args, f); err != nil {
+		f.Close()
+		os.Remove(args.Tar)
+		return err
+	}
+	return f.Close()
+}
+
+// needsOwnershipFix reports whether ownership has to be fixed with sudo after
+// extracting, which is the case on macOS when ownership should be preserved
+func needsOwnershipFix(args Args) bool {
+	return runtime.GOOS == "darwin" && args.OutputDir != "" && args.PreserveOwner
+}
+
+// printBrokenSymlinks lists all symlinks with a missing target
+func printBrokenSymlinks(w io.Writer, files []FileInfo, args Args) {
+	broken := []FileInfo{}
+	for _, file := range files {
+		if file.SymlinkBroken {
+			broken = append(broken, file)
+		}
+	}
+
+	if args.Format != "table" {
+		printListing(w, broken, args)
+	} else {
+		for _, file := range broken {
+			fmt.Fprintf(w, "%s -> %s\n", file.Path, file.SymlinkTo)
+		}
+		fmt.Fprintf(w, "\nBroken symlinks: %d\n", len(broken))
 	}
 }
 
 // In main.go, modify the ownership fixing:
-func fixOwnershipWithSudo(files []FileInfo, outputDir string, stripComponents int) error {
+func fixOwnershipWithSudo(files []FileInfo, outputDir string, args Args) error {
 	// Build a script of chown commands
 	var commands strings.Builder
 	commands.WriteString("#!/bin/bash\n")
 
 	for _, file := range files {
 		// Get the adjusted path based on strip components
-		destPath := getDestPath(file.Path, stripComponents)
+		destPath := getDestPath(file.Path, args.StripComponents)
 		if destPath == "" {
 			continue
 		}
 
-		// Extract UID/GID from the user/group strings
-		uid, err := extractID(file.User)
+		uid, err := fileUID(file)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Warning: Could not extract UID from %q: %v\n", file.User, err)
 			continue
 		}
-		gid, err := extractID(file.Group)
+		gid, err := fileGID(file)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Warning: Could not extract GID from %q: %v\n", file.Group, err)
 			continue
@@ -386,7 +1241,7 @@ func fixOwnershipWithSudo(files []FileInfo, outputDir string, stripComponents in
 
 		fullDestPath := filepath.Join(outputDir, destPath)
 		// Use -h to handle symlinks correctly
-		fmt.Fprintf(&commands, "chown -h %d:%d %q\n", uid, gid, fullDestPath)
+		fmt.Fprintf(&commands, "chown -h %d:%d %q\n", args.owners.uid(uid), args.owners.gid(gid), fullDestPath)
 	}
 
 	// Create a temporary script file
@@ -422,7 +1277,33 @@ func getDestPath(sourcePath string, stripComponents int) string {
 		return ""
 	}
 
-	return "/" + filepath.Join(parts[stripComponents:]...)
+	// Cleaning a rooted path resolves ".." without ever leaving the root,
+	// so the result can not point outside of the output directory
+	destPath := path.Join(append([]string{"/"}, parts[stripComponents:]...)...)
+	if destPath == "/" {
+		return ""
+	}
+	return destPath
+}
+
+// defaultIgnoreFile is used when it exists in the current directory
+const defaultIgnoreFile = ".dockerinspectorignore"
+
+// readIgnoreFile returns the patterns of a gitignore style file
+func readIgnoreFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, nil
 }
 
 func extractID(s string) (int, error) {
@@ -0,0 +1,52 @@
+package main
+
+import "strings"
+
+// permBits converts the permission part of a Go file mode string like
+// "-rwxr-xr-x" or "urwxr-xr-x" (setuid) into the numeric Unix permissions
+func permBits(mode string) uint32 {
+	if len(mode) < 9 {
+		return 0
+	}
+	flags, perms := mode[:len(mode)-9], mode[len(mode)-9:]
+	var bits uint32
+	for i, c := range perms {
+		if c != '-' {
+			bits |= 1 << (8 - i)
+		}
+	}
+	if strings.ContainsRune(flags, 'u') {
+		bits |= 04000
+	}
+	if strings.ContainsRune(flags, 'g') {
+		bits |= 02000
+	}
+	if strings.ContainsRune(flags, 't') {
+		bits |= 01000
+	}
+	return bits
+}
+
+// modeType names the file type of a Go file mode string like "drwxr-xr-x"
+// or "Dcrw-rw-rw-" like the inspector does, it is empty without a mode
+func modeType(mode string) string {
+	if len(mode) < 9 {
+		return ""
+	}
+	flags := mode[:len(mode)-9]
+	switch {
+	case strings.ContainsRune(flags, 'd'):
+		return "dir"
+	case strings.ContainsRune(flags, 'L'):
+		return "symlink"
+	case strings.ContainsRune(flags, 'c'):
+		return "chardev"
+	case strings.ContainsRune(flags, 'D'):
+		return "blockdev"
+	case strings.ContainsRune(flags, 'p'):
+		return "fifo"
+	case strings.ContainsRune(flags, 'S'):
+		return "socket"
+	}
+	return "file"
+}
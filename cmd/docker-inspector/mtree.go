@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// mtreeTypes maps our file types to the mtree type keyword
+var mtreeTypes = map[string]string{
+	"file":     "file",
+	"dir":      "dir",
+	"symlink":  "link",
+	"chardev":  "char",
+	"blockdev": "block",
+	"fifo":     "fifo",
+	"socket":   "socket",
+}
+
+// printMtree writes the files as BSD mtree specification using full paths
+// relative to the image root
+func printMtree(w io.Writer, files []FileInfo) error {
+	if _, err := fmt.Fprintln(w, "#mtree"); err != nil {
+		return err
+	}
+	for _, file := range files {
+		fields := []string{mtreeEscape("." + strings.TrimSuffix(file.Path, "/"))}
+		if t, ok := mtreeTypes[file.Type]; ok {
+			fields = append(fields, "type="+t)
+		}
+		if uid, err := fileUID(file); err == nil {
+			fields = append(fields, fmt.Sprintf("uid=%d", uid))
+		}
+		if name := fileUserName(file); name != "" {
+			fields = append(fields, "uname="+mtreeEscape(name))
+		}
+		if gid, err := fileGID(file); err == nil {
+			fields = append(fields, fmt.Sprintf("gid=%d", gid))
+		}
+		if name := fileGroupName(file); name != "" {
+			fields = append(fields, "gname="+mtreeEscape(name))
+		}
+		fields = append(fields, fmt.Sprintf("mode=%04o", permBits(file.Mode)))
+		if file.Type == "file" {
+			fields = append(fields, fmt.Sprintf("size=%d", file.Size))
+		}
+		if file.Links > 1 && !file.IsDir {
+			fields = append(fields, fmt.Sprintf("nlink=%d", file.Links))
+		}
+		if file.ModTime != nil {
+			fields = append(fields, fmt.Sprintf("time=%d.%09d", file.ModTime.Unix(), file.ModTime.Nanosecond()))
+		}
+		if file.SymlinkTo != "" {
+			fields = append(fields, "link="+mtreeEscape(file.SymlinkTo))
+		}
+		if file.Type == "chardev" || file.Type == "blockdev" {
+			fields = append(fields, fmt.Sprintf("device=native,%d,%d", file.Major, file.Minor))
+		}
+		if isChecksum(file.MD5) {
+			fields = append(fields, "md5digest="+file.MD5)
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(fields, " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mtreeEscape encodes whitespace, special and non-ASCII characters as octal
+// escapes like vis(3) does for mtree
+func mtreeEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || c == '\\' || c == '#' || c == '=' {
+			fmt.Fprintf(&b, "\\%03o", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// ownerName returns the name part of a "name(id)" owner string
+func ownerName(s string) string {
+	if i := strings.LastIndex(s, "("); i > 0 {
+		return s[:i]
+	}
+	return ""
+}
+
+// isChecksum reports whether the MD5 field holds a digest and not an error
+func isChecksum(md5 string) bool {
+	return md5 != "" && !strings.HasPrefix(md5, "error:")
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// cliRuntime runs containers with a docker compatible command, for runtimes
+// like nerdctl (containerd) that have no Docker API socket. It always runs
+// on this host.
+type cliRuntime struct {
+	command string
+}
+
+func (c *cliRuntime) run(spec containerSpec, stdout, stderr io.Writer) error {
+	// The files are mounted read-only instead of being copied
+	tempDir, err := os.MkdirTemp("", "docker-inspector-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	binds := spec.Binds
+	for _, file := range spec.Files {
+		dest := filepath.Join(tempDir, filepath.FromSlash(file.Path))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dest, file.Data, os.FileMode(file.Mode)); err != nil {
+			return fmt.Errorf("failed to write %s: %v", file.Path, err)
+		}
+		top, _, _ := strings.Cut(file.Path, "/")
+		bind := fmt.Sprintf("%s:/%s:ro", filepath.Join(tempDir, top), top)
+		if !slices.Contains(binds, bind) {
+			binds = append(binds, bind)
+		}
+	}
+
+	createArgs := []string{"create", "--entrypoint", "/inspect", "--security-opt", "label=disable"}
+	if spec.Platform != "" {
+		createArgs = append(createArgs, "--platform", spec.Platform)
+	}
+	if spec.Pull != "" {
+		createArgs = append(createArgs, "--pull", spec.Pull)
+	}
+	if spec.User != "" {
+		createArgs = append(createArgs, "--user", spec.User)
+	}
+	for _, bind := range binds {
+		createArgs = append(createArgs, "-v", bind)
+	}
+	createArgs = append(createArgs, spec.Image)
+	createArgs = append(createArgs, spec.Cmd...)
+	create := exec.Command(c.command, createArgs...)
+	if registryUsername != "" || credentialHelper != "" {
+		// nerdctl reads the credentials from a docker config
+		configDir := filepath.Join(tempDir, "docker-config")
+		if err := writeAuthConfig(configDir, imageRegistry(spec.Image)); err != nil {
+			return err
+		}
+		create.Env = append(os.Environ(), "DOCKER_CONFIG="+configDir)
+	}
+	// Pulling a missing image reports its progress
+	create.Stderr = stderr
+	output, err := create.Output()
+	if err != nil {
+		return fmt.Errorf("failed to create container: %v", err)
+	}
+	id := strings.TrimSpace(string(output))
+	if !spec.Keep {
+		defer c.removeContainer(id)
+		defer removeOnInterrupt(func() { c.removeContainer(id) })()
+	}
+
+	start := exec.Command(c.command, "start", "--attach", id)
+	start.Stdout = stdout
+	start.Stderr = stderr
+	return start.Run()
+}
+
+// writeAuthConfig writes a docker config into dir with the credentials of
+// the registry
+func writeAuthConfig(dir, registry string) error {
+	auth, ok, err := registryCredentials(registry)
+	if err != nil || !ok {
+		return err
+	}
+	entry := map[string]string{"identitytoken": auth.IdentityToken}
+	if auth.Username != "" {
+		entry["auth"] = base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+	}
+	data, err := json.Marshal(map[string]any{"auths": map[string]any{auth.ServerAddress: entry}})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "config.json"), data, 0600)
+}
+
+// removeContainer deletes the container like docker run --rm does
+func (c *cliRuntime) removeContainer(id string) {
+	if _, err := c.output("rm", "--force", "--volumes", id); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to remove container %s: %v\n", id, err)
+	}
+}
+
+func (c *cliRuntime) endpoint() string {
+	return c.command
+}
+
+func (c *cliRuntime) isRemote() bool {
+	return false
+}
+
+// isRootless reports whether rootless containerd is used, which is the
+// case when not running as root
+func (c *cliRuntime) isRootless() (bool, error) {
+	return os.Getuid() != 0, nil
+}
+
+// arch returns the architecture of this host, where containerd runs
+func (c *cliRuntime) arch() (string, error) {
+	return runtime.GOARCH, nil
+}
+
+func (c *cliRuntime) imageDetails(image string) (imageDetails, error) {
+	output, err := c.output("image", "inspect", image)
+	if err != nil {
+		return imageDetails{}, err
+	}
+	var details []imageDetails
+	if err := json.Unmarshal([]byte(output), &details); err != nil {
+		return imageDetails{}, fmt.Errorf("failed to parse image details: %v", err)
+	}
+	if len(details) != 1 {
+		return imageDetails{}, fmt.Errorf("no such image: %s", image)
+	}
+	return details[0], nil
+}
+
+func (c *cliRuntime) imageIDs() ([]string, error) {
+	output, err := c.output("image", "ls", "--quiet", "--no-trunc")
+	if err != nil {
+		return nil, err
+	}
+	// Images with several tags are listed once per tag
+	ids := strings.Fields(output)
+	sort.Strings(ids)
+	return slices.Compact(ids), nil
+}
+
+func (c *cliRuntime) removeImage(image string) error {
+	_, err := c.output("image", "rm", image)
+	return err
+}
+
+func (c *cliRuntime) containerImage(container string) (string, error) {
+	return c.output("container", "inspect", "--format", "{{.Image}}", container)
+}
+
+// commitContainer saves the container as image, which needs a name with
+// nerdctl
+func (c *cliRuntime) commitContainer(container string) (string, error) {
+	image := fmt.Sprintf("docker-inspector-commit:%d", time.Now().UnixNano())
+	if _, err := c.output("container", "commit", "--pause=false", container, image); err != nil {
+		return "", err
+	}
+	return image, nil
+}
+
+// output runs the command and returns its trimmed output, failures include
+// what the command printed on stderr
+func (c *cliRuntime) output(args ...string) (string, error) {
+	output, err := exec.Command(c.command, args...).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return "", fmt.Errorf("%s %s: %s", c.command, args[0], strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %v", c.command, args[0], err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
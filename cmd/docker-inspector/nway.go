@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// MatrixRow describes a path that is not the same in all images. Variants
+// holds a letter per image, images with the same letter have the same
+// version of the file and an empty variant means the file is missing.
+type MatrixRow struct {
+	Path     string      `json:"path"`
+	Variants []string    `json:"variants"`
+	Files    []*FileInfo `json:"files"`
+	// Details lists how the variants differ from the first one
+	Details []string `json:"details,omitempty"`
+}
+
+// Matrix is the result of comparing more than two images
+type Matrix struct {
+	Images  []string      `json:"images"`
+	Rows    []MatrixRow   `json:"rows"`
+	Summary MatrixSummary `json:"summary"`
+}
+
+type MatrixSummary struct {
+	TotalPaths     int `json:"totalPaths"`
+	DifferentPaths int `json:"differentPaths"`
+}
+
+// runMatrix inspects all images, writes which files differ in which image
+// and returns the exit status, which is 1 if differences were found
+func runMatrix(images []string, args Args, out *outputFile) int {
+	listings := make([][]FileInfo, len(images))
+	var tasks []func() error
+	for i, image := range images {
+		i, image := i, image
+		tasks = append(tasks, func() error {
+			files, err := inspectFiles(image, withPrefix(args, image))
+			if err != nil {
+				return fmt.Errorf("inspection of %s failed: %v", image, err)
+			}
+			listings[i] = withoutDiffIgnored(files, args.DiffIgnores)
+			return nil
+		})
+	}
+	if err := runConcurrently(tasks...); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	// Like for two images, only files with the same size as in another
+	// image are hashed
+	if !args.MD5 && !args.SHA256 && !args.NoAutoHash {
+		var tasks []func() error
+		for i, image := range images {
+			var paths []string
+			for j := range images {
+				if i != j {
+					candidates, _ := hashCandidates(listings[i], listings[j], false)
+					paths = append(paths, candidates...)
+				}
+			}
+			slices.Sort(paths)
+			paths = slices.Compact(paths)
+			i, image := i, image
+			tasks = append(tasks, func() error {
+				return addHashes(image, withPrefix(args, image), paths, listings[i])
+			})
+		}
+		if err := runConcurrently(tasks...); err != nil {
+			fmt.Fprintf(os.Stderr, "Hashing failed: %v\n", err)
+			return 1
+		}
+	}
+
+	matrix := compareMatrix(images, listings, compareOptions(args))
+	switch args.Format {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(matrix)
+	case "ndjson":
+		encoder := json.NewEncoder(out)
+		for _, row := range matrix.Rows {
+			encoder.Encode(row)
+		}
+	default:
+		printMatrix(out, matrix)
+	}
+	closeOutput(out)
+
+	if matrix.Summary.DifferentPaths > 0 {
+		return 1
+	}
+	return 0
+}
+
+// compareMatrix groups the versions of every path into variants
+func compareMatrix(images []string, listings [][]FileInfo, opts Options) *Matrix {
+	byPath := make(map[string][]*FileInfo)
+	for i, files := range listings {
+		for j := range files {
+			file := &files[j]
+			if byPath[file.Path] == nil {
+				byPath[file.Path] = make([]*FileInfo, len(images))
+			}
+			byPath[file.Path][i] = file
+		}
+	}
+	paths := make([]string, 0, len(byPath))
+	for path := range byPath {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	matrix := &Matrix{Images: images, Rows: []MatrixRow{}}
+	matrix.Summary.TotalPaths = len(paths)
+	for _, path := range paths {
+		files := byPath[path]
+		row := MatrixRow{Path: path, Variants: make([]string, len(images)), Files: files}
+		var variants []*FileInfo
+		for i, file := range files {
+			if file == nil {
+				continue
+			}
+			index := slices.IndexFunc(variants, func(v *FileInfo) bool {
+				return len(compareFiles(*v, *file, opts)) == 0
+			})
+			if index < 0 {
+				index = len(variants)
+				if index > 0 {
+					for _, detail := range compareFiles(*variants[0], *file, opts) {
+						row.Details = append(row.Details, fmt.Sprintf("%s: %s", images[i], detail))
+					}
+				}
+				variants = append(variants, file)
+			}
+			row.Variants[i] = variantName(index)
+		}
+		if len(variants) > 1 || slices.Contains(files, nil) {
+			matrix.Rows = append(matrix.Rows, row)
+		}
+	}
+	matrix.Summary.DifferentPaths = len(matrix.Rows)
+	return matrix
+}
+
+// variantName returns a, b, ..., z, aa, ab, ... for the index of a variant
+func variantName(index int) string {
+	name := string(rune('a' + index%26))
+	for index >= 26 {
+		index = index/26 - 1
+		name = string(rune('a'+index%26)) + name
+	}
+	return name
+}
+
+// printMatrix writes a column per image with the variant of every file,
+// "-" marks files that are missing in an image
+func printMatrix(w io.Writer, matrix *Matrix) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "PATH\t%s\n", strings.Join(matrix.Images, "\t"))
+	for _, row := range matrix.Rows {
+		cells := make([]string, len(row.Variants))
+		for i, variant := range row.Variants {
+			cells[i] = variant
+			if variant == "" {
+				cells[i] = "-"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", row.Path, strings.Join(cells, "\t"))
+	}
+	tw.Flush()
+
+	for _, row := range matrix.Rows {
+		if len(row.Details) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s:\n", row.Path)
+		for _, detail := range row.Details {
+			fmt.Fprintf(w, "  %s\n", detail)
+		}
+	}
+	fmt.Fprintf(w, "\n%d of %d paths differ between the %d images\n",
+		matrix.Summary.DifferentPaths, matrix.Summary.TotalPaths, len(matrix.Images))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// outputFile is the --output destination, gzip compressed for .gz names
+type outputFile struct {
+	file *os.File
+	gz   *gzip.Writer
+}
+
+// openOutput creates the result file. An empty name writes to stdout.
+func openOutput(name string) (*outputFile, error) {
+	if name == "" || name == "-" {
+		return &outputFile{file: os.Stdout}, nil
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %v", err)
+	}
+	out := &outputFile{file: f}
+	if strings.HasSuffix(name, ".gz") {
+		out.gz = gzip.NewWriter(f)
+	}
+	return out, nil
+}
+
+func (o *outputFile) Write(p []byte) (int, error) {
+	if o.gz != nil {
+		return o.gz.Write(p)
+	}
+	return o.file.Write(p)
+}
+
+// Close finishes the compression and closes the file, stdout stays open
+func (o *outputFile) Close() error {
+	if o.gz != nil {
+		if err := o.gz.Close(); err != nil {
+			return fmt.Errorf("failed to compress output: %v", err)
+		}
+	}
+	if o.file == os.Stdout {
+		return nil
+	}
+	return o.file.Close()
+}
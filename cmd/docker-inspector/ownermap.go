@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ownerMap translates the user and group ids of extracted files (--owner-map)
+type ownerMap struct {
+	uids map[int]int
+	gids map[int]int
+}
+
+// parseOwnerMap parses comma separated FROM:TO id pairs. A "u" or "g"
+// prefix restricts a pair to user or group ids, otherwise both are mapped.
+func parseOwnerMap(spec string) (ownerMap, error) {
+	m := ownerMap{uids: make(map[int]int), gids: make(map[int]int)}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		users, groups := true, true
+		if rest, ok := strings.CutPrefix(pair, "u"); ok {
+			pair, groups = rest, false
+		} else if rest, ok := strings.CutPrefix(pair, "g"); ok {
+			pair, users = rest, false
+		}
+		from, to, ok := strings.Cut(pair, ":")
+		if !ok {
+			return m, fmt.Errorf("invalid owner mapping %q, expected FROM:TO", pair)
+		}
+		fromID, err := strconv.Atoi(from)
+		if err != nil || fromID < 0 {
+			return m, fmt.Errorf("invalid id %q in owner mapping", from)
+		}
+		toID, err := strconv.Atoi(to)
+		if err != nil || toID < 0 {
+			return m, fmt.Errorf("invalid id %q in owner mapping", to)
+		}
+		if users {
+			m.uids[fromID] = toID
+		}
+		if groups {
+			m.gids[fromID] = toID
+		}
+	}
+	return m, nil
+}
+
+// uid returns the mapped user id, unmapped ids are kept
+func (m ownerMap) uid(id int) int {
+	if mapped, ok := m.uids[id]; ok {
+		return mapped
+	}
+	return id
+}
+
+// gid returns the mapped group id, unmapped ids are kept
+func (m ownerMap) gid(id int) int {
+	if mapped, ok := m.gids[id]; ok {
+		return mapped
+	}
+	return id
+}
@@ -0,0 +1,38 @@
+package main
+
+// Listings of older versions and snapshots have the owners only as
+// "name(id)" strings, newer ones have separate fields for ids and names.
+
+// fileUID returns the numeric user id of file
+func fileUID(file FileInfo) (int, error) {
+	if file.UID != nil {
+		return int(*file.UID), nil
+	}
+	return extractID(file.User)
+}
+
+// fileGID returns the numeric group id of file
+func fileGID(file FileInfo) (int, error) {
+	if file.GID != nil {
+		return int(*file.GID), nil
+	}
+	return extractID(file.Group)
+}
+
+// fileUserName returns the name of the user owning file, "" if the image
+// does not know the id
+func fileUserName(file FileInfo) string {
+	if file.UID != nil {
+		return file.UserName
+	}
+	return ownerName(file.User)
+}
+
+// fileGroupName returns the name of the group owning file, "" if the image
+// does not know the id
+func fileGroupName(file FileInfo) string {
+	if file.GID != nil {
+		return file.GroupName
+	}
+	return ownerName(file.Group)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// changedFields returns the comma separated names of the changed fields
+func changedFields(changes []FieldChange) string {
+	var fields []string
+	for _, change := range changes {
+		if !slices.Contains(fields, change.Field) {
+			fields = append(fields, change.Field)
+		}
+	}
+	if len(fields) == 0 {
+		return "-"
+	}
+	return strings.Join(fields, ",")
+}
+
+// porcelainPath quotes paths with characters that would break the line
+// format, like git does
+func porcelainPath(path string) string {
+	if strings.ContainsAny(path, "\t\n\"\\") {
+		return strconv.Quote(path)
+	}
+	return path
+}
+
+// printPorcelain writes one stable line per change like git status
+// --porcelain: "A\tpath", "D\tpath", "M\tfields\tpath" and "R\told\tnew"
+func printPorcelain(w io.Writer, result *Result) {
+	// Lines are sorted by path, so the output is stable
+	differences := slices.Clone(result.Differences)
+	sort.SliceStable(differences, func(i, j int) bool {
+		return differences[i].Path < differences[j].Path
+	})
+	for _, diff := range differences {
+		switch diff.Type {
+		case Added:
+			fmt.Fprintf(w, "A\t%s\n", porcelainPath(diff.Path))
+		case Removed:
+			fmt.Fprintf(w, "D\t%s\n", porcelainPath(diff.Path))
+		case Modified:
+			fmt.Fprintf(w, "M\t%s\t%s\n", changedFields(diff.Changes), porcelainPath(diff.Path))
+		case Renamed:
+			fmt.Fprintf(w, "R\t%s\t%s\n", porcelainPath(diff.OldPath), porcelainPath(diff.Path))
+		}
+	}
+}
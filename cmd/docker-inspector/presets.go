@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"github.com/alexflint/go-arg"
+	"os"
+	"sort"
+	"strings"
+)
+
+// defaultPresetFile is read when --preset-file is not given
+const defaultPresetFile = ".dockerinspectorpresets"
+
+// ignorePresets are --diff-ignore patterns for files that change with every
+// build or container run without meaningful differences
+var ignorePresets = map[string][]string{
+	"container-runtime": {
+		"/.dockerenv",
+		"/etc/hostname",
+		"/etc/hosts",
+		"/etc/resolv.conf",
+		"/etc/mtab",
+		"/run/**",
+		"/tmp/**",
+		"/var/tmp/**",
+	},
+	"apt": {
+		"/var/lib/apt/lists/**",
+		"/var/cache/apt/**",
+		"/var/cache/debconf/*-old",
+		"/var/lib/dpkg/*-old",
+		"/var/log/apt/**",
+		"/var/log/dpkg.log",
+	},
+	"apk": {
+		"/var/cache/apk/**",
+		"/etc/apk/cache/**",
+	},
+	"dnf": {
+		"/var/cache/dnf/**",
+		"/var/cache/yum/**",
+		"/var/lib/dnf/history*",
+		"/var/lib/rpm/__db.*",
+		"/var/log/dnf*",
+		"/var/log/yum.log",
+	},
+	"pip": {
+		"**/__pycache__/**",
+		"**/*.pyc",
+		"/root/.cache/pip/**",
+		"**/*.dist-info/direct_url.json",
+	},
+	"npm": {
+		"/root/.npm/**",
+		"**/node_modules/.cache/**",
+		"**/node_modules/.package-lock.json",
+	},
+	"ldconfig": {
+		"/etc/ld.so.cache",
+	},
+	"logs": {
+		"/var/log/**",
+	},
+}
+
+// loadPresetFile adds the presets of a file to ignorePresets. The file has
+// a "[name]" line per preset followed by its patterns, presets with the name
+// of a built-in one extend it.
+func loadPresetFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	name := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if name == "" {
+			return fmt.Errorf("%s:%d: pattern before the first [preset] line", path, i+1)
+		}
+		ignorePresets[name] = append(ignorePresets[name], line)
+	}
+	return nil
+}
+
+// loadPresets reads the given preset file or the default one if it exists
+func loadPresets(path string) error {
+	if path == "" {
+		if _, err := os.Stat(defaultPresetFile); err != nil {
+			return nil
+		}
+		path = defaultPresetFile
+	}
+	if err := loadPresetFile(path); err != nil {
+		return fmt.Errorf("failed to read presets: %v", err)
+	}
+	return nil
+}
+
+// presetPatterns returns the patterns of the comma separated presets
+func presetPatterns(lists []string) ([]string, error) {
+	var patterns []string
+	for _, list := range lists {
+		for _, name := range strings.Split(list, ",") {
+			name = strings.TrimSpace(name)
+			preset, ok := ignorePresets[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown preset %q, available presets: %s",
+					name, strings.Join(presetNames(), ", "))
+			}
+			patterns = append(patterns, preset...)
+		}
+	}
+	return patterns, nil
+}
+
+func presetNames() []string {
+	names := make([]string, 0, len(ignorePresets))
+	for name := range ignorePresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type PresetsArgs struct {
+	PresetFile string `arg:"--preset-file" help:"file with additional presets [default: .dockerinspectorpresets]"`
+}
+
+func (PresetsArgs) Description() string {
+	return "Lists the presets of --ignore-preset with their patterns"
+}
+
+// runPresets implements "docker-inspector presets"
+func runPresets(cmdArgs []string) {
+	var presetsArgs PresetsArgs
+	parser, err := arg.NewParser(arg.Config{Program: "docker-inspector presets"}, &presetsArgs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	parser.MustParse(cmdArgs)
+	if err := loadPresets(presetsArgs.PresetFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	for _, name := range presetNames() {
+		fmt.Printf("[%s]\n", name)
+		for _, pattern := range ignorePresets[name] {
+			fmt.Println(pattern)
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	_ "embed"
+	"strings"
+	"sync"
+)
+
+//go:embed public_suffix_list.dat
+var publicSuffixData string
+
+var (
+	publicSuffixOnce sync.Once
+	// publicSuffixRules holds the rules of the list, wildcards as "*.ck"
+	// and exceptions as "!www.ck"
+	publicSuffixRules map[string]bool
+)
+
+// publicSuffix returns the public suffix of a host name like co.uk for
+// registry.example.co.uk, by the rules of the public suffix list: the
+// longest matching rule wins unless an exception matches, and hosts no
+// rule matches have their top level domain as public suffix
+func publicSuffix(host string) string {
+	publicSuffixOnce.Do(func() {
+		publicSuffixRules = make(map[string]bool)
+		for _, line := range strings.Split(publicSuffixData, "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "//") {
+				publicSuffixRules[line] = true
+			}
+		}
+	})
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(host, ".")), ".")
+	for i := range labels {
+		name := strings.Join(labels[i:], ".")
+		if publicSuffixRules["!"+name] {
+			return strings.Join(labels[i+1:], ".")
+		}
+		if publicSuffixRules[name] {
+			return name
+		}
+		if i+1 < len(labels) && publicSuffixRules["*."+strings.Join(labels[i+1:], ".")] {
+			return name
+		}
+	}
+	return labels[len(labels)-1]
+}
@@ -0,0 +1,28 @@
+package main
+
+import "fmt"
+
+// requireLocalImages fails for the first image that is not available
+// locally, so --pull never stops before any inspection ran
+func requireLocalImages(images []string) error {
+	var engine containerRuntime
+	for _, image := range images {
+		if image == "" || isDaemonless(image) {
+			continue
+		}
+		if engine == nil {
+			var err error
+			if engine, err = containerEngine(); err != nil {
+				return err
+			}
+		}
+		_, err := engine.imageDetails(image)
+		if isNotFound(err) {
+			return fmt.Errorf("image %s is not available locally and is not pulled with --pull never", image)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to inspect image %s: %v", image, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// Media types of the manifests the registry is asked for
+const (
+	ociIndexType        = "application/vnd.oci.image.index.v1+json"
+	ociManifestType     = "application/vnd.oci.image.manifest.v1+json"
+	dockerListType      = "application/vnd.docker.distribution.manifest.list.v2+json"
+	dockerManifestType  = "application/vnd.docker.distribution.manifest.v2+json"
+	manifestAcceptTypes = ociIndexType + ", " + dockerListType + ", " + ociManifestType + ", " + dockerManifestType
+)
+
+// descriptor references a manifest or blob by its digest
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	// Annotations name the images of an OCI image layout
+	Annotations map[string]string `json:"annotations"`
+	Platform    *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+		Variant      string `json:"variant"`
+	} `json:"platform"`
+}
+
+// manifest is an image manifest or an index of the manifests of the
+// platforms of a multi-arch image
+type manifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    descriptor   `json:"config"`
+	Layers    []descriptor `json:"layers"`
+	Manifests []descriptor `json:"manifests"`
+}
+
+// imageConfig is the part of the image config used for the image details
+type imageConfig struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant"`
+	Config       struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"config"`
+	RootFS struct {
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// registryClient talks to the registry API of a repository
+type registryClient struct {
+	// registry is the name credentials are stored under, like docker.io
+	registry   string
+	baseURL    string
+	repository string
+	// authorization is sent with every request once the registry asked for
+	// it, expired tokens are renewed when the registry asks again
+	authorization string
+}
+
+// registryImage is an image read from a registry
+type registryImage struct {
+	client    *registryClient
+	reference string
+	manifest  manifest
+	config    imageConfig
+}
+
+// openRegistryImage resolves the reference to the manifest of the platform
+// (the host architecture if empty) and fetches the image config
+func openRegistryImage(reference, platform string) (*registryImage, error) {
+	client, tag, err := newRegistryClient(reference)
+	if err != nil {
+		return nil, err
+	}
+	image := &registryImage{client: client, reference: reference}
+	if err := client.getJSON("/manifests/"+tag, manifestAcceptTypes, "", &image.manifest); err != nil {
+		return nil, fmt.Errorf("failed to fetch the manifest of %s: %v", reference, err)
+	}
+	if len(image.manifest.Manifests) > 0 {
+		selected, err := selectPlatform(image.manifest.Manifests, platform)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", reference, err)
+		}
+		image.manifest = manifest{}
+		if err := client.getJSON("/manifests/"+selected.Digest, manifestAcceptTypes, selected.Digest, &image.manifest); err != nil {
+			return nil, fmt.Errorf("failed to fetch the manifest of %s: %v", reference, err)
+		}
+	}
+	if image.manifest.Config.Digest == "" {
+		return nil, fmt.Errorf("%s has no image manifest", reference)
+	}
+	if err := client.getJSON("/blobs/"+image.manifest.Config.Digest, "", image.manifest.Config.Digest, &image.config); err != nil {
+		return nil, fmt.Errorf("failed to fetch the config of %s: %v", reference, err)
+	}
+	return image, nil
+}
+
+func (i *registryImage) layers() ([]imageLayer, error) {
+	layers := make([]imageLayer, len(i.manifest.Layers))
+	for n, layer := range i.manifest.Layers {
+		digest := layer.Digest
+		layers[n] = imageLayer{Digest: digest, open: func() (io.ReadCloser, error) {
+			return i.client.blob(digest)
+		}}
+	}
+	return layers, nil
+}
+
+func (i *registryImage) details() (imageDetails, error) {
+	details := imageDetails{
+		ID:           i.manifest.Config.Digest,
+		RepoTags:     []string{i.reference},
+		Os:           i.config.OS,
+		Architecture: i.config.Architecture,
+		Variant:      i.config.Variant,
+	}
+	details.Config.Labels = i.config.Config.Labels
+	details.RootFS.Layers = i.config.RootFS.DiffIDs
+	return details, nil
+}
+
+// selectPlatform picks the manifest of the platform from an index, the
+// variant only has to match if the platform names one
+func selectPlatform(manifests []descriptor, platform string) (descriptor, error) {
+	if platform == "" {
+		platform = "linux/" + runtime.GOARCH
+	}
+	parts := strings.Split(platform, "/")
+	var available []string
+	for _, m := range manifests {
+		if m.Platform == nil {
+			continue
+		}
+		if m.Platform.OS == parts[0] && m.Platform.Architecture == parts[1] &&
+			(len(parts) < 3 || m.Platform.Variant == parts[2]) {
+			return m, nil
+		}
+		name := m.Platform.OS + "/" + m.Platform.Architecture
+		if m.Platform.Variant != "" {
+			name += "/" + m.Platform.Variant
+		}
+		available = append(available, name)
+	}
+	return descriptor{}, fmt.Errorf("no %s variant, available are %s", platform, strings.Join(available, ", "))
+}
+
+// newRegistryClient parses an image reference like the docker CLI does and
+// returns the client of its repository and the tag or digest
+func newRegistryClient(reference string) (*registryClient, string, error) {
+	name, tag := reference, "latest"
+	if before, digest, ok := strings.Cut(reference, "@"); ok {
+		name, tag = before, digest
+	} else if i := strings.LastIndex(reference, ":"); i > strings.LastIndex(reference, "/") {
+		name, tag = reference[:i], reference[i+1:]
+	}
+	if name == "" || tag == "" {
+		return nil, "", fmt.Errorf("invalid image reference %q", reference)
+	}
+	registry := imageRegistry(name)
+	repository := name
+	if registry != "docker.io" || strings.HasPrefix(name, "docker.io/") {
+		repository = name[strings.Index(name, "/")+1:]
+	}
+	if registry == "index.docker.io" {
+		registry = "docker.io"
+	}
+	host := registry
+	if registry == "docker.io" {
+		host = "registry-1.docker.io"
+		if !strings.Contains(repository, "/") {
+			repository = "library/" + repository
+		}
+	}
+	// Local registries usually run without TLS
+	scheme := "https"
+	if hostname, _, _ := strings.Cut(host, ":"); hostname == "localhost" || hostname == "127.0.0.1" {
+		scheme = "http"
+	}
+	client := &registryClient{
+		registry:   registry,
+		baseURL:    scheme + "://" + host + "/v2/" + repository,
+		repository: repository,
+	}
+	return client, tag, nil
+}
+
+// get requests a path of the repository, authenticating as the registry
+// asks for it
+func (c *registryClient) get(path, accept string) (*http.Response, error) {
+	resp, err := c.do(path, accept)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		if err := c.authenticate(resp.Header.Get("WWW-Authenticate")); err != nil {
+			return nil, err
+		}
+		if resp, err = c.do(path, accept); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, registryError(resp)
+	}
+	return resp, nil
+}
+
+func (c *registryClient) do(path, accept string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if c.authorization != "" {
+		req.Header.Set("Authorization", c.authorization)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// getJSON decodes a manifest or config, verifying its digest if given
+func (c *registryClient) getJSON(path, accept, digest string, v any) error {
+	resp, err := c.get(path, accept)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var body io.Reader = resp.Body
+	if digest != "" {
+		if body, err = newVerifiedReader(resp.Body, digest); err != nil {
+			return err
+		}
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// blob opens a layer, which is verified against its digest while it is read
+func (c *registryClient) blob(digest string) (io.ReadCloser, error) {
+	resp, err := c.get("/blobs/"+digest, "")
+	if err != nil {
+		return nil, err
+	}
+	r, err := newVerifiedReader(resp.Body, digest)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, resp.Body}, nil
+}
+
+// registryError turns an error response into the message of the registry
+func registryError(resp *http.Response) error {
+	var body struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&body) == nil && len(body.Errors) > 0 {
+		return fmt.Errorf("%s (%s)", body.Errors[0].Message, body.Errors[0].Code)
+	}
+	return fmt.Errorf("registry returned %s", resp.Status)
+}
+
+// trustedRealm reports whether credentials may be sent to the token service
+// of a challenge: over https to the registry host itself, or to its parent
+// domain or another host below it, like auth.docker.io for
+// registry-1.docker.io. A parent that is a public suffix like co.uk is not
+// trusted, any host could be registered below it.
+func (c *registryClient) trustedRealm(realm string) bool {
+	realmURL, err := url.Parse(realm)
+	if err != nil {
+		return false
+	}
+	baseURL, err := url.Parse(c.baseURL)
+	if err != nil {
+		return false
+	}
+	if realmURL.Scheme != "https" && (realmURL.Scheme != "http" || baseURL.Scheme != "http") {
+		return false
+	}
+	realmHost, registryHost := strings.ToLower(realmURL.Hostname()), strings.ToLower(baseURL.Hostname())
+	if realmHost == registryHost {
+		return true
+	}
+	if net.ParseIP(registryHost) != nil {
+		return false
+	}
+	_, parent, found := strings.Cut(registryHost, ".")
+	if !found || publicSuffix(parent) == parent {
+		return false
+	}
+	return realmHost == parent || strings.HasSuffix(realmHost, "."+parent)
+}
+
+// challengeParam matches the key="value" parameters of a WWW-Authenticate
+// challenge
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// authenticate answers the challenge of the registry with the credentials
+// found like docker pull would find them. Bearer challenges get a token
+// from the realm, which anonymous pulls of public images need as well.
+func (c *registryClient) authenticate(challenge string) error {
+	auth, found, err := registryCredentials(c.registry)
+	if err != nil {
+		return err
+	}
+	scheme, params, _ := strings.Cut(challenge, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if !found || auth.Username == "" {
+			return fmt.Errorf("%s requires credentials, use docker login or --username", c.registry)
+		}
+		c.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(auth.Username+":"+auth.Password))
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("%s asks for the unsupported authentication %q", c.registry, scheme)
+	}
+
+	values := make(map[string]string)
+	for _, match := range challengeParam.FindAllStringSubmatch(params, -1) {
+		values[match[1]] = match[2]
+	}
+	if values["realm"] == "" {
+		return fmt.Errorf("%s sent a challenge without realm", c.registry)
+	}
+	if found && !c.trustedRealm(values["realm"]) {
+		fmt.Fprintf(os.Stderr, "Warning: not sending the credentials for %s to the token service %s\n", c.registry, values["realm"])
+		found = false
+	}
+	scope := values["scope"]
+	if scope == "" {
+		scope = "repository:" + c.repository + ":pull"
+	}
+	var req *http.Request
+	if found && auth.IdentityToken != "" {
+		// Tokens of docker login are exchanged like OAuth2 refresh tokens
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {auth.IdentityToken},
+			"service":       {values["service"]},
+			"scope":         {scope},
+			"client_id":     {"docker-inspector"},
+		}
+		req, err = http.NewRequest("POST", values["realm"], strings.NewReader(form.Encode()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		query := url.Values{"scope": {scope}}
+		if values["service"] != "" {
+			query.Set("service", values["service"])
+		}
+		req, err = http.NewRequest("GET", values["realm"]+"?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		if found && auth.Username != "" {
+			req.SetBasicAuth(auth.Username, auth.Password)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to authenticate at %s: %v", c.registry, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to authenticate at %s: %s", c.registry, resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("failed to authenticate at %s: %v", c.registry, err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	c.authorization = "Bearer " + token.Token
+	return nil
+}
+
+// verifiedReader fails at the end of the content if it does not match the
+// digest, so a corrupted blob is not taken for the image
+type verifiedReader struct {
+	r      io.Reader
+	hash   hash.Hash
+	digest string
+}
+
+func newVerifiedReader(r io.Reader, digest string) (*verifiedReader, error) {
+	algorithm, _, _ := strings.Cut(digest, ":")
+	if algorithm != "sha256" {
+		return nil, fmt.Errorf("unsupported digest %s", digest)
+	}
+	return &verifiedReader{r: r, hash: sha256.New(), digest: digest}, nil
+}
+
+func (v *verifiedReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF {
+		if sum := "sha256:" + hex.EncodeToString(v.hash.Sum(nil)); sum != v.digest {
+			return n, fmt.Errorf("digest mismatch, expected %s but got %s", v.digest, sum)
+		}
+	}
+	return n, err
+}
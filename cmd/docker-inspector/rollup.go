@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// ChangeStats counts the differences of a group of files
+type ChangeStats struct {
+	Added    int `json:"added"`
+	Removed  int `json:"removed"`
+	Modified int `json:"modified"`
+	Renamed  int `json:"renamed"`
+	// SizeDelta is the change of the total file size in bytes
+	SizeDelta int64 `json:"sizeDelta"`
+}
+
+// DirectorySummary aggregates the differences below a directory
+type DirectorySummary struct {
+	Path string `json:"path"`
+	ChangeStats
+}
+
+// ExtensionSummary aggregates the differences of files with an extension
+type ExtensionSummary struct {
+	Extension string `json:"extension"`
+	ChangeStats
+}
+
+// add counts a difference
+func (stats *ChangeStats) add(diff FileDiff) {
+	switch diff.Type {
+	case Added:
+		stats.Added++
+	case Removed:
+		stats.Removed++
+	case Modified:
+		stats.Modified++
+	case Renamed:
+		stats.Renamed++
+	}
+	stats.SizeDelta += contentSize(diff.NewFile) - contentSize(diff.OldFile)
+}
+
+// rollupDirectories adds the differences per directory, cut to depth path
+// components, to the summary of result
+func rollupDirectories(result *Result, depth int) {
+	if depth <= 0 {
+		return
+	}
+	byDir := make(map[string]*DirectorySummary)
+	for _, diff := range result.Differences {
+		dir := rollupDir(diff.Path, depth)
+		summary := byDir[dir]
+		if summary == nil {
+			summary = &DirectorySummary{Path: dir}
+			byDir[dir] = summary
+		}
+		summary.add(diff)
+	}
+
+	result.Summary.Directories = make([]DirectorySummary, 0, len(byDir))
+	for _, summary := range byDir {
+		result.Summary.Directories = append(result.Summary.Directories, *summary)
+	}
+	sort.Slice(result.Summary.Directories, func(i, j int) bool {
+		return result.Summary.Directories[i].Path < result.Summary.Directories[j].Path
+	})
+}
+
+// rollupExtensions adds the differences of files (not directories) per
+// lowercased extension to the summary of result
+func rollupExtensions(result *Result) {
+	byExt := make(map[string]*ExtensionSummary)
+	for _, diff := range result.Differences {
+		if diff.OldFile.IsDir || diff.NewFile.IsDir {
+			continue
+		}
+		ext := fileExtension(diff.Path)
+		summary := byExt[ext]
+		if summary == nil {
+			summary = &ExtensionSummary{Extension: ext}
+			byExt[ext] = summary
+		}
+		summary.add(diff)
+	}
+	if len(byExt) == 0 {
+		return
+	}
+
+	result.Summary.Extensions = make([]ExtensionSummary, 0, len(byExt))
+	for _, summary := range byExt {
+		result.Summary.Extensions = append(result.Summary.Extensions, *summary)
+	}
+	sort.Slice(result.Summary.Extensions, func(i, j int) bool {
+		return result.Summary.Extensions[i].Extension < result.Summary.Extensions[j].Extension
+	})
+}
+
+// fileExtension returns the lowercased extension of a path with the dot,
+// or "(none)". Dot files like .bashrc have no extension.
+func fileExtension(file string) string {
+	base := path.Base(file)
+	ext := path.Ext(base)
+	if ext == "" || ext == base {
+		return "(none)"
+	}
+	return strings.ToLower(ext)
+}
+
+// contentSize is the size of a file, directory entries and additional
+// hardlinks do not count
+func contentSize(file FileInfo) int64 {
+	if file.IsDir || file.HardlinkTo != "" {
+		return 0
+	}
+	return file.Size
+}
+
+// rollupDir returns the directory of file with at most depth components
+func rollupDir(file string, depth int) string {
+	parts := strings.Split(strings.Trim(path.Dir(file), "/"), "/")
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+	return path.Join(append([]string{"/"}, parts...)...)
+}
+
+func printDirectorySummary(w io.Writer, directories []DirectorySummary, human bool) {
+	fmt.Fprintln(w, "Changes by directory:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "ADDED\tREMOVED\tMODIFIED\tRENAMED\tSIZE\t")
+	for _, dir := range directories {
+		printChangeStats(tw, dir.ChangeStats, dir.Path, human)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+}
+
+func printExtensionSummary(w io.Writer, extensions []ExtensionSummary, human bool) {
+	fmt.Fprintln(w, "Changes by extension:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "ADDED\tREMOVED\tMODIFIED\tRENAMED\tSIZE\t")
+	for _, ext := range extensions {
+		printChangeStats(tw, ext.ChangeStats, ext.Extension, human)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+}
+
+func printChangeStats(tw *tabwriter.Writer, stats ChangeStats, name string, human bool) {
+	fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%s\t  %s\n", stats.Added, stats.Removed, stats.Modified, stats.Renamed,
+		formatDelta(stats.SizeDelta, human), name)
+}
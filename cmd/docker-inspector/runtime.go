@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/alexflint/go-arg"
+)
+
+// containerRuntime runs the inspector container and answers the questions
+// about images and containers the inspections need
+type containerRuntime interface {
+	// run runs the container and writes its stdout and stderr to the
+	// writers while it runs, a missing image is pulled
+	run(spec containerSpec, stdout, stderr io.Writer) error
+	// endpoint describes the daemon or command used, for messages
+	endpoint() string
+	// isRemote reports whether host directories can not be mounted
+	isRemote() bool
+	// isRootless reports whether the runtime uses a user namespace
+	isRootless() (bool, error)
+	// arch returns the GOARCH of the daemon, which it pulls images for
+	arch() (string, error)
+	imageDetails(image string) (imageDetails, error)
+	imageIDs() ([]string, error)
+	removeImage(image string) error
+	containerImage(container string) (string, error)
+	commitContainer(container string) (string, error)
+}
+
+// runtimeNames are the values of --runtime
+var runtimeNames = []string{"auto", "docker", "podman", "nerdctl"}
+
+var (
+	runtimeOnce   sync.Once
+	sharedRuntime containerRuntime
+	runtimeErr    error
+	// runtimeName is the --runtime to use
+	runtimeName = "auto"
+	// dockerContextName is the --context to use, "" for the one the docker
+	// CLI would use
+	dockerContextName string
+	// dockerHost is the --host to use instead of DOCKER_HOST
+	dockerHost string
+)
+
+// RuntimeArgs are the options selecting the container runtime and the
+// image variant, the inspecting commands share them
+type RuntimeArgs struct {
+	Podman   bool   `arg:"--podman" help:"use Podman, same as --runtime podman"`
+	Runtime  string `arg:"--runtime" default:"auto" help:"container runtime: auto, docker, podman or nerdctl"`
+	Host     string `arg:"--host" help:"address of the docker daemon like DOCKER_HOST: unix://, tcp://host:2376 or ssh://user@server"`
+	Context  string `arg:"--context" help:"docker context (see docker context ls) of the daemon to use, instead of DOCKER_HOST or the current one"`
+	Platform string `arg:"--platform" help:"platform variant of multi-arch images to inspect, like linux/arm64 or linux/arm/v7"`
+	Pull     string `arg:"--pull" default:"missing" help:"pull the image before inspecting: always, missing (if not available locally) or never"`
+}
+
+// apply validates the options and selects the runtime they name
+func (r RuntimeArgs) apply(parser *arg.Parser) {
+	if !slices.Contains(runtimeNames, r.Runtime) {
+		parser.Fail(fmt.Sprintf("--runtime must be one of %s", strings.Join(runtimeNames, ", ")))
+	}
+	runtimeName = r.Runtime
+	if r.Podman {
+		if runtimeName != "auto" && runtimeName != "podman" {
+			parser.Fail(fmt.Sprintf("--podman can not be used with --runtime %s", runtimeName))
+		}
+		runtimeName = "podman"
+	}
+	if r.Context != "" {
+		if runtimeName != "auto" && runtimeName != "docker" {
+			parser.Fail("--context can only be used with the docker runtime")
+		}
+		dockerContextName = r.Context
+	}
+	if r.Platform != "" {
+		parts := strings.Split(r.Platform, "/")
+		if len(parts) < 2 || len(parts) > 3 || slices.Contains(parts, "") {
+			parser.Fail(fmt.Sprintf("--platform must be os/arch[/variant] like linux/arm64, not %q", r.Platform))
+		}
+		imagePlatform = r.Platform
+	}
+	switch r.Pull {
+	case "always", "missing", "never":
+	default:
+		parser.Fail(fmt.Sprintf("--pull must be always, missing or never, not %q", r.Pull))
+	}
+	if r.Host != "" {
+		if runtimeName != "auto" && runtimeName != "docker" {
+			parser.Fail("--host can only be used with the docker runtime")
+		}
+		if r.Context != "" {
+			parser.Fail("--host and --context can not be used together")
+		}
+		dockerHost = r.Host
+	}
+}
+
+// containerEngine returns the selected runtime, which is shared by
+// concurrent inspections
+func containerEngine() (containerRuntime, error) {
+	runtimeOnce.Do(func() {
+		sharedRuntime, runtimeErr = newRuntime(runtimeName)
+	})
+	return sharedRuntime, runtimeErr
+}
+
+// runContainer runs the container with the selected runtime
+func runContainer(spec containerSpec, stdout, stderr io.Writer) error {
+	engine, err := containerEngine()
+	if err != nil {
+		return err
+	}
+	return engine.run(spec, stdout, stderr)
+}
+
+// newRuntime creates the runtime of that name. Automatically the first
+// one found is used: --host, --context or DOCKER_HOST, CONTAINER_HOST (Podman), a
+// selected docker context, the Docker socket, the Podman socket and then nerdctl, which has no API socket and
+// is run as command.
+func newRuntime(name string) (containerRuntime, error) {
+	switch name {
+	case "docker":
+		return newDockerClient()
+	case "podman":
+		if host := os.Getenv("CONTAINER_HOST"); host != "" {
+			return newEngineClient(host, nil)
+		}
+		if socket := podmanSocket(); socket != "" {
+			return newEngineClient("unix://"+socket, nil)
+		}
+		return nil, fmt.Errorf("no Podman socket found, start it with: systemctl --user start podman.socket")
+	case "nerdctl":
+		if _, err := exec.LookPath("nerdctl"); err != nil {
+			return nil, fmt.Errorf("nerdctl not found: %v", err)
+		}
+		return &cliRuntime{command: "nerdctl"}, nil
+	case "auto":
+		switch {
+		case dockerHost != "", dockerContextName != "", os.Getenv("DOCKER_HOST") != "":
+			return newRuntime("docker")
+		case os.Getenv("CONTAINER_HOST") != "":
+			return newRuntime("podman")
+		case currentDockerContext() != "default":
+			return newRuntime("docker")
+		case socketExists(defaultDockerHost):
+			return newRuntime("docker")
+		case podmanSocket() != "":
+			return newRuntime("podman")
+		}
+		if _, err := exec.LookPath("nerdctl"); err == nil {
+			return newRuntime("nerdctl")
+		}
+		// Connecting fails with the message of the missing Docker daemon
+		return newRuntime("docker")
+	default:
+		return nil, fmt.Errorf("unknown runtime %q, use one of %s", name, strings.Join(runtimeNames, ", "))
+	}
+}
+
+// newDockerClient connects to the daemon the docker CLI would use: the
+// --host, --context, DOCKER_HOST or the context selected with "docker
+// context use". The default context is DOCKER_HOST or the default socket.
+func newDockerClient() (*engineClient, error) {
+	if dockerHost != "" {
+		tlsConfig, err := dockerTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		return newEngineClient(dockerHost, tlsConfig)
+	}
+	name := dockerContextName
+	if name == "" && os.Getenv("DOCKER_HOST") == "" {
+		name = currentDockerContext()
+	}
+	if name == "" || name == "default" {
+		host := os.Getenv("DOCKER_HOST")
+		if host == "" {
+			host = defaultDockerHost
+		}
+		tlsConfig, err := dockerTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		return newEngineClient(host, tlsConfig)
+	}
+	context, err := loadDockerContext(name)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := context.tlsConfig()
+	if err != nil {
+		return nil, fmt.Errorf("docker context %q: %v", name, err)
+	}
+	return newEngineClient(context.Host, tlsConfig)
+}
+
+// socketExists reports whether the unix socket of a daemon address exists,
+// other addresses can not be checked without connecting
+func socketExists(host string) bool {
+	socket, ok := strings.CutPrefix(host, "unix://")
+	if !ok {
+		return true
+	}
+	_, err := os.Stat(socket)
+	return err == nil
+}
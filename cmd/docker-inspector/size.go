@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a size in bytes that can be given on the command line
+// with an optional unit suffix (e.g. 512, 10K, 100MB, 2GiB)
+type ByteSize int64
+
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+	{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"TB", 1 << 40},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler for go-arg
+func (s *ByteSize) UnmarshalText(b []byte) error {
+	text := strings.ToUpper(strings.TrimSpace(string(b)))
+	factor := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(text, unit.suffix) {
+			text = strings.TrimSpace(strings.TrimSuffix(text, unit.suffix))
+			factor = unit.factor
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil || value < 0 {
+		return fmt.Errorf("invalid size %q", string(b))
+	}
+	*s = ByteSize(value * float64(factor))
+	return nil
+}
+
+// humanSize formats a byte count like ls -h does (e.g. 512, 1.2K, 34M, 2.1G)
+func humanSize(n int64) string {
+	if n < 1024 {
+		return strconv.FormatInt(n, 10)
+	}
+	value := float64(n)
+	unit := 0
+	for value >= 1024 && unit < len(humanUnits)-1 {
+		value /= 1024
+		unit++
+	}
+	if value < 10 {
+		return fmt.Sprintf("%.1f%s", value, humanUnits[unit])
+	}
+	return fmt.Sprintf("%.0f%s", value, humanUnits[unit])
+}
+
+var humanUnits = []string{"", "K", "M", "G", "T", "P", "E"}
+
+// formatBytes returns n as plain number or human readable with --human
+func formatBytes(n int64, human bool) string {
+	if human {
+		return humanSize(n)
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// formatTotal is formatBytes for sizes in sentences
+func formatTotal(n int64, human bool) string {
+	if human {
+		return humanSize(n)
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// formatDelta is formatBytes for size changes, always with a sign
+func formatDelta(n int64, human bool) string {
+	if n < 0 {
+		return "-" + formatBytes(-n, human)
+	}
+	return "+" + formatBytes(n, human)
+}
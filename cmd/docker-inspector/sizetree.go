@@ -0,0 +1,64 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// sizeNode is a directory (or file) with the accumulated size of its contents
+type sizeNode struct {
+	Name     string      `json:"name"`
+	Path     string      `json:"path"`
+	Size     int64       `json:"size"`
+	Children []*sizeNode `json:"children,omitempty"`
+	index    map[string]*sizeNode
+}
+
+// buildSizeTree accumulates the file sizes along their directories, biggest
+// children first. Hardlinks are counted once.
+func buildSizeTree(files []FileInfo) *sizeNode {
+	root := &sizeNode{Name: "/", Path: "/"}
+	for _, file := range files {
+		if file.Path == "/" {
+			continue
+		}
+		size := file.Size
+		if file.IsDir || file.HardlinkTo != "" {
+			size = 0
+		}
+		node := root
+		node.Size += size
+		for _, name := range strings.Split(strings.Trim(file.Path, "/"), "/") {
+			node = node.child(name)
+			node.Size += size
+		}
+	}
+	root.sort()
+	return root
+}
+
+func (n *sizeNode) child(name string) *sizeNode {
+	if n.index == nil {
+		n.index = make(map[string]*sizeNode)
+	}
+	if c, ok := n.index[name]; ok {
+		return c
+	}
+	c := &sizeNode{Name: name, Path: path.Join(n.Path, name)}
+	n.index[name] = c
+	n.Children = append(n.Children, c)
+	return c
+}
+
+func (n *sizeNode) sort() {
+	sort.SliceStable(n.Children, func(i, j int) bool {
+		if n.Children[i].Size != n.Children[j].Size {
+			return n.Children[i].Size > n.Children[j].Size
+		}
+		return n.Children[i].Name < n.Children[j].Name
+	})
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
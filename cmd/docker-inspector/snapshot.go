@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/alexflint/go-arg"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SnapshotArgs are the arguments of the snapshot command
+type SnapshotArgs struct {
+	Image    string   `arg:"positional,required" help:"docker image to snapshot"`
+	Output   string   `arg:"-o,--output" help:"snapshot file, gzip compressed if it ends with .gz [default: IMAGE.snap.json.gz]"`
+	Paths    []string `arg:"--path,separate" help:"path inside the container to include (repeatable) [default: /]"`
+	Excludes []string `arg:"--exclude,separate" help:"glob pattern for files to skip (repeatable)"`
+	MD5      bool     `arg:"--md5" help:"calculate MD5 checksums in addition to SHA256"`
+	Xattrs   bool     `arg:"--xattrs" help:"include extended attributes"`
+	RuntimeArgs
+}
+
+func (SnapshotArgs) Description() string {
+	return "Writes a listing of the image including SHA256 checksums for later comparisons with --against"
+}
+
+// runSnapshot implements "docker-inspector snapshot IMAGE"
+func runSnapshot(cmdArgs []string) {
+	var snapArgs SnapshotArgs
+	parser, err := arg.NewParser(arg.Config{Program: "docker-inspector snapshot"}, &snapArgs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	parser.MustParse(cmdArgs)
+	snapArgs.RuntimeArgs.apply(parser)
+	if snapArgs.Output == "" {
+		snapArgs.Output = snapshotName(snapArgs.Image)
+	}
+
+	args := Args{
+		RuntimeArgs: snapArgs.RuntimeArgs,
+		Image1:      snapArgs.Image,
+		Paths:       snapArgs.Paths,
+		Excludes:    snapArgs.Excludes,
+		MD5:         snapArgs.MD5,
+		Xattrs:      snapArgs.Xattrs,
+	}
+	count, err := writeSnapshot(args, snapArgs.Output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Snapshot of %d files written to %s\n", count, snapArgs.Output)
+}
+
+// writeSnapshot inspects the image with SHA256 checksums and writes the
+// listing in the JSON envelope to path. It returns the number of files.
+func writeSnapshot(args Args, path string) (int, error) {
+	args.SHA256 = true
+	output, err := runInspector(args.Image1, args)
+	if err != nil {
+		return 0, fmt.Errorf("inspection failed: %v", err)
+	}
+	var files []FileInfo
+	if err := json.Unmarshal(output, &files); err != nil {
+		return 0, fmt.Errorf("failed to parse inspection results: %v", err)
+	}
+
+	out, err := openOutput(path)
+	if err != nil {
+		return 0, err
+	}
+	if err := json.NewEncoder(out).Encode(newEnvelope(args.Image1, files)); err != nil {
+		out.Close()
+		return 0, fmt.Errorf("failed to write snapshot: %v", err)
+	}
+	return len(files), out.Close()
+}
+
+// snapshotName derives a file name from an image reference
+func snapshotName(image string) string {
+	name := strings.NewReplacer("/", "_", ":", "_", "@", "_").Replace(image)
+	return filepath.Clean(name + ".snap.json.gz")
+}
+
+// loadSnapshot reads a saved listing for comparisons. It accepts the JSON
+// envelope, a bare JSON array and NDJSON, optionally gzip compressed.
+// Snapshots saved before the listing had a type get it from the mode.
+func loadSnapshot(path string) ([]FileInfo, error) {
+	files, err := readSnapshot(path)
+	for i := range files {
+		if files[i].Type == "" {
+			files[i].Type = modeType(files[i].Mode)
+		}
+	}
+	return files, err
+}
+
+// readSnapshot decodes the listing of a snapshot file
+func readSnapshot(path string) ([]FileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %v", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = bufio.NewReader(f)
+	if magic, _ := r.(*bufio.Reader).Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress snapshot: %v", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	decoder := json.NewDecoder(r)
+	var first json.RawMessage
+	if err := decoder.Decode(&first); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot: %v", err)
+	}
+	first = bytes.TrimSpace(first)
+
+	var files []FileInfo
+	if len(first) > 0 && first[0] == '[' {
+		if err := json.Unmarshal(first, &files); err != nil {
+			return nil, fmt.Errorf("failed to parse snapshot: %v", err)
+		}
+		return files, nil
+	}
+
+	var envelope Envelope
+	if err := json.Unmarshal(first, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot: %v", err)
+	}
+	if envelope.SchemaVersion > schemaVersion {
+		return nil, fmt.Errorf("snapshot schema version %d is newer than the supported version %d",
+			envelope.SchemaVersion, schemaVersion)
+	}
+	if envelope.SchemaVersion > 0 {
+		return envelope.Files, nil
+	}
+
+	// Without an envelope every value is a file (NDJSON)
+	var file FileInfo
+	if err := json.Unmarshal(first, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot: %v", err)
+	}
+	files = append(files, file)
+	for {
+		var file FileInfo
+		if err := decoder.Decode(&file); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse snapshot: %v", err)
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// snapshotHashes reports which checksums the files of a snapshot have, so
+// the image can be inspected with the same ones
+func snapshotHashes(files []FileInfo) (bool, bool) {
+	var md5, sha256 bool
+	for _, file := range files {
+		md5 = md5 || isChecksum(file.MD5)
+		sha256 = sha256 || isChecksum(file.SHA256)
+	}
+	return md5, sha256
+}
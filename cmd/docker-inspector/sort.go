@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// sortKeys compare two files for --sort, ties are broken by path
+var sortKeys = map[string]func(a, b *FileInfo) int{
+	"path": func(a, b *FileInfo) int { return 0 },
+	"size": func(a, b *FileInfo) int { return compareInt64(a.Size, b.Size) },
+	"mtime": func(a, b *FileInfo) int {
+		if a.ModTime == nil || b.ModTime == nil {
+			return 0
+		}
+		return a.ModTime.Compare(*b.ModTime)
+	},
+	"owner": func(a, b *FileInfo) int {
+		if c := strings.Compare(a.User, b.User); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Group, b.Group)
+	},
+}
+
+// sortFiles orders the files by the given --sort key
+func sortFiles(files []FileInfo, key string, reverse bool) {
+	compare, ok := sortKeys[key]
+	if !ok {
+		compare = sortKeys["path"]
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		c := compare(&files[i], &files[j])
+		if c == 0 {
+			c = strings.Compare(files[i].Path, files[j].Path)
+		}
+		if reverse {
+			return c > 0
+		}
+		return c < 0
+	})
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// registryPrefix marks an image that is read straight from its registry,
+// without a container runtime
+const registryPrefix = "registry://"
+
+// imagePlatform is the --platform of images read without a container runtime
+var imagePlatform string
+
+// imageSource provides the layers of an image that is read without a
+// container runtime
+type imageSource interface {
+	// layers returns the filesystem layers, the lowest first
+	layers() ([]imageLayer, error)
+	details() (imageDetails, error)
+}
+
+var (
+	sourcesMu sync.Mutex
+	// sources are opened once per image, comparisons and the envelope ask
+	// for the same image more than once
+	sources = make(map[string]imageSource)
+)
+
+// isDaemonless reports whether image is read without a container runtime,
+// from a registry, an image archive or an OCI image layout
+func isDaemonless(image string) bool {
+	return strings.HasPrefix(image, registryPrefix) || strings.HasPrefix(image, ociPrefix) || isImageArchive(image)
+}
+
+// openImageSource returns the source of an image read without a container
+// runtime
+func openImageSource(image string) (imageSource, error) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	if source, ok := sources[image]; ok {
+		return source, nil
+	}
+	var source imageSource
+	var err error
+	switch {
+	case strings.HasPrefix(image, ociPrefix):
+		source, err = openOCILayout(image, imagePlatform)
+	case isImageArchive(image):
+		source, err = openImageArchive(image, imagePlatform)
+	default:
+		source, err = openRegistryImage(strings.TrimPrefix(image, registryPrefix), imagePlatform)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sources[image] = source
+	return source, nil
+}
+
+// lookupImage returns the details of an image from the container engine or
+// from its source
+func lookupImage(image string) (imageDetails, error) {
+	if isDaemonless(image) {
+		source, err := openImageSource(image)
+		if err != nil {
+			return imageDetails{}, err
+		}
+		return source.details()
+	}
+	engine, err := containerEngine()
+	if err != nil {
+		return imageDetails{}, err
+	}
+	return engine.imageDetails(image)
+}
+
+// inspectImageSource merges the layers of an image read without a container
+// runtime and writes the listing like the inspector does
+func inspectImageSource(image string, args Args, w io.Writer) error {
+	source, err := openImageSource(image)
+	if err != nil {
+		return err
+	}
+	layers, err := source.layers()
+	if err != nil {
+		return err
+	}
+	stderr := io.Writer(os.Stderr)
+	if args.stderr != nil {
+		stderr = args.stderr
+		defer args.stderr.Flush()
+	}
+	fmt.Fprintf(stderr, "Reading %d layers of %s\n", len(layers), strings.TrimPrefix(image, registryPrefix))
+	entries, err := mergeLayers(layers, args)
+	if err != nil {
+		return err
+	}
+	files, err := listLayerFiles(entries, args)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	if args.streamNDJSON {
+		for _, file := range files {
+			if err := encoder.Encode(file); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(files)
+}
+
+// daemonlessUnsupported returns the first option that needs a container
+// runtime, which images read without one can not be used with
+func daemonlessUnsupported(args Args) string {
+	options := []struct {
+		name string
+		set  bool
+	}{
+		{"--output-dir", args.OutputDir != ""},
+		{"--extract-both", args.ExtractBoth != ""},
+		{"--tar", args.Tar != ""},
+		{"--zip", args.Zip != ""},
+		{"--cat", args.Cat != ""},
+		{"--against-dir", args.AgainstDir != ""},
+		{"--against-base", args.AgainstBase},
+		{"--content-diff", args.ContentDiff},
+		{"--perm", args.Perm != ""},
+		{"--labels", args.Labels},
+		{"--attr-flags", args.AttrFlags},
+		{"--entropy", args.Entropy},
+		{"--detect-types", args.DetectTypes},
+		{"--content-type", args.ContentType != ""},
+		{"--grep", args.Grep != ""},
+		{"--elf", args.Elf},
+	}
+	for _, option := range options {
+		if option.set {
+			return option.name
+		}
+	}
+	return ""
+}
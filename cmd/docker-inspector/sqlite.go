@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// sqliteSchema creates the tables for --sqlite, existing results are replaced
+const sqliteSchema = `DROP TABLE IF EXISTS files;
+DROP TABLE IF EXISTS diffs;
+CREATE TABLE files (
+	image TEXT NOT NULL,
+	path TEXT NOT NULL,
+	type TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	allocated_size INTEGER NOT NULL,
+	mode TEXT NOT NULL,
+	mod_time TEXT,
+	user TEXT,
+	"group" TEXT,
+	symlink_to TEXT,
+	hardlink_to TEXT,
+	md5 TEXT,
+	sha256 TEXT,
+	content_type TEXT,
+	capabilities TEXT
+);
+CREATE TABLE diffs (
+	path TEXT NOT NULL,
+	change TEXT NOT NULL,
+	details TEXT
+);
+`
+
+// sqliteIndexes are created after inserting, which is faster
+const sqliteIndexes = `CREATE INDEX files_path ON files (path);
+CREATE INDEX files_size ON files (size);
+CREATE INDEX files_type ON files (type);
+CREATE INDEX files_image ON files (image);
+CREATE INDEX diffs_path ON diffs (path);
+`
+
+// writeSQLite stores the listings (and the diff when comparing) in a SQLite
+// database. There is no SQLite library in our dependencies, so the script
+// is fed to the sqlite3 command line tool.
+func writeSQLite(dbPath string, images []string, listings [][]FileInfo, result *Result) error {
+	var script strings.Builder
+	script.WriteString("BEGIN;\n")
+	script.WriteString(sqliteSchema)
+	for i, files := range listings {
+		for _, file := range files {
+			modTime := "NULL"
+			if file.ModTime != nil {
+				modTime = sqlQuote(file.ModTime.Format(time.RFC3339Nano))
+			}
+			fmt.Fprintf(&script, "INSERT INTO files VALUES (%s, %s, %s, %d, %d, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s);\n",
+				sqlQuote(images[i]), sqlQuote(file.Path), sqlQuote(file.Type),
+				file.Size, file.AllocatedSize, sqlQuote(file.Mode), modTime,
+				sqlQuote(file.User), sqlQuote(file.Group),
+				sqlNullable(file.SymlinkTo), sqlNullable(file.HardlinkTo),
+				sqlNullable(file.MD5), sqlNullable(file.SHA256),
+				sqlNullable(file.ContentType), sqlNullable(file.Capabilities))
+		}
+	}
+	if result != nil {
+		for _, diff := range result.Differences {
+			fmt.Fprintf(&script, "INSERT INTO diffs VALUES (%s, %s, %s);\n",
+				sqlQuote(diff.Path), sqlQuote(string(diff.Type)),
+				sqlNullable(strings.Join(diff.Details, "\n")))
+		}
+	}
+	script.WriteString(sqliteIndexes)
+	script.WriteString("COMMIT;\n")
+
+	cmd := exec.Command("sqlite3", "-bail", dbPath)
+	cmd.Stdin = strings.NewReader(script.String())
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run sqlite3 (is it installed?): %v", err)
+	}
+	return nil
+}
+
+// sqlQuote returns s as SQL string literal
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// sqlNullable is sqlQuote with NULL for empty strings
+func sqlNullable(s string) string {
+	if s == "" {
+		return "NULL"
+	}
+	return sqlQuote(s)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dialSSH connects to the Docker daemon of a ssh://[user@]host[:port]
+// address like the docker CLI does: "docker system dial-stdio" is run on
+// the server and the connection is its stdin and stdout. Authentication is
+// left to ssh and its configuration.
+func dialSSH(u *url.URL) (net.Conn, error) {
+	sshArgs := []string{"-o", "ConnectTimeout=30", "-T"}
+	if u.User != nil {
+		sshArgs = append(sshArgs, "-l", u.User.Username())
+	}
+	if port := u.Port(); port != "" {
+		sshArgs = append(sshArgs, "-p", port)
+	}
+	sshArgs = append(sshArgs, "--", u.Hostname(), "docker", "system", "dial-stdio")
+	cmd := exec.Command("ssh", sshArgs...)
+	conn := &sshConn{cmd: cmd, host: u.Host}
+	cmd.Stderr = &conn.stderr
+	var err error
+	if conn.stdin, err = cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if conn.stdout, err = cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run ssh: %v", err)
+	}
+	return conn, nil
+}
+
+// sshConn is a connection through the stdin and stdout of ssh
+type sshConn struct {
+	cmd    *exec.Cmd
+	host   string
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr lockedBuffer
+	// read is set once data arrived, an earlier end means ssh failed
+	read bool
+}
+
+// lockedBuffer collects what ssh prints on stderr while it runs
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.TrimSpace(b.buf.String())
+}
+
+func (c *sshConn) Read(p []byte) (int, error) {
+	n, err := c.stdout.Read(p)
+	if n > 0 {
+		c.read = true
+	}
+	if err != nil && !c.read {
+		// Give ssh the chance to report why it ended
+		c.cmd.Wait()
+		if message := c.stderr.String(); message != "" {
+			return n, fmt.Errorf("ssh %s: %s", c.host, message)
+		}
+	}
+	return n, err
+}
+
+func (c *sshConn) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+// CloseWrite signals the end of the request, like on a TCP connection
+func (c *sshConn) CloseWrite() error {
+	return c.stdin.Close()
+}
+
+func (c *sshConn) Close() error {
+	c.stdin.Close()
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+	return nil
+}
+
+// sshAddr is the address of a ssh connection
+type sshAddr string
+
+func (a sshAddr) Network() string { return "ssh" }
+func (a sshAddr) String() string  { return string(a) }
+
+func (c *sshConn) LocalAddr() net.Addr                { return sshAddr("localhost") }
+func (c *sshConn) RemoteAddr() net.Addr               { return sshAddr(c.host) }
+func (c *sshConn) SetDeadline(t time.Time) error      { return nil }
+func (c *sshConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *sshConn) SetWriteDeadline(t time.Time) error { return nil }
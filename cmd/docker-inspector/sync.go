@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// staleFiles returns the paths in the output directory that are not part
+// of the extracted files (anymore). Of stale directories only the
+// directory itself is returned, not its contents.
+func staleFiles(files []FileInfo, args Args) ([]string, error) {
+	keep := map[string]bool{}
+	for _, file := range files {
+		destPath := getDestPath(file.Path, args.StripComponents)
+		// The parents of an extracted file are kept even when they were
+		// not matched themselves
+		for destPath != "" && destPath != "/" && !keep[destPath] {
+			keep[destPath] = true
+			destPath = path.Dir(destPath)
+		}
+	}
+
+	var stale []string
+	err := filepath.WalkDir(args.OutputDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(args.OutputDir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		if keep["/"+filepath.ToSlash(rel)] {
+			return nil
+		}
+		stale = append(stale, p)
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return stale, err
+}
+
+// syncOutputDir deletes the files of the output directory that do not
+// exist in the image, so it mirrors the extracted files (--sync)
+func syncOutputDir(files []FileInfo, args Args) error {
+	stale, err := staleFiles(files, args)
+	if err != nil {
+		return fmt.Errorf("failed to scan output directory: %v", err)
+	}
+	failed := 0
+	for _, p := range stale {
+		if err := os.RemoveAll(p); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Could not delete %s: %v\n", p, err)
+			failed++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Deleted %d files no longer in the image, %d failed\n", len(stale)-failed, failed)
+	return nil
+}
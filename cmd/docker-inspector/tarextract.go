@@ -0,0 +1,385 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// tarListingRecord marks the entry of the inspector's tar stream that holds
+// the JSON listing instead of a file of the image
+const tarListingRecord = "DOCKERINSPECTOR.listing"
+
+// extractVia resolves the --extract-via channel. Automatically the tar
+// stream is used when the daemon is not local, as a bind mount of the
+// output directory would end up on the daemon's host.
+func extractVia(choice string) (string, error) {
+	switch choice {
+	case "tar":
+		return choice, nil
+	case "mount":
+		// A remote daemon would mount the directory of its own host
+		if engine, err := containerEngine(); err == nil && engine.isRemote() {
+			return "", fmt.Errorf("--extract-via mount can not be used with the remote daemon %s, use --extract-via tar (the default for remote daemons)", engine.endpoint())
+		}
+		return choice, nil
+	case "", "auto":
+		if engine, err := containerEngine(); err == nil && engine.isRemote() {
+			return "tar", nil
+		}
+		return "mount", nil
+	default:
+		return "", fmt.Errorf("--extract-via must be auto, mount or tar, not %q", choice)
+	}
+}
+
+// extractTarStream unpacks the tar stream of the inspector into outputDir
+// and writes the listing it ends with to w
+func extractTarStream(r io.Reader, outputDir string, args Args, w io.Writer) error {
+	listing := false
+	progress := &tarProgress{enabled: args.Progress, started: time.Now()}
+	progress.printed = progress.started
+	// Directory metadata is set after their contents were written
+	var dirs []*tar.Header
+	root, err := filepath.EvalSymlinks(outputDir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve output directory: %v", err)
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar stream: %v", err)
+		}
+		if header.PAXRecords[tarListingRecord] != "" {
+			if _, err := io.Copy(w, tr); err != nil {
+				return fmt.Errorf("failed to read listing: %v", err)
+			}
+			listing = true
+			continue
+		}
+		dest := filepath.Join(outputDir, filepath.FromSlash(header.Name))
+		isDir := header.Typeflag == tar.TypeDir
+		if !isDir {
+			progress.start(header.Name)
+		}
+		if err := checkTarEntry(header, root, dest, args); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Skipping %s: %v\n", header.Name, err)
+			if !isDir {
+				progress.failed++
+			}
+			continue
+		}
+		written, err := extractTarEntry(tr, header, dest, outputDir, args)
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Warning: Failed to extract %s: %v\n", header.Name, err)
+			progress.failed++
+		case isDir:
+			dirs = append(dirs, header)
+		case written:
+			progress.copied++
+			progress.bytes += header.Size
+		default:
+			progress.skipped++
+		}
+	}
+	// Deeper directories first, so a read-only parent is finished last
+	for i := len(dirs) - 1; i >= 0; i-- {
+		setTarMetadata(dirs[i], filepath.Join(outputDir, filepath.FromSlash(dirs[i].Name)), args)
+	}
+	progress.summary()
+	if !listing {
+		return fmt.Errorf("tar stream ended without listing")
+	}
+	return nil
+}
+
+// checkTarEntry makes sure an entry is written inside of the output
+// directory, neither by its name nor through symlinks extracted before
+func checkTarEntry(header *tar.Header, root, dest string, args Args) error {
+	if !filepath.IsLocal(filepath.FromSlash(strings.TrimSuffix(header.Name, "/"))) {
+		return fmt.Errorf("name is not local to the output directory")
+	}
+	if header.Typeflag == tar.TypeLink && !filepath.IsLocal(filepath.FromSlash(header.Linkname)) {
+		return fmt.Errorf("hardlink target %s is not local to the output directory", header.Linkname)
+	}
+	// Existing files are replaced, so only directories are checked
+	// including their last component
+	checkPath := filepath.Dir(dest)
+	if header.Typeflag == tar.TypeDir {
+		checkPath = dest
+	}
+	if !args.FollowUnsafe && !staysInside(root, checkPath) {
+		return fmt.Errorf("a symlink leads outside of the output directory (see --follow-unsafe-symlinks)")
+	}
+	// The hardlink target is resolved through the directories extracted
+	// before as well, a symlinked one would link files of the host
+	if header.Typeflag == tar.TypeLink && !args.FollowUnsafe {
+		target := filepath.Join(root, filepath.FromSlash(header.Linkname))
+		if !staysInside(root, filepath.Dir(target)) {
+			return fmt.Errorf("hardlink target %s leads outside of the output directory through a symlink (see --follow-unsafe-symlinks)", header.Linkname)
+		}
+	}
+	return nil
+}
+
+// staysInside reports whether path is inside root after resolving the
+// symlinks of the part of it that exists already. root must be resolved.
+func staysInside(root, path string) bool {
+	existing, rest := path, ""
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+	// Broken symlinks can not be checked and are unsafe as well
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, filepath.Join(resolved, rest))
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// extractTarEntry creates dest from a tar entry with the attributes that
+// should be preserved, like the inspector does when copying into a mount.
+// It reports whether dest was written.
+func extractTarEntry(tr *tar.Reader, header *tar.Header, dest, outputDir string, args Args) (bool, error) {
+	if header.Typeflag == tar.TypeDir {
+		return true, os.MkdirAll(dest, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return false, fmt.Errorf("failed to create destination directory: %v", err)
+	}
+	switch header.Typeflag {
+	case tar.TypeSymlink, tar.TypeLink, tar.TypeReg:
+		write, err := checkTarOverwrite(header, dest, args.Overwrite)
+		if err != nil || !write {
+			return false, err
+		}
+	}
+
+	switch header.Typeflag {
+	case tar.TypeSymlink:
+		if err := os.Symlink(header.Linkname, dest); err != nil {
+			return false, err
+		}
+	case tar.TypeLink:
+		target := filepath.Join(outputDir, filepath.FromSlash(header.Linkname))
+		return true, os.Link(target, dest)
+	case tar.TypeReg:
+		f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return false, fmt.Errorf("failed to create destination file: %v", err)
+		}
+		if err := copyHoles(f, tr); err != nil {
+			f.Close()
+			return false, fmt.Errorf("failed to copy file contents: %v", err)
+		}
+		if err := f.Close(); err != nil {
+			return false, err
+		}
+	default:
+		// Device nodes and fifos are not copied through the mount either
+		return false, nil
+	}
+
+	setTarMetadata(header, dest, args)
+	return true, nil
+}
+
+// holeBlock is the size of the zero runs copyHoles leaves as holes
+const holeBlock = 4096
+
+// copyHoles copies r into the new file f and seeks over blocks of zeros
+// instead of writing them. The tar stream fills the holes of sparse files
+// with zeros, this recreates them.
+func copyHoles(f *os.File, r io.Reader) error {
+	buf := make([]byte, 64*1024)
+	var size int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		for start := 0; start < n; {
+			end := min(start+holeBlock, n)
+			hole := isZeros(buf[start:end])
+			// Adjacent blocks of the same kind are handled at once
+			for end < n && isZeros(buf[end:min(end+holeBlock, n)]) == hole {
+				end = min(end+holeBlock, n)
+			}
+			var writeErr error
+			if hole {
+				_, writeErr = f.Seek(int64(end-start), io.SeekCurrent)
+			} else {
+				_, writeErr = f.Write(buf[start:end])
+			}
+			if writeErr != nil {
+				return writeErr
+			}
+			start = end
+		}
+		size += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// A trailing hole is created by extending the file
+	return f.Truncate(size)
+}
+
+// isZeros reports whether b only holds zero bytes
+func isZeros(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// setTarMetadata applies the attributes of a tar entry to dest as far as
+// they should be preserved
+func setTarMetadata(header *tar.Header, dest string, args Args) {
+	isSymlink := header.Typeflag == tar.TypeSymlink
+	if args.PreservePermissions && !isSymlink {
+		if err := os.Chmod(dest, header.FileInfo().Mode()); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Could not preserve mode of %s: %v\n", dest, err)
+		}
+	}
+	// On macOS ownership is fixed with sudo afterwards
+	if args.PreserveOwner && !needsOwnershipFix(args) {
+		if err := os.Lchown(dest, args.owners.uid(header.Uid), args.owners.gid(header.Gid)); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Could not preserve ownership of %s: %v\n", dest, err)
+		}
+	}
+	if args.PreserveXattrs && !isSymlink {
+		for key, value := range header.PAXRecords {
+			name, ok := strings.CutPrefix(key, "SCHILY.xattr.")
+			if !ok {
+				continue
+			}
+			if err := setXattr(dest, name, []byte(value)); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: Could not preserve xattr %s of %s: %v\n", name, dest, err)
+			}
+		}
+	}
+	if args.PreserveTimes && !isSymlink {
+		if err := os.Chtimes(dest, header.ModTime, header.ModTime); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Could not preserve modification time of %s: %v\n", dest, err)
+		}
+	}
+}
+
+// runTarExtraction runs the inspector container and unpacks its tar stream
+// into the --output-dir, the listing is written to w
+func runTarExtraction(spec containerSpec, args Args, stderr, w io.Writer) error {
+	outputDir, err := filepath.Abs(args.OutputDir)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path for output dir: %v", err)
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := runContainer(spec, pw, stderr)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	extractErr := extractTarStream(pr, outputDir, args, w)
+	// Drain the rest, so the inspector does not block on a full pipe
+	io.Copy(io.Discard, pr)
+	if err := <-done; err != nil {
+		return err
+	}
+	return extractErr
+}
+
+// checkTarOverwrite decides by the --overwrite policy whether dest may be
+// written for a tar entry, like the inspector does when copying. As the
+// contents are only streamed, update compares the size and modification
+// time without hashing.
+func checkTarOverwrite(header *tar.Header, dest, policy string) (bool, error) {
+	existing, err := os.Lstat(dest)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	switch policy {
+	case "never":
+		return false, nil
+	case "update":
+		if !isTarOutdated(header, dest, existing) {
+			return false, nil
+		}
+	case "backup":
+		return true, os.Rename(dest, dest+"~")
+	}
+	return true, os.Remove(dest)
+}
+
+// isTarOutdated reports whether the extracted dest differs from the entry
+func isTarOutdated(header *tar.Header, dest string, existing os.FileInfo) bool {
+	switch header.Typeflag {
+	case tar.TypeSymlink:
+		target, err := os.Readlink(dest)
+		return err != nil || target != header.Linkname
+	case tar.TypeReg:
+		return !existing.Mode().IsRegular() || existing.Size() != header.Size ||
+			!existing.ModTime().Equal(header.ModTime)
+	}
+	return true
+}
+
+// progressInterval is the time between two --progress lines
+const progressInterval = 2 * time.Second
+
+// tarProgress counts the files unpacked from the tar stream and reports
+// them on stderr like the inspector does when copying into a mount. The
+// total is not known before the listing at the end of the stream.
+type tarProgress struct {
+	// enabled prints periodic progress lines, the summary is always printed
+	enabled bool
+	done    int
+	copied  int
+	skipped int
+	failed  int
+	bytes   int64
+	started time.Time
+	printed time.Time
+}
+
+// start is called before extracting name and prints a progress line when
+// the last one is old enough
+func (p *tarProgress) start(name string) {
+	p.done++
+	if !p.enabled || time.Since(p.printed) < progressInterval {
+		return
+	}
+	p.printed = time.Now()
+	fmt.Fprintf(os.Stderr, "Extracting file %d, %d bytes copied: %s\n", p.done, p.bytes, name)
+}
+
+func (p *tarProgress) summary() {
+	fmt.Fprintf(os.Stderr, "Extracted %d files (%d bytes) in %s, %d skipped, %d failed\n",
+		p.copied, p.bytes, time.Since(p.started).Round(time.Millisecond), p.skipped, p.failed)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are available in --format-template, modelled after the
+// functions docker inspect --format offers
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"split": strings.Split,
+}
+
+// parseFormatTemplate compiles a per file output template. Like docker,
+// the escape sequences \t and \n are expanded, so they can be used in
+// single quoted shell arguments.
+func parseFormatTemplate(text string) (*template.Template, error) {
+	text = strings.NewReplacer(`\t`, "\t", `\n`, "\n").Replace(text)
+	return template.New("format").Funcs(templateFuncs).Parse(text)
+}
+
+// printTemplate executes the template for every file, each on its own line
+func printTemplate(w io.Writer, files []FileInfo, tmpl *template.Template) error {
+	for _, file := range files {
+		if err := tmpl.Execute(w, file); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
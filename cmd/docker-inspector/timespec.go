@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeSpec is a point in time given either as timestamp or as age relative
+// to now (e.g. 2024-01-31, 2024-01-31T12:00:00Z, 90m, 12h, 30d, 2w)
+type TimeSpec struct {
+	time.Time
+}
+
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler for go-arg
+func (t *TimeSpec) UnmarshalText(b []byte) error {
+	text := strings.TrimSpace(string(b))
+	for _, layout := range timeLayouts {
+		if parsed, err := time.ParseInLocation(layout, text, time.Local); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	age, err := parseAge(text)
+	if err != nil {
+		return fmt.Errorf("invalid time %q (use a date, a timestamp or an age like 30d)", text)
+	}
+	t.Time = time.Now().Add(-age)
+	return nil
+}
+
+// parseAge parses a duration that additionally supports days (d) and weeks (w)
+func parseAge(text string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(text, suffix) {
+			value, err := strconv.ParseFloat(strings.TrimSuffix(text, suffix), 64)
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(value * float64(unit)), nil
+		}
+	}
+	return time.ParseDuration(text)
+}
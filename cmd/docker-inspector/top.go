@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// largestFiles returns the n largest regular files, biggest first, and the
+// total size of all listed files. Hardlinks are counted once.
+func largestFiles(files []FileInfo, n int) ([]FileInfo, int64) {
+	var total int64
+	var regular []FileInfo
+	for _, file := range files {
+		if file.HardlinkTo != "" {
+			continue
+		}
+		total += file.Size
+		if file.Type == "file" {
+			regular = append(regular, file)
+		}
+	}
+	sort.SliceStable(regular, func(i, j int) bool {
+		return regular[i].Size > regular[j].Size
+	})
+	if len(regular) > n {
+		regular = regular[:n]
+	}
+	return regular, total
+}
+
+// printTop writes the report of the largest files with their share of the
+// total size and the cumulative share
+func printTop(out io.Writer, top []FileInfo, total int64, args Args) {
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+	fmt.Fprintln(w, "Size\tShare\tCumulative\tPath")
+	var cumulative int64
+	for _, file := range top {
+		cumulative += file.Size
+		fmt.Fprintf(w, "%s\t%.1f%%\t%.1f%%\t%s\n",
+			formatBytes(file.Size, args.Human),
+			percentage(file.Size, total),
+			percentage(cumulative, total),
+			file.Path)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "\nTop %d files: %s of %s total\n", len(top),
+		formatTotal(cumulative, args.Human), formatTotal(total, args.Human))
+}
+
+func percentage(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	treemapWidth  = 1200
+	treemapHeight = 800
+	// treemapDepth limits the nesting, deeper directories are shown as one box
+	treemapDepth = 6
+	// treemapHeader is the space for the label of a directory
+	treemapHeader = 14
+)
+
+// treemapColors are used for the nesting levels
+var treemapColors = []string{"#4e79a7", "#f28e2b", "#e15759", "#76b7b2", "#59a14f", "#edc948", "#b07aa1"}
+
+type rect struct {
+	x, y, w, h float64
+}
+
+// writeTreemap writes the directory sizes as SVG treemap when the file name
+// ends with .svg and as hierarchical JSON for d3 and similar viewers otherwise
+func writeTreemap(treemapPath string, files []FileInfo) error {
+	tree := buildSizeTree(files)
+	f, err := os.Create(treemapPath)
+	if err != nil {
+		return fmt.Errorf("failed to create treemap: %v", err)
+	}
+	if strings.HasSuffix(strings.ToLower(treemapPath), ".svg") {
+		err = printTreemapSVG(f, tree)
+	} else {
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(tree)
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write treemap: %v", err)
+	}
+	return f.Close()
+}
+
+func printTreemapSVG(w io.Writer, tree *sizeNode) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="11">`+"\n",
+		treemapWidth, treemapHeight)
+	drawTreemapNode(&b, tree, rect{0, 0, treemapWidth, treemapHeight}, 0)
+	b.WriteString("</svg>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func drawTreemapNode(b *strings.Builder, node *sizeNode, r rect, depth int) {
+	if r.w < 1 || r.h < 1 {
+		return
+	}
+	fmt.Fprintf(b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s" stroke="#fff"><title>%s (%s)</title></rect>`+"\n",
+		r.x, r.y, r.w, r.h, treemapColors[depth%len(treemapColors)],
+		html.EscapeString(node.Path), humanSize(node.Size))
+
+	label := r.w > 40 && r.h > treemapHeader
+	if label {
+		// Labels are cut to the width of the box (at roughly 6.5 pixels per character)
+		text := []rune(node.Name + " " + humanSize(node.Size))
+		if maxLen := int((r.w - 6) / 6.5); len(text) > maxLen {
+			text = text[:maxLen]
+		}
+		fmt.Fprintf(b, `<text x="%.1f" y="%.1f" fill="#fff">%s</text>`+"\n",
+			r.x+3, r.y+11, html.EscapeString(string(text)))
+	}
+	if len(node.Children) == 0 || depth >= treemapDepth || node.Size == 0 {
+		return
+	}
+
+	// Children are drawn below the label of the directory
+	inner := rect{r.x + 1, r.y + 1, r.w - 2, r.h - 2}
+	if label {
+		inner.y += treemapHeader - 1
+		inner.h -= treemapHeader - 1
+	}
+	if inner.w < 2 || inner.h < 2 {
+		return
+	}
+	var children []*sizeNode
+	for _, c := range node.Children {
+		if c.Size > 0 {
+			children = append(children, c)
+		}
+	}
+	squarify(children, node.Size, inner, func(c *sizeNode, cr rect) {
+		drawTreemapNode(b, c, cr, depth+1)
+	})
+}
+
+// squarify lays out nodes sorted by size (biggest first) in rows that keep
+// the boxes close to squares
+func squarify(nodes []*sizeNode, total int64, r rect, place func(*sizeNode, rect)) {
+	if total <= 0 {
+		return
+	}
+	scale := r.w * r.h / float64(total)
+	for len(nodes) > 0 {
+		short := min(r.w, r.h)
+		n := 1
+		for n < len(nodes) && worstRatio(nodes[:n+1], short, scale) <= worstRatio(nodes[:n], short, scale) {
+			n++
+		}
+
+		var rowSize int64
+		for _, node := range nodes[:n] {
+			rowSize += node.Size
+		}
+		thickness := float64(rowSize) * scale / short
+		offset := 0.0
+		for _, node := range nodes[:n] {
+			length := float64(node.Size) * scale / thickness
+			if r.w >= r.h {
+				place(node, rect{r.x, r.y + offset, thickness, length})
+			} else {
+				place(node, rect{r.x + offset, r.y, length, thickness})
+			}
+			offset += length
+		}
+		if r.w >= r.h {
+			r.x += thickness
+			r.w -= thickness
+		} else {
+			r.y += thickness
+			r.h -= thickness
+		}
+		nodes = nodes[n:]
+	}
+}
+
+// worstRatio is the largest aspect ratio of a row of boxes along a side
+func worstRatio(row []*sizeNode, side, scale float64) float64 {
+	var sum, largest, smallest float64
+	for i, node := range row {
+		area := float64(node.Size) * scale
+		sum += area
+		if i == 0 || area > largest {
+			largest = area
+		}
+		if i == 0 || area < smallest {
+			smallest = area
+		}
+	}
+	return max(side*side*largest/(sum*sum), sum*sum/(side*side*smallest))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// verifiesExtraction reports whether extracted files are verified, which
+// is the case when checksums were calculated inside the container
+func verifiesExtraction(args Args) bool {
+	return args.OutputDir != "" && (args.MD5 || args.SHA256)
+}
+
+// existingOutput returns the files in the --output-dir before the
+// extraction when the --overwrite policy can keep them, never and update
+func existingOutput(args Args) map[string]os.FileInfo {
+	if args.Overwrite != "never" && args.Overwrite != "update" {
+		return nil
+	}
+	existing := map[string]os.FileInfo{}
+	filepath.WalkDir(args.OutputDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			existing[path] = info
+		}
+		return nil
+	})
+	return existing
+}
+
+// isKept reports whether the file existing before the extraction was left
+// untouched by the --overwrite policy, update keeps files with the size and
+// modification time of the listed file
+func isKept(file FileInfo, existing os.FileInfo, policy string) bool {
+	if policy == "never" {
+		return true
+	}
+	return existing.Mode().IsRegular() && existing.Size() == file.Size &&
+		(file.ModTime == nil || existing.ModTime().Equal(*file.ModTime))
+}
+
+// verifyExtraction compares the checksums of the extracted files with the
+// ones calculated inside the container and returns the number of mismatches.
+// Files the --overwrite policy kept from before the extraction (existing)
+// are skipped, their contents were not written.
+func verifyExtraction(files []FileInfo, existing map[string]os.FileInfo, args Args) int {
+	verified, mismatches, kept := 0, 0, 0
+	for _, file := range files {
+		expected, newHash := file.SHA256, sha256.New
+		if expected == "" {
+			expected, newHash = file.MD5, md5.New
+		}
+		if expected == "" || file.HashSkipped || strings.HasPrefix(expected, "error:") {
+			continue
+		}
+		destPath := getDestPath(file.Path, args.StripComponents)
+		if destPath == "" {
+			continue
+		}
+		dest := filepath.Join(args.OutputDir, destPath)
+		if info, ok := existing[dest]; ok && isKept(file, info, args.Overwrite) {
+			kept++
+			continue
+		}
+		actual, err := fileDigest(dest, newHash())
+		verified++
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Cannot verify %s: %v\n", dest, err)
+			mismatches++
+		} else if actual != expected {
+			fmt.Fprintf(os.Stderr, "Error: Checksum mismatch for %s: expected %s, got %s\n", dest, expected, actual)
+			mismatches++
+		}
+	}
+	if kept > 0 {
+		fmt.Fprintf(os.Stderr, "Verified %d extracted files, %d mismatches, %d kept files skipped\n", verified, mismatches, kept)
+	} else {
+		fmt.Fprintf(os.Stderr, "Verified %d extracted files, %d mismatches\n", verified, mismatches)
+	}
+	return mismatches
+}
+
+// fileDigest returns the hex encoded digest of the file
+func fileDigest(path string, h hash.Hash) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
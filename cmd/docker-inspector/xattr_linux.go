@@ -0,0 +1,8 @@
+package main
+
+import "syscall"
+
+// setXattr sets an extended attribute of an extracted file
+func setXattr(path, name string, value []byte) error {
+	return syscall.Setxattr(path, name, value, 0)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// setXattr is only implemented for Linux hosts
+func setXattr(path, name string, value []byte) error {
+	return fmt.Errorf("extended attributes are not supported on this platform")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// writeZipArchive converts the tar stream of the inspector into a zip
+// archive written to the --zip file (- for stdout)
+func writeZipArchive(args Args) error {
+	var f *os.File
+	if args.Zip == "-" {
+		f = os.Stdout
+	} else {
+		var err error
+		if f, err = os.Create(args.Zip); err != nil {
+			return fmt.Errorf("failed to create zip file: %v", err)
+		}
+	}
+
+	tarArgs := args
+	tarArgs.Tar = "-"
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(streamInspector(args.Image1, tarArgs, pw))
+	}()
+
+	err := convertTarToZip(pr, f)
+	// Drain the rest, so the inspector does not block on a full pipe
+	io.Copy(io.Discard, pr)
+	if f == os.Stdout {
+		return err
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(args.Zip)
+	}
+	return err
+}
+
+// convertTarToZip writes the entries of the tar stream r into a zip archive.
+// Modes and modification times are kept, symlinks are stored the way Info-ZIP
+// does with the target as content. Device nodes and fifos are skipped.
+func convertTarToZip(r io.Reader, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar stream: %v", err)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir, tar.TypeReg, tar.TypeSymlink:
+		default:
+			fmt.Fprintf(os.Stderr, "Warning: Skipping %s, zip archives can not store it\n", header.Name)
+			continue
+		}
+
+		zipHeader, err := zip.FileInfoHeader(header.FileInfo())
+		if err != nil {
+			return fmt.Errorf("failed to create zip header for %s: %v", header.Name, err)
+		}
+		zipHeader.Name = header.Name
+		zipHeader.Modified = header.ModTime
+		// FileInfoHeader stores everything uncompressed
+		if header.Typeflag == tar.TypeReg {
+			zipHeader.Method = zip.Deflate
+		}
+		entry, err := zw.CreateHeader(zipHeader)
+		if err != nil {
+			return fmt.Errorf("failed to write zip header for %s: %v", header.Name, err)
+		}
+		switch header.Typeflag {
+		case tar.TypeSymlink:
+			_, err = io.WriteString(entry, header.Linkname)
+		case tar.TypeReg:
+			_, err = io.Copy(entry, tr)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write %s to zip: %v", header.Name, err)
+		}
+	}
+	return zw.Close()
+}
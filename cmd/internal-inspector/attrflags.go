@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// fsIocGetflags is FS_IOC_GETFLAGS from linux/fs.h
+const fsIocGetflags = 0x80086601
+
+// attrFlagLetters maps inode flags to the letters used by lsattr
+var attrFlagLetters = []struct {
+	flag   uint32
+	letter byte
+}{
+	{0x00000001, 's'}, // secure deletion
+	{0x00000002, 'u'}, // undelete
+	{0x00000008, 'S'}, // synchronous updates
+	{0x00010000, 'D'}, // synchronous directory updates
+	{0x00000010, 'i'}, // immutable
+	{0x00000020, 'a'}, // append only
+	{0x00000040, 'd'}, // no dump
+	{0x00000080, 'A'}, // no atime updates
+	{0x00000004, 'c'}, // compressed
+	{0x00000800, 'E'}, // encrypted
+	{0x00004000, 'j'}, // data journaling
+	{0x00001000, 'I'}, // indexed directory
+	{0x00008000, 't'}, // no tail merging
+	{0x00020000, 'T'}, // top of directory hierarchy
+	{0x00080000, 'e'}, // extents
+	{0x00800000, 'C'}, // no copy on write
+	{0x02000000, 'x'}, // direct access
+	{0x40000000, 'F'}, // casefolded
+	{0x10000000, 'N'}, // inline data
+	{0x20000000, 'P'}, // project hierarchy
+	{0x00100000, 'V'}, // verity
+}
+
+// getAttrFlags returns the ext2/ext4 style inode flags of path as lsattr
+// letters (e.g. "ia" for immutable and append only)
+func getAttrFlags(path string) (string, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var flags uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocGetflags,
+		uintptr(unsafe.Pointer(&flags))); errno != 0 {
+		return "", errno
+	}
+
+	var letters []byte
+	for _, attr := range attrFlagLetters {
+		if flags&attr.flag != 0 {
+			letters = append(letters, attr.letter)
+		}
+	}
+	return string(letters), nil
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/oderwat/docker-inspector/internal/xattr"
+)
+
+// getCapabilities returns the file capabilities of path in the textual
+// form used by getcap (e.g. "cap_net_bind_service=ep") or "" if none are set
+func getCapabilities(path string) (string, error) {
+	value, err := getXattr(path, "security.capability")
+	if err != nil || len(value) == 0 {
+		return "", err
+	}
+	return xattr.DecodeCapabilities(value)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"io"
+	"os"
+	"strings"
+)
+
+// ElfInfo describes an ELF binary
+type ElfInfo struct {
+	Machine     string `json:"machine"`
+	Class       int    `json:"class"`
+	Type        string `json:"type"`
+	Linkage     string `json:"linkage"`
+	Interpreter string `json:"interpreter,omitempty"`
+	Stripped    bool   `json:"stripped"`
+}
+
+// getElfInfo parses the ELF headers of path and returns nil if the file
+// is not an ELF binary
+func getElfInfo(path string) (*ElfInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil || !bytes.Equal(magic, []byte(elf.ELFMAG)) {
+		return nil, nil
+	}
+
+	ef, err := elf.NewFile(f)
+	if err != nil {
+		return nil, err
+	}
+	defer ef.Close()
+
+	info := &ElfInfo{
+		Machine: strings.ToLower(strings.TrimPrefix(ef.Machine.String(), "EM_")),
+		Type:    strings.ToLower(strings.TrimPrefix(ef.Type.String(), "ET_")),
+	}
+	if ef.Class == elf.ELFCLASS64 {
+		info.Class = 64
+	} else {
+		info.Class = 32
+	}
+
+	for _, prog := range ef.Progs {
+		if prog.Type == elf.PT_INTERP {
+			interp := make([]byte, prog.Filesz)
+			if _, err := prog.ReadAt(interp, 0); err == nil {
+				info.Interpreter = strings.TrimRight(string(interp), "\x00")
+			}
+		}
+	}
+
+	// Shared libraries and static-pie binaries have a dynamic section
+	// but no interpreter
+	switch {
+	case info.Interpreter != "":
+		info.Linkage = "dynamic"
+	case ef.Section(".dynamic") == nil:
+		info.Linkage = "static"
+	default:
+		info.Linkage = "shared"
+	}
+
+	info.Stripped = ef.Section(".symtab") == nil
+	return info, nil
+}
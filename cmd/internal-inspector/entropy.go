@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	entropySamples    = 8
+	entropySampleSize = 64 * 1024
+)
+
+// calculateEntropy returns the Shannon entropy in bits per byte (0-8) of
+// the file. Large files are sampled at evenly spaced offsets.
+func calculateEntropy(path string, size int64) (float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var counts [256]int64
+	var total int64
+	buf := make([]byte, entropySampleSize)
+
+	samples := int64(entropySamples)
+	if size <= samples*entropySampleSize {
+		samples = 1
+	}
+	for i := int64(0); i < samples; i++ {
+		offset := int64(0)
+		if samples > 1 {
+			offset = i * (size - entropySampleSize) / (samples - 1)
+		}
+		reader := io.Reader(io.NewSectionReader(f, offset, entropySampleSize))
+		if samples == 1 {
+			reader = f
+		}
+		for {
+			n, err := reader.Read(buf)
+			for _, b := range buf[:n] {
+				counts[b]++
+			}
+			total += int64(n)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if total == 0 {
+		return 0, nil
+	}
+	entropy := 0.0
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / float64(total)
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return math.Round(entropy*1000) / 1000, nil
+}
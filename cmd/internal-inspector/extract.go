@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/oderwat/docker-inspector/internal/filter"
+)
+
+// extractFiles copies the matching files to the --output-dir
+func extractFiles(files []FileInfo, args Args) {
+	progress := newExtractProgress(files, args)
+	// Directory metadata is set after their contents were written
+	var dirs []extractedDir
+	// extracted maps the paths of copied files to their destination
+	extracted := make(map[string]string)
+	root, err := filepath.EvalSymlinks(args.OutputDir)
+	if err != nil {
+		root = filepath.Clean(args.OutputDir)
+	}
+	for _, file := range files {
+		destPath := getDestPath(file.Path, args.StripComponents)
+		if destPath == "" {
+			continue // Skip if all components were stripped
+		}
+		if !isExtracted(file, args) {
+			continue
+		}
+
+		fullDestPath := filepath.Join(args.OutputDir, destPath)
+
+		// Symlinks extracted before (or found in the output directory) must
+		// not redirect writes to outside of it. Existing files are replaced,
+		// so only directories are checked including their last component.
+		checkPath := filepath.Dir(fullDestPath)
+		if file.IsDir {
+			checkPath = fullDestPath
+		}
+		if !args.FollowUnsafe && !staysInside(root, checkPath) {
+			fmt.Fprintf(os.Stderr, "Warning: Skipping %s, a symlink leads outside of the output directory (see --follow-unsafe-symlinks)\n", fullDestPath)
+			if !file.IsDir {
+				progress.failed++
+			}
+			continue
+		}
+
+		if file.IsDir {
+			if err := os.MkdirAll(fullDestPath, 0755); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: Failed to create %s: %v\n", fullDestPath, err)
+				continue
+			}
+			dirs = append(dirs, extractedDir{file: file, dest: fullDestPath})
+			continue
+		}
+
+		progress.start(file.Path)
+		// Files known to be unchanged since the last extraction are
+		// neither compared nor copied
+		if args.unchanged[file.Path] {
+			extracted[file.Path] = fullDestPath
+			progress.skipped++
+			continue
+		}
+		info, err := os.Lstat(file.Path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Cannot stat %s: %v\n", file.Path, err)
+			progress.failed++
+			continue
+		}
+
+		write, err := checkOverwrite(file.Path, info, fullDestPath, args.Overwrite)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Cannot replace %s: %v\n", fullDestPath, err)
+			progress.failed++
+			continue
+		}
+		if !write {
+			extracted[file.Path] = fullDestPath
+			progress.skipped++
+			continue
+		}
+
+		// Hardlinks are linked to the first extracted file of their group,
+		// if that fails they become independent copies
+		if target, ok := extracted[file.HardlinkTo]; ok {
+			err := linkFile(target, fullDestPath)
+			if err == nil {
+				progress.copied++
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "Warning: Could not link %s to %s, copying it: %v\n", fullDestPath, target, err)
+		}
+
+		if err := copyFile(file.Path, fullDestPath, info,
+			args.PreservePermissions,
+			args.PreserveOwner,
+			args.PreserveTimes,
+			args.owners); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to copy %s: %v\n", file.Path, err)
+			progress.failed++
+			continue
+		}
+		extracted[file.Path] = fullDestPath
+		progress.copied++
+		progress.bytes += info.Size()
+
+		if args.PreserveXattrs && file.SymlinkTo == "" {
+			if err := setXattrs(fullDestPath, file.Xattrs); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: Could not preserve xattrs of %s: %v\n", fullDestPath, err)
+			}
+		}
+	}
+
+	// Deeper directories first, so a read-only parent is finished last
+	for i := len(dirs) - 1; i >= 0; i-- {
+		setDirMetadata(dirs[i], args)
+	}
+	progress.summary()
+}
+
+// staysInside reports whether path is inside root after resolving the
+// symlinks of the part of it that exists already. root must be resolved.
+func staysInside(root, path string) bool {
+	existing, rest := path, ""
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+	// Broken symlinks can not be checked and are unsafe as well
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return false
+	}
+	return isBelow(filepath.Join(resolved, rest), root)
+}
+
+// isExtracted reports whether a listed file is selected for extraction by
+// --extract-glob and --extract-exclude, which do not affect the listing
+func isExtracted(file FileInfo, args Args) bool {
+	if len(args.ExtractGlobs) > 0 {
+		if match, _ := filter.MatchesAny(args.ExtractGlobs, file.Path); !match {
+			return false
+		}
+	}
+	excluded, _ := filter.MatchesAny(args.ExtractExcludes, file.Path)
+	return !excluded
+}
+
+// progressInterval is the time between two --progress lines
+const progressInterval = 2 * time.Second
+
+// extractProgress counts the extracted files and reports them on stderr
+type extractProgress struct {
+	// enabled prints periodic progress lines, the summary is always printed
+	enabled bool
+	total   int
+	done    int
+	copied  int
+	skipped int
+	failed  int
+	bytes   int64
+	started time.Time
+	printed time.Time
+}
+
+// newExtractProgress counts the files to extract, directories are not
+// counted as they are only created
+func newExtractProgress(files []FileInfo, args Args) *extractProgress {
+	p := &extractProgress{enabled: args.Progress, started: time.Now()}
+	p.printed = p.started
+	for _, file := range files {
+		if !file.IsDir && getDestPath(file.Path, args.StripComponents) != "" && isExtracted(file, args) {
+			p.total++
+		}
+	}
+	return p
+}
+
+// start is called before extracting path and prints a progress line when
+// the last one is old enough
+func (p *extractProgress) start(path string) {
+	p.done++
+	if !p.enabled || time.Since(p.printed) < progressInterval {
+		return
+	}
+	p.printed = time.Now()
+	fmt.Fprintf(os.Stderr, "Extracting %d/%d files, %d bytes copied: %s\n", p.done, p.total, p.bytes, path)
+}
+
+func (p *extractProgress) summary() {
+	fmt.Fprintf(os.Stderr, "Extracted %d files (%d bytes) in %s, %d skipped, %d failed\n",
+		p.copied, p.bytes, time.Since(p.started).Round(time.Millisecond), p.skipped, p.failed)
+}
+
+// extractedDir is a directory created in the output directory
+type extractedDir struct {
+	file FileInfo
+	dest string
+}
+
+// setDirMetadata applies the mode, owner, xattrs and modification time of
+// an extracted directory as far as they should be preserved
+func setDirMetadata(dir extractedDir, args Args) {
+	info, err := os.Lstat(dir.file.Path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Cannot stat %s: %v\n", dir.file.Path, err)
+		return
+	}
+	if args.PreservePermissions {
+		if err := os.Chmod(dir.dest, info.Mode()); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Could not preserve mode of %s: %v\n", dir.dest, err)
+		}
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && args.PreserveOwner {
+		uid, gid := args.owners.uid(int(stat.Uid)), args.owners.gid(int(stat.Gid))
+		if err := os.Chown(dir.dest, uid, gid); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Could not preserve ownership of %s: %v\n", dir.dest, err)
+		}
+	}
+	if args.PreserveXattrs {
+		if err := setXattrs(dir.dest, dir.file.Xattrs); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Could not preserve xattrs of %s: %v\n", dir.dest, err)
+		}
+	}
+	if args.PreserveTimes {
+		if err := os.Chtimes(dir.dest, info.ModTime(), info.ModTime()); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Could not preserve modification time of %s: %v\n", dir.dest, err)
+		}
+	}
+}
+
+// linkFile creates dest as hardlink of the already extracted target
+func linkFile(target, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %v", err)
+	}
+	return os.Link(target, dest)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// contentSignatures maps magic bytes at the start of a file to a content type
+var contentSignatures = []struct {
+	offset int
+	magic  []byte
+	name   string
+}{
+	{0, []byte("\x7fELF"), "elf"},
+	{0, []byte("\x1f\x8b"), "gzip"},
+	{0, []byte("BZh"), "bzip2"},
+	{0, []byte("\xfd7zXZ\x00"), "xz"},
+	{0, []byte("\x28\xb5\x2f\xfd"), "zstd"},
+	{0, []byte("PK\x03\x04"), "zip"},
+	{0, []byte("7z\xbc\xaf\x27\x1c"), "7z"},
+	{257, []byte("ustar"), "tar"},
+	{0, []byte("\x89PNG\r\n\x1a\n"), "image/png"},
+	{0, []byte("\xff\xd8\xff"), "image/jpeg"},
+	{0, []byte("GIF8"), "image/gif"},
+	{0, []byte("%PDF-"), "pdf"},
+	{0, []byte("SQLite format 3\x00"), "sqlite"},
+	{0, []byte("\xca\xfe\xba\xbe"), "java-class"},
+	{0, []byte("\x00asm"), "wasm"},
+	{0, []byte("!<arch>\n"), "ar"},
+}
+
+// detectContentType sniffs the first bytes of a regular file and classifies
+// it as elf, script/<interpreter>, text, a known binary format or data
+func detectContentType(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return classifyContent(head[:n]), nil
+}
+
+func classifyContent(head []byte) string {
+	if len(head) == 0 {
+		return "empty"
+	}
+	for _, sig := range contentSignatures {
+		end := sig.offset + len(sig.magic)
+		if len(head) >= end && bytes.Equal(head[sig.offset:end], sig.magic) {
+			return sig.name
+		}
+	}
+	if bytes.HasPrefix(head, []byte("#!")) {
+		return "script/" + interpreterName(head)
+	}
+	if isText(head) {
+		return "text"
+	}
+	return "data"
+}
+
+// interpreterName extracts the interpreter from a shebang line, looking
+// through "/usr/bin/env" indirections
+func interpreterName(head []byte) string {
+	line := string(head[2:])
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "unknown"
+	}
+	name := path.Base(fields[0])
+	if name == "env" {
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "-") {
+				return path.Base(field)
+			}
+		}
+	}
+	return name
+}
+
+func isText(head []byte) bool {
+	// A partial multibyte sequence may be cut off at the end
+	for len(head) > 0 && !utf8.Valid(head) && len(head) > 512-utf8.UTFMax {
+		head = head[:len(head)-1]
+	}
+	if !utf8.Valid(head) {
+		return false
+	}
+	for _, b := range head {
+		if b < ' ' && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != '\v' && b != 0x1b {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesContentType reports whether contentType is filter or a subtype of
+// it, so "script" matches "script/sh" and "script/python3"
+func matchesContentType(contentType, filter string) bool {
+	return contentType == filter || strings.HasPrefix(contentType, filter+"/")
+}
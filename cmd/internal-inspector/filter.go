@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// matchesOwner checks the owner filters. Names are resolved through the
+// passwd and group files of the image.
+func matchesOwner(args Args, info fs.FileInfo) bool {
+	if args.UID == nil && args.GID == nil && args.User == "" && args.Group == "" {
+		return true
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	if args.UID != nil && stat.Uid != *args.UID {
+		return false
+	}
+	if args.GID != nil && stat.Gid != *args.GID {
+		return false
+	}
+	if args.User != "" && userNames[stat.Uid] != args.User {
+		return false
+	}
+	if args.Group != "" && groupNames[stat.Gid] != args.Group {
+		return false
+	}
+	return true
+}
+
+// permFilter implements find -perm: an exact match, "-" for all bits set
+// or "/" for any bit set
+type permFilter struct {
+	mode uint32
+	kind byte
+}
+
+// parsePermFilter parses an octal (e.g. -4000) or symbolic (e.g. /o+w)
+// permission predicate
+func parsePermFilter(spec string) (*permFilter, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	filter := &permFilter{kind: '='}
+	if spec[0] == '-' || spec[0] == '/' {
+		filter.kind = spec[0]
+		spec = spec[1:]
+	}
+	if mode, err := strconv.ParseUint(spec, 8, 32); err == nil {
+		filter.mode = uint32(mode) & 07777
+		return filter, nil
+	}
+	mode, err := parseSymbolicMode(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid permission %q: %v", spec, err)
+	}
+	filter.mode = mode
+	return filter, nil
+}
+
+// parseSymbolicMode parses modes like "u+s", "o+w" or "u=rwx,g+x" starting from 0
+func parseSymbolicMode(spec string) (uint32, error) {
+	var mode uint32
+	for _, clause := range strings.Split(spec, ",") {
+		opIdx := strings.IndexAny(clause, "+=")
+		if opIdx < 0 {
+			return 0, fmt.Errorf("missing + or = in %q", clause)
+		}
+		who := clause[:opIdx]
+		if who == "" || who == "a" {
+			who = "ugo"
+		}
+		var bits uint32
+		for _, perm := range clause[opIdx+1:] {
+			for _, w := range who {
+				shift, ok := map[rune]uint{'u': 6, 'g': 3, 'o': 0}[w]
+				if !ok {
+					return 0, fmt.Errorf("invalid who %q", w)
+				}
+				switch perm {
+				case 'r':
+					bits |= 4 << shift
+				case 'w':
+					bits |= 2 << shift
+				case 'x':
+					bits |= 1 << shift
+				case 's':
+					if w == 'u' {
+						bits |= 04000
+					} else if w == 'g' {
+						bits |= 02000
+					}
+				case 't':
+					bits |= 01000
+				default:
+					return 0, fmt.Errorf("invalid permission %q", perm)
+				}
+			}
+		}
+		mode |= bits
+	}
+	return mode, nil
+}
+
+// matches tests the permission bits of a file
+func (f *permFilter) matches(info fs.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	perm := stat.Mode & 07777
+	switch f.kind {
+	case '-':
+		return perm&f.mode == f.mode
+	case '/':
+		return f.mode == 0 || perm&f.mode != 0
+	default:
+		return perm == f.mode
+	}
+}
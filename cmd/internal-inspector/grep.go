@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	// grepBinarySniff is how much of a file is checked for NUL bytes,
+	// files containing them are taken as binary like grep does
+	grepBinarySniff = 8 * 1024
+	// grepMaxLine is the longest line searched, longer ones end the search
+	grepMaxLine = 1024 * 1024
+	// grepMaxText is the longest matching line reported, the rest is cut
+	grepMaxText = 512
+)
+
+// GrepMatch is a line of a file matching --grep
+type GrepMatch struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// compileGrep returns the expression of --grep, which is taken literally
+// with --fixed-strings
+func compileGrep(pattern string, fixed bool) (*regexp.Regexp, error) {
+	if fixed {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	return regexp.Compile(pattern)
+}
+
+// grepFile returns the lines of the text file matching re. Binary files
+// have no matches.
+func grepFile(path string, re *regexp.Regexp) ([]GrepMatch, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReaderSize(f, grepBinarySniff)
+	head, err := reader.Peek(grepBinarySniff)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return nil, nil
+	}
+
+	var matches []GrepMatch
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), grepMaxLine)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Bytes()
+		if !re.Match(text) {
+			continue
+		}
+		if len(text) > grepMaxText {
+			text = text[:grepMaxText]
+		}
+		matches = append(matches, GrepMatch{
+			Line: line,
+			Text: strings.ToValidUTF8(strings.TrimSuffix(string(text), "\r"), "�"),
+		})
+	}
+	// Matches before an overlong line are still reported
+	if err := scanner.Err(); err != nil && err != bufio.ErrTooLong {
+		return matches, err
+	}
+	return matches, nil
+}
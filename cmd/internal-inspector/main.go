@@ -2,78 +2,292 @@ package main
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/alexflint/go-arg"
-	"github.com/bmatcuk/doublestar/v4"
+	"github.com/oderwat/docker-inspector/internal/filter"
 	"io"
 	"io/fs"
 	"os"
-	"os/user"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
-	"strconv"
 	"strings"
 	"syscall"
 	"time"
 )
 
 type FileInfo struct {
-	Path      string     `json:"path"`
-	Size      int64      `json:"size"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// AllocatedSize is the disk usage based on st_blocks
+	AllocatedSize int64 `json:"allocatedSize"`
+	// Sparse is set for regular files that use less disk space than their size
+	Sparse    bool       `json:"sparse,omitempty"`
 	Mode      string     `json:"mode"`
+	Type      string     `json:"type"`
 	ModTime   *time.Time `json:"modTime,omitempty"`
 	IsDir     bool       `json:"isDir"`
 	SymlinkTo string     `json:"symlinkTo,omitempty"`
-	User      string     `json:"user"`
-	Group     string     `json:"group"`
-	MD5       string     `json:"md5,omitempty"`
+	// SymlinkBroken is set when the symlink target does not exist
+	SymlinkBroken bool `json:"symlinkBroken,omitempty"`
+	// SymlinkResolved is the absolute path the symlink points to
+	SymlinkResolved string `json:"symlinkResolved,omitempty"`
+	// SymlinkEscapes is set when the resolved target is outside of --path
+	SymlinkEscapes bool   `json:"symlinkEscapes,omitempty"`
+	User           string `json:"user"`
+	Group          string `json:"group"`
+	// UID and GID are the numeric owner ids, UserName and GroupName their
+	// names in the image (empty if unknown), User and Group combine both
+	UID       *uint32 `json:"uid,omitempty"`
+	GID       *uint32 `json:"gid,omitempty"`
+	UserName  string  `json:"userName,omitempty"`
+	GroupName string  `json:"groupName,omitempty"`
+	MD5       string  `json:"md5,omitempty"`
+	SHA256    string  `json:"sha256,omitempty"`
+	// HashSkipped is set when the file exceeded --max-hash-size
+	HashSkipped bool `json:"hashSkipped,omitempty"`
+	// Xattrs holds the extended attributes of the file (with --xattrs)
+	Xattrs map[string]string `json:"xattrs,omitempty"`
+	// Capabilities lists file capabilities in getcap notation
+	Capabilities string `json:"capabilities,omitempty"`
+	// SecurityLabel is the SELinux/AppArmor/Smack label (with --labels)
+	SecurityLabel string `json:"securityLabel,omitempty"`
+	// AttrFlags holds the lsattr letters of the inode flags (with --attr-flags)
+	AttrFlags string `json:"attrFlags,omitempty"`
+	// Entropy is the Shannon entropy in bits per byte (with --entropy)
+	Entropy float64 `json:"entropy,omitempty"`
+	// ContentType classifies the file contents (with --detect-types)
+	ContentType string `json:"contentType,omitempty"`
+	// MountType is the filesystem type of mount points (e.g. tmpfs for volumes)
+	MountType string `json:"mountType,omitempty"`
+	// Elf describes ELF binaries (with --elf)
+	Elf *ElfInfo `json:"elf,omitempty"`
+	// Matches are the lines matching --grep
+	Matches []GrepMatch `json:"matches,omitempty"`
+	// Inode, Device and Links identify hardlinked files
+	Inode  uint64 `json:"inode,omitempty"`
+	Device uint64 `json:"device,omitempty"`
+	Links  uint64 `json:"links,omitempty"`
+	// HardlinkTo names the first path of the hardlink group this file belongs to
+	HardlinkTo string `json:"hardlinkTo,omitempty"`
+	// Major and Minor are the device numbers of device nodes
+	Major uint32 `json:"major,omitempty"`
+	Minor uint32 `json:"minor,omitempty"`
 }
 
 type Args struct {
-	Path                string `arg:"--path" default:"/" help:"path to inspect"`
-	Pattern             string `arg:"--glob" help:"glob pattern for matching files (supports **/)"`
-	MD5                 bool   `arg:"--md5" help:"calculate MD5 checksums for files"`
-	NoTimes             bool   `arg:"--no-times" help:"exclude modification times from output"`
-	OutputDir           string `arg:"--output-dir" help:"extract matching files to this directory"`
-	StripComponents     int    `arg:"--strip-components" help:"strip NUMBER leading components from file names"`
-	PreserveOwner       bool   `arg:"--preserve-owner" help:"preserve user/group information when extracting"`
-	PreservePermissions bool   `arg:"--preserve-perms" help:"preserve file perms when extracting"`
+	Paths               []string   `arg:"--path,separate" help:"path to inspect (repeatable) [default: /]"`
+	PathsFrom           string     `arg:"--paths-from" help:"inspect the paths listed in this file (- for stdin) instead of walking"`
+	Chroot              string     `arg:"--chroot" help:"inspect this directory as root filesystem (paths, filters and user names refer to it)"`
+	Patterns            []string   `arg:"--glob,separate" help:"glob pattern for matching files (supports **/, repeatable)"`
+	IGlobs              []string   `arg:"--iglob,separate" help:"case insensitive glob pattern for matching files (repeatable)"`
+	IgnoreCase          bool       `arg:"--ignore-case" help:"match --glob, --exclude and --regex case insensitive"`
+	Excludes            []string   `arg:"--exclude,separate" help:"glob pattern for files to skip (repeatable)"`
+	Ignores             []string   `arg:"--ignore,separate" help:"gitignore style pattern for files to skip (repeatable)"`
+	MaxDepth            int        `arg:"--max-depth" help:"descend at most this many directory levels below --path"`
+	OneFileSystem       bool       `arg:"--one-file-system" help:"do not descend into other mounted filesystems"`
+	MinSize             *int64     `arg:"--min-size" help:"only list files with at least this many bytes"`
+	MaxSize             *int64     `arg:"--max-size" help:"only list files with at most this many bytes"`
+	NewerThan           *time.Time `arg:"--newer-than" help:"only list files modified after this time (RFC3339)"`
+	OlderThan           *time.Time `arg:"--older-than" help:"only list files modified before this time (RFC3339)"`
+	UID                 *uint32    `arg:"--uid" help:"only list files owned by this user id"`
+	User                string     `arg:"--user" help:"only list files owned by this user name"`
+	GID                 *uint32    `arg:"--gid" help:"only list files owned by this group id"`
+	Group               string     `arg:"--group" help:"only list files owned by this group name"`
+	Type                string     `arg:"--type" help:"only list entries of these types (f, d, l, c, b, p, s; comma separated)"`
+	Perm                string     `arg:"--perm" help:"only list entries matching this find style permission predicate (e.g. -4000 or /o+w)"`
+	Regexes             []string   `arg:"--regex,separate" help:"regular expression for matching the full path (repeatable)"`
+	MD5                 bool       `arg:"--md5" help:"calculate MD5 checksums for files"`
+	SHA256              bool       `arg:"--sha256" help:"calculate SHA256 checksums for files"`
+	MaxHashSize         int64      `arg:"--max-hash-size" help:"skip hashing of files larger than this many bytes"`
+	NoTimes             bool       `arg:"--no-times" help:"exclude modification times from output"`
+	Format              string     `arg:"--format" help:"output format: json or ndjson (written while walking)"`
+	Xattrs              bool       `arg:"--xattrs" help:"collect extended attributes of files"`
+	Labels              bool       `arg:"--labels" help:"collect SELinux/AppArmor security labels of files"`
+	AttrFlags           bool       `arg:"--attr-flags" help:"collect ext2/ext4 attribute flags (immutable, append only, ...)"`
+	Entropy             bool       `arg:"--entropy" help:"calculate the (sampled) Shannon entropy of files"`
+	DetectTypes         bool       `arg:"--detect-types" help:"classify file contents by their magic bytes"`
+	ContentType         string     `arg:"--content-type" help:"only list files with this content type (implies --detect-types)"`
+	Elf                 bool       `arg:"--elf" help:"report architecture, linkage and interpreter of ELF binaries"`
+	OutputDir           string     `arg:"--output-dir" help:"extract matching files to this directory"`
+	StripComponents     int        `arg:"--strip-components" help:"strip NUMBER leading components from file names"`
+	PreserveOwner       bool       `arg:"--preserve-owner" help:"preserve user/group information when extracting"`
+	PreservePermissions bool       `arg:"--preserve-perms" help:"preserve file perms when extracting"`
+	PreserveXattrs      bool       `arg:"--preserve-xattrs" help:"restore extended attributes when extracting"`
+	PreserveTimes       bool       `arg:"--preserve-times" help:"preserve modification times when extracting"`
+	OwnerMap            string     `arg:"--owner-map" help:"translate owner ids when extracting, e.g. 0:1000,u33:1001 (implies --preserve-owner)"`
+	ExtractGlobs        []string   `arg:"--extract-glob,separate" help:"only extract files matching this glob pattern (repeatable)"`
+	ExtractExcludes     []string   `arg:"--extract-exclude,separate" help:"do not extract files matching this glob pattern (repeatable)"`
+	FollowUnsafe        bool       `arg:"--follow-unsafe-symlinks" help:"extract through symlinks pointing outside of --output-dir"`
+	Progress            bool       `arg:"--progress" help:"report the progress of extracting on stderr"`
+	Overwrite           string     `arg:"--overwrite" default:"always" help:"what to do with existing files when extracting: always, never, update or backup"`
+	Tar                 bool       `arg:"--tar" help:"write matching files as tar archive to stdout instead of JSON"`
+	TarListing          bool       `arg:"--tar-listing" help:"append the JSON listing as last entry of the --tar archive"`
+	NoHardlinks         bool       `arg:"--no-hardlinks" help:"store hardlinked files as separate copies in the --tar archive"`
+	Grep                string     `arg:"--grep" help:"only list text files with lines matching this regular expression and report the lines"`
+	FixedStrings        bool       `arg:"--fixed-strings" help:"take the --grep pattern literally instead of as regular expression"`
+	SkipExtract         string     `arg:"--skip-extract" help:"do not extract the paths listed in this file (- for stdin), they are unchanged in the output directory"`
+	// owners is the parsed --owner-map
+	owners ownerMap
+	// unchanged holds the paths read by --skip-extract
+	unchanged map[string]bool
+	// grep is the compiled --grep pattern
+	grep *regexp.Regexp
 }
 
-func calculateMD5(path string) (string, error) {
+// calculateHashes returns the hex encoded MD5 and SHA256 digests of the
+// file, reading it only once. Digests not requested are returned empty.
+func calculateHashes(path string, withMD5, withSHA256 bool) (string, string, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 	defer f.Close()
 
-	hash := md5.New()
-	if _, err := io.Copy(hash, f); err != nil {
-		return "", err
+	md5Hash := md5.New()
+	sha256Hash := sha256.New()
+	var writers []io.Writer
+	if withMD5 {
+		writers = append(writers, md5Hash)
+	}
+	if withSHA256 {
+		writers = append(writers, sha256Hash)
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), f); err != nil {
+		return "", "", err
 	}
 
-	return hex.EncodeToString(hash.Sum(nil)), nil
+	md5sum, sha256sum := "", ""
+	if withMD5 {
+		md5sum = hex.EncodeToString(md5Hash.Sum(nil))
+	}
+	if withSHA256 {
+		sha256sum = hex.EncodeToString(sha256Hash.Sum(nil))
+	}
+	return md5sum, sha256sum, nil
 }
 
 func main() {
 	var args Args
-	// Set defaults
-	args.Path = "/"
-
 	arg.MustParse(&args)
+	args.Paths = walkRoots(args.Paths)
+
+	// Lists of paths are copied next to the inspector, so they are read
+	// before changing the root
+	var pathList []string
+	if args.PathsFrom != "" {
+		paths, err := filter.ReadPathList(args.PathsFrom)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		pathList = paths
+	}
+	if args.SkipExtract != "" {
+		paths, err := filter.ReadPathList(args.SkipExtract)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		args.unchanged = make(map[string]bool, len(paths))
+		for _, path := range paths {
+			args.unchanged[path] = true
+		}
+	}
+
+	// A directory mounted into the container is inspected like an image,
+	// user and group names are taken from its /etc/passwd and /etc/group
+	if args.Chroot != "" {
+		if err := syscall.Chroot(args.Chroot); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to change root to %s: %v\n", args.Chroot, err)
+			os.Exit(1)
+		}
+		if err := os.Chdir("/"); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if args.ContentType != "" {
+		args.DetectTypes = true
+	}
+	// User and group names are taken from the image, not from the
+	// inspector's own view of the system
+	loadIDNames()
+	owners, err := parseOwnerMap(args.OwnerMap)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	args.owners = owners
+	if args.OwnerMap != "" {
+		args.PreserveOwner = true
+	}
+	if args.Grep != "" {
+		re, err := compileGrep(args.Grep, args.FixedStrings)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --grep pattern: %v\n", err)
+			os.Exit(1)
+		}
+		args.grep = re
+	}
+
+	// Case insensitive matching compares lowercased patterns and paths
+	if args.IgnoreCase {
+		args.IGlobs = append(args.IGlobs, args.Patterns...)
+		args.Patterns = nil
+		args.Excludes = filter.LowerAll(args.Excludes)
+		for i := range args.Regexes {
+			args.Regexes[i] = "(?i)" + args.Regexes[i]
+		}
+	}
+	args.IGlobs = filter.LowerAll(args.IGlobs)
+
+	regexes, err := filter.CompileRegexes(args.Regexes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	ignoreRules := filter.ParseIgnoreRules(args.Ignores)
+
+	// The mount points of the container do not apply to a chroot
+	var mounts map[string]string
+	if args.Chroot == "" {
+		mounts, err = readMounts()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Cannot read mount points: %v\n", err)
+		}
+	}
+	perm, err := parsePermFilter(args.Perm)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	typeFilter, err := filter.ParseTypeFilter(args.Type)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 
 	var files []FileInfo
+	streamEncoder := json.NewEncoder(os.Stdout)
+	streamLinks := make(hardlinkTracker)
+	// root is the --path currently walked, resolvedRoot the same with its
+	// symlinks resolved like those of the links found below it
+	var root, resolvedRoot string
 	var totalSize int64
 	var dirCount, fileCount, md5Count, md5ErrorCount, skippedCount int
 
-	err := filepath.Walk(args.Path, func(path string, info fs.FileInfo, err error) error {
+	visit := func(path string, info fs.FileInfo, err error) error {
 		// Handle path errors gracefully
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Warning: Cannot access %s: %v\n", path, err)
 			skippedCount++
-			if info.IsDir() {
+			if info != nil && info.IsDir() {
 				return filepath.SkipDir
 			}
 			return nil
@@ -81,6 +295,7 @@ func main() {
 
 		// We always need to skip some directories
 		if path == "/inspect-target" ||
+			path == "/inspect-input" ||
 			path == "/proc" ||
 			path == "/sys" ||
 			path == "/dev" {
@@ -92,20 +307,108 @@ func main() {
 		if path == "/inspect" {
 			return nil
 		}
-		// Pattern matching if specified
-		if args.Pattern != "" {
-			match, err := doublestar.Match(args.Pattern, path)
+		// Mounted files like /etc/hosts are not part of the image
+		mountType, isMount := mounts[path]
+		if isMount && args.OneFileSystem && !info.IsDir() {
+			return nil
+		}
+		// Ignored directories are pruned, like git does not look into them
+		if filter.IsIgnored(ignoreRules, path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Excluded directories are pruned entirely
+		if len(args.Excludes) > 0 {
+			excludePath := path
+			if args.IgnoreCase {
+				excludePath = strings.ToLower(path)
+			}
+			excluded, err := filter.MatchesAny(args.Excludes, excludePath)
 			if err != nil {
-				return fmt.Errorf("invalid pattern: %v", err)
+				return err
+			}
+			if excluded {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		// Pattern matching if specified, globs and regexes are alternatives
+		if len(args.Patterns) > 0 || len(args.IGlobs) > 0 || len(regexes) > 0 {
+			match, err := filter.MatchesAny(args.Patterns, path)
+			if err != nil {
+				return err
 			}
 			if !match {
+				// --iglob patterns were lowercased already
+				if match, err = filter.MatchesAny(args.IGlobs, strings.ToLower(path)); err != nil {
+					return err
+				}
+			}
+			if !match && !filter.MatchesAnyRegex(regexes, path) {
 				return nil
 			}
 		}
+		if typeFilter != nil && !typeFilter[fileType(info.Mode())] {
+			return nil
+		}
+		if perm != nil && !perm.matches(info) {
+			return nil
+		}
+		// Size filters select files only, directory sizes are meaningless
+		if args.MinSize != nil || args.MaxSize != nil {
+			if info.IsDir() ||
+				(args.MinSize != nil && info.Size() < *args.MinSize) ||
+				(args.MaxSize != nil && info.Size() > *args.MaxSize) {
+				return nil
+			}
+		}
+		if (args.NewerThan != nil && !info.ModTime().After(*args.NewerThan)) ||
+			(args.OlderThan != nil && !info.ModTime().Before(*args.OlderThan)) {
+			return nil
+		}
+		if !matchesOwner(args, info) {
+			return nil
+		}
+		// Sniff the content type of regular files and filter on it
+		contentType := ""
+		if args.DetectTypes && info.Mode().IsRegular() {
+			if contentType, err = detectContentType(path); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: Cannot detect type of %s: %v\n", path, err)
+			}
+		}
+		if args.ContentType != "" && !matchesContentType(contentType, args.ContentType) {
+			return nil
+		}
+		// Searching the contents comes last as it is the most expensive filter
+		var matches []GrepMatch
+		if args.grep != nil {
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			if matches, err = grepFile(path, args.grep); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: Cannot search %s: %v\n", path, err)
+			}
+			if len(matches) == 0 {
+				return nil
+			}
+		}
+
 		// Get symlink target if it's a symlink
 		symlinkTo := ""
+		symlinkBroken := false
 		if info.Mode()&os.ModeSymlink != 0 {
 			symlinkTo, _ = os.Readlink(path)
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				symlinkBroken = true
+			}
+		}
+		symlinkResolved := ""
+		if symlinkTo != "" {
+			symlinkResolved = resolveSymlink(path, symlinkTo)
 		}
 
 		// Count files and directories
@@ -117,21 +420,36 @@ func main() {
 
 		totalSize += info.Size()
 
-		// Get user and group information
-		userName, groupName, err := getUserGroupNames(info)
-		if err != nil {
-			userName = "unknown"
-			groupName = "unknown"
+		fileInfo := FileInfo{
+			Path:            path,
+			Size:            info.Size(),
+			Mode:            info.Mode().String(),
+			Type:            fileType(info.Mode()),
+			IsDir:           info.IsDir(),
+			SymlinkTo:       symlinkTo,
+			SymlinkBroken:   symlinkBroken,
+			SymlinkResolved: symlinkResolved,
+			SymlinkEscapes:  symlinkResolved != "" && !isBelow(symlinkResolved, resolvedRoot),
+			User:            "unknown",
+			Group:           "unknown",
+			ContentType:     contentType,
+			MountType:       mountType,
+			Matches:         matches,
 		}
 
-		fileInfo := FileInfo{
-			Path:      path,
-			Size:      info.Size(),
-			Mode:      info.Mode().String(),
-			IsDir:     info.IsDir(),
-			SymlinkTo: symlinkTo,
-			User:      userName,
-			Group:     groupName,
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid, gid := stat.Uid, stat.Gid
+			fileInfo.UID, fileInfo.GID = &uid, &gid
+			fileInfo.UserName, fileInfo.GroupName = userNames[uid], groupNames[gid]
+			fileInfo.User, fileInfo.Group = ownerString(userNames, uid), ownerString(groupNames, gid)
+			fileInfo.Inode = stat.Ino
+			fileInfo.Device = uint64(stat.Dev)
+			fileInfo.Links = uint64(stat.Nlink)
+			fileInfo.AllocatedSize = stat.Blocks * 512
+			fileInfo.Sparse = info.Mode().IsRegular() && fileInfo.AllocatedSize < info.Size()
+			if info.Mode()&os.ModeDevice != 0 {
+				fileInfo.Major, fileInfo.Minor = deviceNumbers(uint64(stat.Rdev))
+			}
 		}
 
 		if !args.NoTimes {
@@ -139,25 +457,122 @@ func main() {
 			fileInfo.ModTime = &modTime
 		}
 
-		// Calculate MD5 if requested and file is not a directory
-		if args.MD5 && !info.IsDir() && info.Size() > 0 && symlinkTo == "" {
-			if md5sum, err := calculateMD5(path); err == nil {
+		if args.Elf && info.Mode().IsRegular() && info.Size() > 0 {
+			if elfInfo, err := getElfInfo(path); err == nil {
+				fileInfo.Elf = elfInfo
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: Cannot parse ELF file %s: %v\n", path, err)
+			}
+		}
+
+		// File capabilities are cheap to read and a common source of trouble
+		if info.Mode().IsRegular() {
+			if caps, err := getCapabilities(path); err == nil {
+				fileInfo.Capabilities = caps
+			}
+		}
+
+		if args.AttrFlags && (info.Mode().IsRegular() || info.IsDir()) {
+			if flags, err := getAttrFlags(path); err == nil {
+				fileInfo.AttrFlags = flags
+			}
+		}
+
+		if args.Labels && symlinkTo == "" {
+			if label, err := getSecurityLabel(path); err == nil {
+				fileInfo.SecurityLabel = label
+			}
+		}
+
+		// Symlinks are skipped as the xattr syscalls would follow them
+		if args.Xattrs && symlinkTo == "" {
+			if xattrs, err := getXattrs(path); err == nil {
+				fileInfo.Xattrs = xattrs
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: Cannot read xattrs of %s: %v\n", path, err)
+			}
+		}
+
+		if args.Entropy && info.Mode().IsRegular() && info.Size() > 0 {
+			if entropy, err := calculateEntropy(path, info.Size()); err == nil {
+				fileInfo.Entropy = entropy
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: Cannot calculate entropy of %s: %v\n", path, err)
+			}
+		}
+
+		// Calculate checksums if requested and file is not a directory
+		if (args.MD5 || args.SHA256) && !info.IsDir() && info.Size() > 0 && symlinkTo == "" {
+			if args.MaxHashSize > 0 && info.Size() > args.MaxHashSize {
+				fileInfo.HashSkipped = true
+			} else if md5sum, sha256sum, err := calculateHashes(path, args.MD5, args.SHA256); err == nil {
 				fileInfo.MD5 = md5sum
+				fileInfo.SHA256 = sha256sum
 				md5Count++
 			} else {
 				md5ErrorCount++
-				fileInfo.MD5 = fmt.Sprintf("error: %v", err)
+				if args.MD5 {
+					fileInfo.MD5 = fmt.Sprintf("error: %v", err)
+				}
+				if args.SHA256 {
+					fileInfo.SHA256 = fmt.Sprintf("error: %v", err)
+				}
+			}
+		}
+
+		// NDJSON is written right away in walk order: the entries of a
+		// directory are sorted, but "/a/b" still comes before "/a-b" and the
+		// --path roots follow each other, so it is not sorted by path
+		if args.Format == "ndjson" {
+			streamLinks.mark(&fileInfo)
+			if err := streamEncoder.Encode(fileInfo); err != nil {
+				return err
+			}
+			if args.OutputDir == "" {
+				return nil
 			}
 		}
 
 		files = append(files, fileInfo)
 		return nil
-	})
+	}
 
-	// Change the error handling at the Walk level
-	if err != nil && !os.IsPermission(err) && !os.IsNotExist(err) {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+	if args.PathsFrom != "" {
+		// An explicit list of paths is inspected without walking
+		root, resolvedRoot = "/", "/"
+		for _, path := range pathList {
+			info, err := os.Lstat(path)
+			if err := visit(path, info, err); err != nil && err != filepath.SkipDir {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		args.Paths = nil
+	}
+
+	for _, root = range args.Paths {
+		resolvedRoot = resolvePath(root)
+		err = filepath.Walk(root, func(path string, info fs.FileInfo, err error) error {
+			result := visit(path, info, err)
+			if result != nil || info == nil || !info.IsDir() {
+				return result
+			}
+			// Stop descending once the depth limit is reached
+			if args.MaxDepth > 0 && pathDepth(root, path) >= args.MaxDepth {
+				return filepath.SkipDir
+			}
+			// Mount points are listed but not descended into
+			if _, isMount := mounts[path]; isMount && args.OneFileSystem && path != "/" {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+
+		// Change the error handling at the Walk level
+		if err != nil && !os.IsPermission(err) && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
 	// Sort by path for consistent output
@@ -165,41 +580,145 @@ func main() {
 		return files[i].Path < files[j].Path
 	})
 
+	markHardlinks(files)
+
 	// If output directory is specified, copy matching files
 	if args.OutputDir != "" {
-		for _, file := range files {
-			if file.IsDir {
-				continue // Skip directories, they'll be created as needed
-			}
-
-			destPath := getDestPath(file.Path, args.StripComponents)
-			if destPath == "" {
-				continue // Skip if all components were stripped
-			}
+		extractFiles(files, args)
+	}
 
-			fullDestPath := filepath.Join(args.OutputDir, destPath)
+	if args.Tar {
+		if err := writeTar(os.Stdout, files, args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-			info, err := os.Lstat(file.Path)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Warning: Cannot stat %s: %v\n", file.Path, err)
-				continue
-			}
+	if args.Format != "ndjson" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(files)
+	}
+}
 
-			if err := copyFile(file.Path, fullDestPath, info,
-				args.PreservePermissions,
-				args.PreserveOwner); err != nil {
-				fmt.Fprintf(os.Stderr, "Warning: Failed to copy %s: %v\n", file.Path, err)
-				continue
+// walkRoots cleans the given paths and drops those inside of another one,
+// so no file is reported twice
+func walkRoots(paths []string) []string {
+	if len(paths) == 0 {
+		return []string{"/"}
+	}
+	var roots []string
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		nested := false
+		for _, other := range paths {
+			other = filepath.Clean(other)
+			if other != path && isBelow(path, other) {
+				nested = true
+				break
 			}
 		}
+		if !nested && !slices.Contains(roots, path) {
+			roots = append(roots, path)
+		}
+	}
+	return roots
+}
+
+// pathDepth returns the number of path components of path below root
+func pathDepth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
 	}
+	return strings.Count(rel, "/") + 1
+}
 
-	encoder := json.NewEncoder(os.Stdout)
-	encoder.SetIndent("", "  ")
-	encoder.Encode(files)
+// resolveSymlink returns the absolute path a symlink points to. Chains of
+// links are followed as long as they exist, broken links are resolved lexically
+// from their resolved directory.
+func resolveSymlink(path, target string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	if filepath.IsAbs(target) {
+		return filepath.Clean(target)
+	}
+	return filepath.Join(resolvePath(filepath.Dir(path)), target)
+}
+
+// resolvePath returns path with its symlinks resolved, like /usr/bin for
+// /bin in images with a merged /usr, or path itself if it can't be resolved
+func resolvePath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return filepath.Clean(path)
+}
+
+// isBelow reports whether path is root or inside of root
+func isBelow(path, root string) bool {
+	root = filepath.Clean(root)
+	if root == "/" {
+		return true
+	}
+	return path == root || strings.HasPrefix(path, root+"/")
+}
+
+// fileType names the type of a file like find -type does, spelled out
+func fileType(mode fs.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "dir"
+	case mode&os.ModeSymlink != 0:
+		return "symlink"
+	case mode&os.ModeCharDevice != 0:
+		return "chardev"
+	case mode&os.ModeDevice != 0:
+		return "blockdev"
+	case mode&os.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	default:
+		return "file"
+	}
 }
 
-func copyFile(src string, dest string, info fs.FileInfo, preservePerms, preserveUser bool) error {
+// deviceNumbers splits a Linux dev_t into its major and minor numbers
+func deviceNumbers(rdev uint64) (uint32, uint32) {
+	major := uint32((rdev>>8)&0xfff) | uint32((rdev>>32)&^0xfff)
+	minor := uint32(rdev&0xff) | uint32((rdev>>12)&^0xff)
+	return major, minor
+}
+
+// hardlinkTracker remembers the first path of every hardlink group
+type hardlinkTracker map[[2]uint64]string
+
+// mark points every additional member of a hardlink group to the first
+// path of that group, so sizes are not counted twice
+func (t hardlinkTracker) mark(file *FileInfo) {
+	if file.IsDir || file.SymlinkTo != "" || file.Links < 2 {
+		return
+	}
+	key := [2]uint64{file.Device, file.Inode}
+	if path, ok := t[key]; ok {
+		file.HardlinkTo = path
+	} else {
+		t[key] = file.Path
+	}
+}
+
+// markHardlinks marks the hardlinks in a list of files sorted by path
+func markHardlinks(files []FileInfo) {
+	tracker := make(hardlinkTracker)
+	for i := range files {
+		tracker.mark(&files[i])
+	}
+}
+
+func copyFile(src string, dest string, info fs.FileInfo, preservePerms, preserveUser, preserveTimes bool, owners ownerMap) error {
 	// Create destination directory if it doesn't exist
 	destDir := filepath.Dir(dest)
 	if err := os.MkdirAll(destDir, 0755); err != nil {
@@ -228,15 +747,23 @@ func copyFile(src string, dest string, info fs.FileInfo, preservePerms, preserve
 	}
 	defer destFile.Close()
 
-	if _, err := io.Copy(destFile, srcFile); err != nil {
-		return fmt.Errorf("failed to copy file contents: %v", err)
-	}
-
 	// Get original file's stats
 	stat, ok := info.Sys().(*syscall.Stat_t)
 	if !ok {
 		return fmt.Errorf("failed to get stat info")
 	}
+	uid := owners.uid(int(stat.Uid))
+	gid := owners.gid(int(stat.Gid))
+
+	// Sparse files keep their holes instead of being filled with zeros
+	if stat.Blocks*512 < info.Size() {
+		err = copySparse(destFile, srcFile, info.Size())
+	} else {
+		_, err = io.Copy(destFile, srcFile)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy file contents: %v", err)
+	}
 
 	if preservePerms {
 		//fmt.Fprintf(os.Stderr, "Debug: Setting mode on %s to %s\n", dest, info.Mode())
@@ -246,22 +773,26 @@ func copyFile(src string, dest string, info fs.FileInfo, preservePerms, preserve
 	}
 
 	if preserveUser {
-		uid := int(stat.Uid)
-		gid := int(stat.Gid)
 		//fmt.Fprintf(os.Stderr, "Debug: Attempting to set ownership on %s to %d:%d\n", dest, uid, gid)
 		if err := os.Chown(dest, uid, gid); err != nil {
 			fmt.Fprintf(os.Stderr, "Warning: Could not preserve ownership of %s: %v\n", dest, err)
 		}
 	}
 
+	if preserveTimes {
+		if err := os.Chtimes(dest, info.ModTime(), info.ModTime()); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Could not preserve modification time of %s: %v\n", dest, err)
+		}
+	}
+
 	// Verify final state if debugging
 	if destInfo, err := os.Lstat(dest); err == nil {
 		//fmt.Fprintf(os.Stderr, "Debug: Final mode: %s\n", destInfo.Mode())
 		if destStat, ok := destInfo.Sys().(*syscall.Stat_t); ok {
 			//fmt.Fprintf(os.Stderr, "Debug: Final uid:gid = %d:%d\n", destStat.Uid, destStat.Gid)
-			if destStat.Uid != uint32(stat.Uid) || destStat.Gid != uint32(stat.Gid) {
+			if destStat.Uid != uint32(uid) || destStat.Gid != uint32(gid) {
 				fmt.Fprintf(os.Stderr, "Warning: Final ownership is %d:%d but %d:%d was expected\n",
-					destStat.Uid, destStat.Gid, stat.Uid, stat.Gid)
+					destStat.Uid, destStat.Gid, uid, gid)
 			}
 		}
 	}
@@ -278,34 +809,11 @@ func getDestPath(sourcePath string, stripComponents int) string {
 		return ""
 	}
 
-	return "/" + filepath.Join(parts[stripComponents:]...)
-}
-
-// Add a helper function to get user and group names with IDs
-func getUserGroupNames(info fs.FileInfo) (string, string, error) {
-	stat, ok := info.Sys().(*syscall.Stat_t)
-	if !ok {
-		return "", "", fmt.Errorf("failed to get stat info")
-	}
-
-	uid := stat.Uid
-	gid := stat.Gid
-
-	// Try to lookup user
-	userName := strconv.FormatUint(uint64(uid), 10) // Default to just the ID
-	if u, err := user.LookupId(userName); err == nil {
-		userName = fmt.Sprintf("%s(%d)", u.Username, uid)
-	} else {
-		userName = fmt.Sprintf("(%d)", uid) // Just ID in parentheses if no name found
-	}
-
-	// Try to lookup group
-	groupName := strconv.FormatUint(uint64(gid), 10) // Default to just the ID
-	if g, err := user.LookupGroupId(groupName); err == nil {
-		groupName = fmt.Sprintf("%s(%d)", g.Name, gid)
-	} else {
-		groupName = fmt.Sprintf("(%d)", gid) // Just ID in parentheses if no name found
+	// Cleaning a rooted path resolves ".." without ever leaving the root,
+	// so the result can not point outside of the output directory
+	destPath := filepath.Join(append([]string{"/"}, parts[stripComponents:]...)...)
+	if destPath == "/" {
+		return ""
 	}
-
-	return userName, groupName, nil
+	return destPath
 }
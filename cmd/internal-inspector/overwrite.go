@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+)
+
+// checkOverwrite decides by the --overwrite policy whether dest may be
+// written for the source file. An existing file is removed or renamed
+// (backup), so writing never follows a symlink left at dest.
+func checkOverwrite(src string, info fs.FileInfo, dest, policy string) (bool, error) {
+	existing, err := os.Lstat(dest)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	switch policy {
+	case "never":
+		return false, nil
+	case "update":
+		if !isOutdated(src, info, dest, existing) {
+			return false, nil
+		}
+	case "backup":
+		return true, os.Rename(dest, dest+"~")
+	}
+	return true, os.Remove(dest)
+}
+
+// isOutdated reports whether the extracted dest differs from src. Files
+// with equal size and modification time are taken as unchanged, like
+// rsync does, others are compared by their SHA256.
+func isOutdated(src string, info fs.FileInfo, dest string, existing fs.FileInfo) bool {
+	switch {
+	case info.Mode().Type() != existing.Mode().Type():
+		return true
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err1 := os.Readlink(src)
+		existingTarget, err2 := os.Readlink(dest)
+		return err1 != nil || err2 != nil || target != existingTarget
+	case !info.Mode().IsRegular() || info.Size() != existing.Size():
+		return true
+	case info.ModTime().Equal(existing.ModTime()):
+		return false
+	}
+	_, srcSum, err := calculateHashes(src, false, true)
+	if err != nil {
+		return true
+	}
+	_, destSum, err := calculateHashes(dest, false, true)
+	return err != nil || srcSum != destSum
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// idNames maps the numeric ids of a passwd or group file to their names
+type idNames map[uint32]string
+
+// userNames and groupNames are read from the image once, after --chroot
+var userNames, groupNames idNames
+
+// loadIDNames reads the passwd and group files of the inspected root
+func loadIDNames() {
+	userNames = readIDNames("/etc/passwd")
+	groupNames = readIDNames("/etc/group")
+}
+
+// readIDNames parses a passwd or group file, which have the name in the
+// first and the id in the third field. Like getpwuid the first entry of an
+// id wins. A missing file (e.g. in distroless images) has no names.
+func readIDNames(path string) idNames {
+	names := make(idNames)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Warning: Cannot read %s: %v\n", path, err)
+		}
+		return names
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		if _, ok := names[uint32(id)]; !ok {
+			names[uint32(id)] = fields[0]
+		}
+	}
+	return names
+}
+
+// ownerString formats an owner as "name(id)" or "(id)" without a name
+func ownerString(names idNames, id uint32) string {
+	return fmt.Sprintf("%s(%d)", names[id], id)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// Whence values of lseek for finding data and holes in sparse files
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// copySparse copies only the data regions of src to dest and leaves the
+// holes in between unallocated. Filesystems without SEEK_DATA support get
+// a plain copy.
+func copySparse(dest, src *os.File, size int64) error {
+	var offset int64
+	for offset < size {
+		data, err := src.Seek(offset, seekData)
+		if errors.Is(err, syscall.ENXIO) {
+			break // only a hole is left
+		}
+		if errors.Is(err, syscall.EINVAL) && offset == 0 {
+			_, err = io.Copy(dest, src)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		hole, err := src.Seek(data, seekHole)
+		if err != nil {
+			return err
+		}
+		if _, err := src.Seek(data, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := dest.Seek(data, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(dest, src, hole-data); err != nil {
+			return err
+		}
+		offset = hole
+	}
+	// Trailing holes are created by extending the file
+	return dest.Truncate(size)
+}
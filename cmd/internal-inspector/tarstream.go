@@ -0,0 +1,147 @@
+package main
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/oderwat/docker-inspector/internal/xattr"
+)
+
+// writeTar streams the files as tar archive to w. Ownership, modes and
+// symlinks are kept in the headers, hardlinks are written as links to the
+// first path of their group (unless --no-hardlinks) and xattrs (if
+// collected) as PAX records. With --tar-listing the JSON listing of the
+// files follows as last entry.
+func writeTar(w io.Writer, files []FileInfo, args Args) error {
+	tw := tar.NewWriter(w)
+	for _, file := range files {
+		name := tarName(file.Path, args.StripComponents)
+		if name == "" || !isExtracted(file, args) || args.unchanged[file.Path] {
+			continue
+		}
+		info, err := os.Lstat(file.Path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Cannot stat %s: %v\n", file.Path, err)
+			continue
+		}
+		// Sockets can not be stored in a tar archive
+		if file.Type == "socket" {
+			fmt.Fprintf(os.Stderr, "Warning: Skipping socket %s\n", file.Path)
+			continue
+		}
+
+		header, err := tar.FileInfoHeader(info, file.SymlinkTo)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Cannot create tar header for %s: %v\n", file.Path, err)
+			continue
+		}
+		header.Name = name
+		if file.IsDir {
+			header.Name += "/"
+		}
+		header.Uname = file.UserName
+		header.Gname = file.GroupName
+		if file.HardlinkTo != "" && !args.NoHardlinks {
+			if target := tarName(file.HardlinkTo, args.StripComponents); target != "" {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = target
+				header.Size = 0
+			}
+		}
+		if len(file.Xattrs) > 0 {
+			header.PAXRecords = make(map[string]string)
+			for key, value := range file.Xattrs {
+				data, err := xattr.DecodeValue(value)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: Invalid xattr %s of %s: %v\n", key, file.Path, err)
+					continue
+				}
+				header.PAXRecords["SCHILY.xattr."+key] = string(data)
+			}
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write tar header for %s: %v", file.Path, err)
+		}
+		if header.Typeflag == tar.TypeReg && header.Size > 0 {
+			if err := copyToTar(tw, file.Path, header.Size); err != nil {
+				return err
+			}
+		}
+	}
+	if args.TarListing {
+		if err := writeTarListing(tw, files); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// tarListingRecord is the PAX record marking the listing entry, so it can
+// not be confused with a file of the image
+const tarListingRecord = "DOCKERINSPECTOR.listing"
+
+// writeTarListing adds the JSON listing as entry, the host reads it while
+// unpacking the files
+func writeTarListing(tw *tar.Writer, files []FileInfo) error {
+	if files == nil {
+		files = []FileInfo{}
+	}
+	data, err := json.Marshal(files)
+	if err != nil {
+		return fmt.Errorf("failed to encode listing: %v", err)
+	}
+	header := &tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       ".inspect-listing.json",
+		Mode:       0644,
+		Size:       int64(len(data)),
+		PAXRecords: map[string]string{tarListingRecord: "1"},
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write listing header: %v", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write listing: %v", err)
+	}
+	return nil
+}
+
+// copyToTar writes exactly size bytes of the file, so a file that changed
+// while inspecting can not corrupt the archive
+func copyToTar(tw *tar.Writer, path string, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	n, err := io.Copy(tw, io.LimitReader(f, size))
+	if err != nil {
+		return fmt.Errorf("failed to copy %s: %v", path, err)
+	}
+	if n < size {
+		fmt.Fprintf(os.Stderr, "Warning: %s shrank while archiving, padding with zeros\n", path)
+		if _, err := io.Copy(tw, io.LimitReader(zeroReader{}, size-n)); err != nil {
+			return fmt.Errorf("failed to pad %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// tarName returns the relative archive name of path or "" if all of its
+// components were stripped
+func tarName(path string, stripComponents int) string {
+	return strings.TrimPrefix(getDestPath(path, stripComponents), "/")
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
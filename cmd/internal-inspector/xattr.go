@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"syscall"
+
+	"github.com/oderwat/docker-inspector/internal/xattr"
+)
+
+// getXattrs returns all extended attributes of path. Binary values are
+// encoded as "0x<hex>" the same way getfattr does it.
+func getXattrs(path string) (map[string]string, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := make(map[string]string)
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := getXattr(path, string(name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read xattr %s: %v", name, err)
+		}
+		xattrs[string(name)] = xattr.EncodeValue(value)
+	}
+	return xattrs, nil
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	value := make([]byte, size)
+	size, err = syscall.Getxattr(path, name, value)
+	if err != nil {
+		return nil, err
+	}
+	return value[:size], nil
+}
+
+// setXattrs restores extended attributes collected by getXattrs
+func setXattrs(path string, xattrs map[string]string) error {
+	for name, value := range xattrs {
+		data, err := xattr.DecodeValue(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for xattr %s: %v", name, err)
+		}
+		if err := syscall.Setxattr(path, name, data, 0); err != nil {
+			return fmt.Errorf("failed to set xattr %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// securityLabelXattrs lists the xattrs used by the Linux security modules
+// to label files, in order of preference
+var securityLabelXattrs = []string{
+	"security.selinux",
+	"security.apparmor",
+	"security.SMACK64",
+}
+
+// getSecurityLabel returns the LSM label (e.g. SELinux context) of path
+func getSecurityLabel(path string) (string, error) {
+	for _, name := range securityLabelXattrs {
+		value, err := getXattr(path, name)
+		if err == nil && len(value) > 0 {
+			return strings.TrimRight(string(value), "\x00"), nil
+		}
+	}
+	return "", nil
+}
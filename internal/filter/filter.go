@@ -0,0 +1,137 @@
+// Package filter implements the file selection of the inspector: globs,
+// regexes, gitignore style ignore rules and find style type filters. The
+// inspector and the listing of images read without a container runtime
+// share it, so both select the same files.
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// MatchesAny reports whether path matches at least one of the glob patterns
+func MatchesAny(patterns []string, path string) (bool, error) {
+	for _, pattern := range patterns {
+		match, err := doublestar.Match(pattern, path)
+		if err != nil {
+			return false, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// LowerAll returns the patterns in lower case, for matching case
+// insensitive against lowered paths
+func LowerAll(patterns []string) []string {
+	lowered := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		lowered[i] = strings.ToLower(pattern)
+	}
+	return lowered
+}
+
+// CompileRegexes compiles the --regex expressions
+func CompileRegexes(exprs []string) ([]*regexp.Regexp, error) {
+	var regexes []*regexp.Regexp
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %v", expr, err)
+		}
+		regexes = append(regexes, re)
+	}
+	return regexes, nil
+}
+
+// MatchesAnyRegex reports whether path matches at least one of the regexes
+func MatchesAnyRegex(regexes []*regexp.Regexp, path string) bool {
+	for _, re := range regexes {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// IgnoreRule is a single gitignore style pattern converted to a glob
+type IgnoreRule struct {
+	glob    string
+	negate  bool
+	dirOnly bool
+}
+
+// ParseIgnoreRules converts gitignore style patterns into ignore rules.
+// Patterns are relative to the root of the image.
+func ParseIgnoreRules(patterns []string) []IgnoreRule {
+	var rules []IgnoreRule
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		rule := IgnoreRule{}
+		if strings.HasPrefix(pattern, "!") {
+			rule.negate = true
+			pattern = pattern[1:]
+		}
+		if strings.HasSuffix(pattern, "/") {
+			rule.dirOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+		// Patterns without a slash match at any level
+		if strings.Contains(pattern, "/") {
+			rule.glob = "/" + strings.TrimPrefix(pattern, "/")
+		} else {
+			rule.glob = "/**/" + pattern
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// IsIgnored applies the rules like git does: the last matching rule wins
+func IsIgnored(rules []IgnoreRule, path string, isDir bool) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if match, _ := doublestar.Match(rule.glob, path); match {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// findTypes maps the find -type letters to our file type names
+var findTypes = map[string]string{
+	"f": "file",
+	"d": "dir",
+	"l": "symlink",
+	"c": "chardev",
+	"b": "blockdev",
+	"p": "fifo",
+	"s": "socket",
+}
+
+// ParseTypeFilter turns a find style type list like "f,l" into a set of file types
+func ParseTypeFilter(spec string) (map[string]bool, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	types := make(map[string]bool)
+	for _, letter := range strings.Split(spec, ",") {
+		name, ok := findTypes[strings.TrimSpace(letter)]
+		if !ok {
+			return nil, fmt.Errorf("invalid type %q (use f, d, l, c, b, p or s)", letter)
+		}
+		types[name] = true
+	}
+	return types, nil
+}
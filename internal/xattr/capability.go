@@ -0,0 +1,98 @@
+// Package xattr decodes the values of extended attributes the same way for
+// the inspector and for images read without a container runtime.
+package xattr
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// capabilityNames maps capability numbers to their names (linux/capability.h)
+var capabilityNames = []string{
+	"cap_chown", "cap_dac_override", "cap_dac_read_search", "cap_fowner",
+	"cap_fsetid", "cap_kill", "cap_setgid", "cap_setuid", "cap_setpcap",
+	"cap_linux_immutable", "cap_net_bind_service", "cap_net_broadcast",
+	"cap_net_admin", "cap_net_raw", "cap_ipc_lock", "cap_ipc_owner",
+	"cap_sys_module", "cap_sys_rawio", "cap_sys_chroot", "cap_sys_ptrace",
+	"cap_sys_pacct", "cap_sys_admin", "cap_sys_boot", "cap_sys_nice",
+	"cap_sys_resource", "cap_sys_time", "cap_sys_tty_config", "cap_mknod",
+	"cap_lease", "cap_audit_write", "cap_audit_control", "cap_setfcap",
+	"cap_mac_override", "cap_mac_admin", "cap_syslog", "cap_wake_alarm",
+	"cap_block_suspend", "cap_audit_read", "cap_perfmon", "cap_bpf",
+	"cap_checkpoint_restore",
+}
+
+const (
+	vfsCapRevisionMask   = 0xFF000000
+	vfsCapRevision1      = 0x01000000
+	vfsCapRevision2      = 0x02000000
+	vfsCapRevision3      = 0x03000000
+	vfsCapFlagsEffective = 0x000001
+)
+
+// DecodeCapabilities parses a vfs_cap_data structure, the value of the
+// security.capability xattr, into the textual form used by getcap
+func DecodeCapabilities(data []byte) (string, error) {
+	if len(data) < 4 {
+		return "", fmt.Errorf("capability data too short")
+	}
+	magic := binary.LittleEndian.Uint32(data)
+	words := 0
+	switch magic & vfsCapRevisionMask {
+	case vfsCapRevision1:
+		words = 1
+	case vfsCapRevision2, vfsCapRevision3:
+		words = 2
+	default:
+		return "", fmt.Errorf("unknown capability revision 0x%x", magic&vfsCapRevisionMask)
+	}
+	if len(data) < 4+words*8 {
+		return "", fmt.Errorf("capability data too short")
+	}
+
+	var permitted, inheritable uint64
+	for i := 0; i < words; i++ {
+		offset := 4 + i*8
+		permitted |= uint64(binary.LittleEndian.Uint32(data[offset:])) << (32 * i)
+		inheritable |= uint64(binary.LittleEndian.Uint32(data[offset+4:])) << (32 * i)
+	}
+	effective := magic&vfsCapFlagsEffective != 0
+
+	// Group capabilities with the same flags like getcap does
+	groups := make(map[string][]string)
+	var order []string
+	for bit := 0; bit < 64; bit++ {
+		mask := uint64(1) << bit
+		if permitted&mask == 0 && inheritable&mask == 0 {
+			continue
+		}
+		flags := ""
+		if effective && permitted&mask != 0 {
+			flags += "e"
+		}
+		if inheritable&mask != 0 {
+			flags += "i"
+		}
+		if permitted&mask != 0 {
+			flags += "p"
+		}
+		if _, ok := groups[flags]; !ok {
+			order = append(order, flags)
+		}
+		groups[flags] = append(groups[flags], capabilityName(bit))
+	}
+
+	var parts []string
+	for _, flags := range order {
+		parts = append(parts, strings.Join(groups[flags], ",")+"="+flags)
+	}
+	return strings.Join(parts, " "), nil
+}
+
+func capabilityName(bit int) string {
+	if bit < len(capabilityNames) {
+		return capabilityNames[bit]
+	}
+	return fmt.Sprintf("cap_%d", bit)
+}
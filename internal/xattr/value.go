@@ -0,0 +1,27 @@
+package xattr
+
+import (
+	"encoding/hex"
+	"strings"
+	"unicode/utf8"
+)
+
+// EncodeValue keeps text values readable, binary ones are encoded as
+// "0x<hex>" the same way getfattr does it
+func EncodeValue(value []byte) string {
+	text := string(value)
+	if utf8.ValidString(text) && !strings.ContainsFunc(text, func(r rune) bool {
+		return r < ' ' && r != '\t' && r != '\n'
+	}) && !strings.HasPrefix(text, "0x") {
+		return text
+	}
+	return "0x" + hex.EncodeToString(value)
+}
+
+// DecodeValue turns a value of EncodeValue back into its bytes
+func DecodeValue(value string) ([]byte, error) {
+	if strings.HasPrefix(value, "0x") {
+		return hex.DecodeString(value[2:])
+	}
+	return []byte(value), nil
+}